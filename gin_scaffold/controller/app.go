@@ -6,6 +6,7 @@ import (
 	"gin_scaffold/dto"
 	"gin_scaffold/middleware"
 	"gin_scaffold/public"
+	"time"
 
 	"github.com/e421083458/golang_common/lib"
 	"github.com/gin-gonic/gin"
@@ -39,35 +40,22 @@ func (app *AppController) AppList(c *gin.Context) {
 	params := &dto.AppListInput{}
 	if err := params.BindingValidParams(c); err != nil {
 		middleware.ResponseError(c, 2000, err)
+		return
 	}
 	tx, err := lib.GetGormPool("default")
 	if err != nil {
 		middleware.ResponseError(c, 2001, err)
+		return
 	}
 	appinfo := &dao.App{}
 	list, total, err := appinfo.AppList(c, tx, params)
 	if err != nil {
 		middleware.ResponseError(c, 2002, err)
+		return
 	}
 	outputList := []dto.AppListItemOutput{}
 	for _, item := range list {
-		appCounter, err := public.FlowCounterHandler.GetCounter(public.FlowAppPrefix + item.AppID)
-		if err != nil {
-			middleware.ResponseError(c, 2003, err)
-			c.Abort()
-			return
-		}
-		outputList = append(outputList, dto.AppListItemOutput{
-			ID:       item.ID,
-			AppID:    item.AppID,
-			Name:     item.Name,
-			Secret:   item.Secret,
-			WhiteIPS: item.WhiteIPS,
-			Qpd:      item.Qpd,
-			Qps:      item.Qps,
-			RealQpd:  appCounter.TotalCount,
-			RealQps:  appCounter.QPS,
-		})
+		outputList = append(outputList, *appItemOutput(c, &item))
 	}
 	output := dto.AppListOutput{
 		List:  outputList,
@@ -85,22 +73,58 @@ func (app *AppController) AppList(c *gin.Context) {
 // @Accept  json
 // @Produce  json
 // @Param info query string false "关键词"
-// @Success 200 {object} middleware.Response{data=dto.App} "success"
+// @Success 200 {object} middleware.Response{data=dto.AppListItemOutput} "success"
 // @Router /app/app_detail [get]
 func (app *AppController) AppDetail(c *gin.Context) {
 	param := &dto.AppDeleteInput{}
 	if err := param.BindingValidParams(c); err != nil {
 		middleware.ResponseError(c, 2000, err)
+		return
 	}
 	tx, err := lib.GetGormPool("default")
 	if err != nil {
 		middleware.ResponseError(c, 2001, err)
+		return
 	}
 	appinfo := &dao.App{ID: param.ID}
 	if appinfo, err = appinfo.Find(c, tx, appinfo); err != nil {
 		middleware.ResponseError(c, 2002, err)
+		return
+	}
+	middleware.ResponseSuccess(c, appItemOutput(c, appinfo))
+}
+
+// 租户信息附带实时用量，直接读redis，redis不可用时用量为0
+// 设置了Qpd时当日请求量取限流实际占用的配额，不含被拒绝的请求
+func appItemOutput(c *gin.Context, item *dao.App) *dto.AppListItemOutput {
+	name := public.FlowAppPrefix + item.AppID
+	now := time.Now()
+	appCounter, err := public.QueryFlowCount(name, now)
+	if err == nil && item.Qpd > 0 {
+		appCounter.TotalCount, err = public.QueryDayQuota(name, now)
+	}
+	if err != nil {
+		public.ContextWarning(c, "_com_app_flow_count_failure", map[string]interface{}{
+			"app_id": item.AppID,
+			"err":    err.Error(),
+		})
+		appCounter = &public.FlowCountSnapshot{}
+	}
+	return &dto.AppListItemOutput{
+		ID:          item.ID,
+		AppID:       item.AppID,
+		Name:        item.Name,
+		Secret:      item.Secret,
+		WhiteIPS:    item.WhiteIPS,
+		CertSubject: item.CertSubject,
+		Qpd:         item.Qpd,
+		Qps:         item.Qps,
+		RealQpd:     appCounter.TotalCount,
+		RealQps:     appCounter.QPS,
+		CreatedAt:   item.CreatedAt,
+		UpdatedAt:   item.UpdatedAt,
+		IsDelete:    item.IsDelete,
 	}
-	middleware.ResponseSuccess(c, appinfo)
 }
 
 // AppDelete godoc
@@ -117,19 +141,24 @@ func (app *AppController) AppDelete(c *gin.Context) {
 	param := &dto.AppDeleteInput{}
 	if err := param.BindingValidParams(c); err != nil {
 		middleware.ResponseError(c, 2000, err)
+		return
 	}
 	tx, err := lib.GetGormPool("default")
 	if err != nil {
 		middleware.ResponseError(c, 2001, err)
+		return
 	}
 	appinfo := &dao.App{ID: param.ID}
 	if appinfo, err = appinfo.Find(c, tx, appinfo); err != nil {
 		middleware.ResponseError(c, 2002, err)
+		return
 	}
 	appinfo.IsDelete = 1
 	if err = appinfo.Save(c, tx); err != nil {
 		middleware.ResponseError(c, 2003, err)
+		return
 	}
+	dao.PublishConfigChange(c, dao.ConfigChangeTypeApp, dao.ConfigChangeActionDelete, appinfo.ID)
 	middleware.ResponseSuccess(c, "success")
 }
 
@@ -162,17 +191,19 @@ func (app *AppController) AppAdd(c *gin.Context) {
 	}
 	tx := lib.GORMDefaultPool
 	info := &dao.App{
-		AppID:    params.AppID,
-		Name:     params.Name,
-		Secret:   params.Secret,
-		WhiteIPS: params.WhiteIPS,
-		Qps:      params.Qps,
-		Qpd:      params.Qpd,
+		AppID:       params.AppID,
+		Name:        params.Name,
+		Secret:      params.Secret,
+		WhiteIPS:    params.WhiteIPS,
+		CertSubject: params.CertSubject,
+		Qps:         params.Qps,
+		Qpd:         params.Qpd,
 	}
 	if err := info.Save(c, tx); err != nil {
 		middleware.ResponseError(c, 2003, err)
 		return
 	}
+	dao.PublishConfigChange(c, dao.ConfigChangeTypeApp, dao.ConfigChangeActionCreate, info.ID)
 	middleware.ResponseSuccess(c, "")
 	return
 }
@@ -207,12 +238,14 @@ func (app *AppController) AppUpdate(c *gin.Context) {
 	info.Name = params.Name
 	info.Secret = params.Secret
 	info.WhiteIPS = params.WhiteIPS
+	info.CertSubject = params.CertSubject
 	info.Qps = params.Qps
 	info.Qpd = params.Qpd
 	if err := info.Save(c, lib.GORMDefaultPool); err != nil {
 		middleware.ResponseError(c, 2003, err)
 		return
 	}
+	dao.PublishConfigChange(c, dao.ConfigChangeTypeApp, dao.ConfigChangeActionUpdate, info.ID)
 	middleware.ResponseSuccess(c, "")
 	return
 }
@@ -231,14 +264,30 @@ func (app *AppController) AppStat(c *gin.Context) {
 	param := &dto.AppDeleteInput{}
 	if err := param.BindingValidParams(c); err != nil {
 		middleware.ResponseError(c, 2000, err)
+		return
 	}
 	tx, err := lib.GetGormPool("default")
 	if err != nil {
 		middleware.ResponseError(c, 2001, err)
+		return
 	}
 	appinfo := &dao.App{ID: param.ID}
 	if appinfo, err = appinfo.Find(c, tx, appinfo); err != nil {
 		middleware.ResponseError(c, 2002, err)
+		return
+	}
+	today := flowStatToday()
+	list, err := flowStatDayList(c, tx, public.FlowAppPrefix+appinfo.AppID, today.AddDate(0, 0, -1), today)
+	if err != nil {
+		middleware.ResponseError(c, 2003, err)
+		return
 	}
-	middleware.ResponseSuccess(c, &dto.AppStatOutput{})
+	middleware.ResponseSuccess(c, &dto.AppStatOutput{
+		Today:          list[1].Request,
+		Yesterday:      list[0].Request,
+		TodayError:     list[1].Error,
+		YesterdayError: list[0].Error,
+		TodayP95:       list[1].P95,
+		YesterdayP95:   list[0].P95,
+	})
 }
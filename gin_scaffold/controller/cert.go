@@ -0,0 +1,319 @@
+package controller
+
+import (
+	"encoding/pem"
+	"errors"
+	"gin_scaffold/dao"
+	"gin_scaffold/dto"
+	"gin_scaffold/middleware"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/e421083458/golang_common/lib"
+	"github.com/gin-gonic/gin"
+)
+
+type CertController struct{}
+
+func CertRegister(router *gin.RouterGroup) {
+	cert := &CertController{}
+	router.GET("/cert_list", cert.CertList)
+	router.GET("/cert_detail", cert.CertDetail)
+	router.GET("/cert_delete", cert.CertDelete)
+	router.POST("/cert_add", cert.CertAdd)
+	router.POST("/cert_issue", cert.CertIssue)
+}
+
+// CertList godoc
+// @Summary 证书列表
+// @Description 证书列表, 包含过期时间和证书覆盖的域名接入服务
+// @Tags 证书管理
+// @ID /cert/cert_list
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} middleware.Response{data=dto.CertListOutput} "success"
+// @Router /cert/cert_list [get]
+func (cert *CertController) CertList(c *gin.Context) {
+	tx, err := lib.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2001, err)
+		return
+	}
+	certInfo := &dao.Cert{}
+	list, err := certInfo.CertList(c, tx)
+	if err != nil {
+		middleware.ResponseError(c, 2002, err)
+		return
+	}
+	httpRule := &dao.HttpRule{}
+	rules, err := httpRule.DomainRuleList(c, tx)
+	if err != nil {
+		middleware.ResponseError(c, 2003, err)
+		return
+	}
+	outputList := []dto.CertListItemOutput{}
+	for _, item := range list {
+		outputList = append(outputList, certItemOutput(&item, rules))
+	}
+	middleware.ResponseSuccess(c, &dto.CertListOutput{
+		Total: int64(len(outputList)),
+		List:  outputList,
+	})
+}
+
+// CertDetail godoc
+// @Summary 证书详情
+// @Description 证书详情, 只返回证书链不返回私钥
+// @Tags 证书管理
+// @ID /cert/cert_detail
+// @Accept  json
+// @Produce  json
+// @Param id query string true "证书ID"
+// @Success 200 {object} middleware.Response{data=dto.CertDetailOutput} "success"
+// @Router /cert/cert_detail [get]
+func (cert *CertController) CertDetail(c *gin.Context) {
+	params := &dto.CertDeleteInput{}
+	if err := params.BindingValidParams(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+	tx, err := lib.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2001, err)
+		return
+	}
+	certInfo := &dao.Cert{ID: params.ID}
+	certInfo, err = certInfo.Find(c, tx, certInfo)
+	if err != nil {
+		middleware.ResponseError(c, 2002, err)
+		return
+	}
+	if certInfo.ID == 0 || certInfo.IsDelete == 1 {
+		middleware.ResponseError(c, 2003, errors.New("证书不存在"))
+		return
+	}
+	httpRule := &dao.HttpRule{}
+	rules, err := httpRule.DomainRuleList(c, tx)
+	if err != nil {
+		middleware.ResponseError(c, 2004, err)
+		return
+	}
+	output := &dto.CertDetailOutput{CertListItemOutput: certItemOutput(certInfo, rules)}
+	//磁盘证书在代理机器上，只展示代理加载后写回的域名和有效期
+	if certInfo.StoreType == dao.CertStoreTypeDisk {
+		output.Loaded = certInfo.Domains != ""
+		if !output.Loaded {
+			output.LoadError = "waiting for proxy to load certificate file"
+		}
+		middleware.ResponseSuccess(c, output)
+		return
+	}
+	keyPair, err := certInfo.LoadKeyPair()
+	if err != nil {
+		output.LoadError = err.Error()
+	} else {
+		output.Loaded = true
+		output.Subject = keyPair.Leaf.Subject.String()
+		output.Issuer = keyPair.Leaf.Issuer.String()
+		output.SerialNumber = keyPair.Leaf.SerialNumber.String()
+		output.NotBefore = keyPair.Leaf.NotBefore
+		output.NotAfter = keyPair.Leaf.NotAfter
+		output.DaysLeft, output.Expired = certDaysLeft(keyPair.Leaf.NotAfter)
+		chain := []byte{}
+		for _, der := range keyPair.Certificate {
+			chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+		}
+		output.CertPem = string(chain)
+	}
+	middleware.ResponseSuccess(c, output)
+}
+
+// CertAdd godoc
+// @Summary 上传证书
+// @Description 上传证书, store_type=0时传cert_pem和key_pem, store_type=1时传代理机器上的cert_file和key_file
+// @Tags 证书管理
+// @ID /cert/cert_add
+// @Accept  json
+// @Produce  json
+// @Param body body dto.CertAddInput true "body"
+// @Success 200 {object} middleware.Response{data=string} "success"
+// @Router /cert/cert_add [post]
+func (cert *CertController) CertAdd(c *gin.Context) {
+	params := &dto.CertAddInput{}
+	if err := params.BindingValidParams(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+	certInfo := &dao.Cert{Name: params.Name, StoreType: params.StoreType}
+	if params.StoreType == dao.CertStoreTypeDisk {
+		//文件在代理机器上，由代理加载时校验，加载失败记录日志并跳过，域名和有效期由代理写回
+		if params.CertFile == "" || params.KeyFile == "" {
+			middleware.ResponseError(c, 2001, errors.New("cert_file和key_file不能为空"))
+			return
+		}
+		certInfo.CertFile, certInfo.KeyFile = params.CertFile, params.KeyFile
+	} else {
+		if params.CertPem == "" || params.KeyPem == "" {
+			middleware.ResponseError(c, 2001, errors.New("cert_pem和key_pem不能为空"))
+			return
+		}
+		certInfo.CertPem, certInfo.KeyPem = params.CertPem, params.KeyPem
+		keyPair, err := certInfo.LoadKeyPair()
+		if err != nil {
+			middleware.ResponseError(c, 2002, err)
+			return
+		}
+		certInfo.Domains = strings.Join(dao.CertDomains(keyPair.Leaf), ",")
+		certInfo.NotBefore = keyPair.Leaf.NotBefore
+		certInfo.NotAfter = keyPair.Leaf.NotAfter
+		if certInfo.Domains == "" {
+			middleware.ResponseError(c, 2003, errors.New("证书中没有域名"))
+			return
+		}
+	}
+	tx, err := lib.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2004, err)
+		return
+	}
+	if err := certInfo.Save(c, tx); err != nil {
+		middleware.ResponseError(c, 2005, err)
+		return
+	}
+	dao.PublishConfigChange(c, dao.ConfigChangeTypeCert, dao.ConfigChangeActionCreate, certInfo.ID)
+	middleware.ResponseSuccess(c, "")
+}
+
+// CertIssue godoc
+// @Summary 签发证书
+// @Description 用内部CA为域名接入的服务立即签发或续期证书
+// @Tags 证书管理
+// @ID /cert/cert_issue
+// @Accept  json
+// @Produce  json
+// @Param body body dto.CertIssueInput true "body"
+// @Success 200 {object} middleware.Response{data=dto.CertListItemOutput} "success"
+// @Router /cert/cert_issue [post]
+func (cert *CertController) CertIssue(c *gin.Context) {
+	params := &dto.CertIssueInput{}
+	if err := params.BindingValidParams(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+	tx, err := lib.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2001, err)
+		return
+	}
+	httpRule := &dao.HttpRule{}
+	rules, err := httpRule.DomainRuleList(c, tx)
+	if err != nil {
+		middleware.ResponseError(c, 2002, err)
+		return
+	}
+	domain := strings.ToLower(params.Domain)
+	matched := false
+	for _, rule := range rules {
+		if strings.ToLower(rule.Rule) == domain {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		middleware.ResponseError(c, 2003, errors.New("没有使用该域名接入的服务"))
+		return
+	}
+	certInfo, err := dao.AutoCertManagerHandler.Ensure(c, domain, true)
+	if err != nil {
+		middleware.ResponseError(c, 2004, err)
+		return
+	}
+	middleware.ResponseSuccess(c, certItemOutput(certInfo, rules))
+}
+
+// CertDelete godoc
+// @Summary 删除证书
+// @Description 删除证书
+// @Tags 证书管理
+// @ID /cert/cert_delete
+// @Accept  json
+// @Produce  json
+// @Param id query string true "证书ID"
+// @Success 200 {object} middleware.Response{data=string} "success"
+// @Router /cert/cert_delete [get]
+func (cert *CertController) CertDelete(c *gin.Context) {
+	params := &dto.CertDeleteInput{}
+	if err := params.BindingValidParams(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+	tx, err := lib.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2001, err)
+		return
+	}
+	certInfo := &dao.Cert{ID: params.ID}
+	certInfo, err = certInfo.Find(c, tx, certInfo)
+	if err != nil {
+		middleware.ResponseError(c, 2002, err)
+		return
+	}
+	if certInfo.ID == 0 {
+		middleware.ResponseError(c, 2003, errors.New("证书不存在"))
+		return
+	}
+	certInfo.IsDelete = 1
+	if err := certInfo.Save(c, tx); err != nil {
+		middleware.ResponseError(c, 2004, err)
+		return
+	}
+	dao.PublishConfigChange(c, dao.ConfigChangeTypeCert, dao.ConfigChangeActionDelete, certInfo.ID)
+	middleware.ResponseSuccess(c, "success")
+}
+
+// 证书信息附带剩余天数和覆盖的域名接入服务
+func certItemOutput(item *dao.Cert, rules []dao.DomainRuleItem) dto.CertListItemOutput {
+	//磁盘证书在代理加载前没有域名和有效期
+	domains := []string{}
+	if item.Domains != "" {
+		domains = strings.Split(item.Domains, ",")
+	}
+	services := []dto.CertServiceOutput{}
+	for _, rule := range rules {
+		for _, domain := range domains {
+			if dao.CertDomainMatch(domain, rule.Rule) {
+				services = append(services, dto.CertServiceOutput{
+					ServiceID:   rule.ServiceID,
+					ServiceName: rule.ServiceName,
+					Rule:        rule.Rule,
+				})
+				break
+			}
+		}
+	}
+	daysLeft, expired := 0, false
+	if item.Domains != "" {
+		daysLeft, expired = certDaysLeft(item.NotAfter)
+	}
+	return dto.CertListItemOutput{
+		ID:        item.ID,
+		Name:      item.Name,
+		StoreType: item.StoreType,
+		Source:    item.Source,
+		CertFile:  item.CertFile,
+		KeyFile:   item.KeyFile,
+		Domains:   domains,
+		NotBefore: item.NotBefore,
+		NotAfter:  item.NotAfter,
+		DaysLeft:  daysLeft,
+		Expired:   expired,
+		Services:  services,
+		CreatedAt: item.CreatedAt,
+	}
+}
+
+func certDaysLeft(notAfter time.Time) (int, bool) {
+	left := time.Until(notAfter)
+	return int(math.Floor(left.Hours() / 24)), left <= 0
+}
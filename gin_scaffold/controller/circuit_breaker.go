@@ -0,0 +1,298 @@
+package controller
+
+import (
+	"errors"
+	"gin_scaffold/dao"
+	"gin_scaffold/dto"
+	"gin_scaffold/middleware"
+	"gin_scaffold/public"
+
+	"github.com/e421083458/golang_common/lib"
+	"github.com/gin-gonic/gin"
+)
+
+type CircuitBreakerController struct{}
+
+func CircuitBreakerRegister(router *gin.RouterGroup) {
+	breaker := &CircuitBreakerController{}
+	router.GET("/circuit_breaker_list", breaker.CircuitBreakerList)
+	router.GET("/circuit_breaker_detail", breaker.CircuitBreakerDetail)
+	router.GET("/circuit_breaker_delete", breaker.CircuitBreakerDelete)
+	router.POST("/circuit_breaker_add", breaker.CircuitBreakerAdd)
+	router.POST("/circuit_breaker_update", breaker.CircuitBreakerUpdate)
+}
+
+// CircuitBreakerList godoc
+// @Summary 熔断规则列表
+// @Description 熔断规则列表
+// @Tags 熔断管理
+// @ID /circuit_breaker/circuit_breaker_list
+// @Accept  json
+// @Produce  json
+// @Param service_id query string false "服务id"
+// @Param page_no query int true "页码"
+// @Param page_size query int true "每页条数"
+// @Success 200 {object} middleware.Response{data=dto.CircuitBreakerListOutput} "success"
+// @Router /circuit_breaker/circuit_breaker_list [get]
+func (breaker *CircuitBreakerController) CircuitBreakerList(c *gin.Context) {
+	params := &dto.CircuitBreakerListInput{}
+	if err := params.BindingValidParams(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+	tx, err := lib.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2001, err)
+		return
+	}
+	rule := &dao.CircuitBreakerRule{}
+	list, total, err := rule.PageList(c, tx, params)
+	if err != nil {
+		middleware.ResponseError(c, 2002, err)
+		return
+	}
+	outputList := []dto.CircuitBreakerListItemOutput{}
+	for _, item := range list {
+		serviceInfo := &dao.Serviceinfo{ID: item.ServiceID}
+		serviceInfo, err := serviceInfo.FindService(c, tx, serviceInfo)
+		if err != nil {
+			middleware.ResponseError(c, 2003, err)
+			return
+		}
+		outputList = append(outputList, circuitBreakerItemOutput(&item, serviceInfo.ServiceName))
+	}
+	middleware.ResponseSuccess(c, &dto.CircuitBreakerListOutput{
+		Total: total,
+		List:  outputList,
+	})
+}
+
+// CircuitBreakerDetail godoc
+// @Summary 熔断规则详情
+// @Description 熔断规则详情
+// @Tags 熔断管理
+// @ID /circuit_breaker/circuit_breaker_detail
+// @Accept  json
+// @Produce  json
+// @Param id query string true "熔断规则ID"
+// @Success 200 {object} middleware.Response{data=dto.CircuitBreakerListItemOutput} "success"
+// @Router /circuit_breaker/circuit_breaker_detail [get]
+func (breaker *CircuitBreakerController) CircuitBreakerDetail(c *gin.Context) {
+	params := &dto.CircuitBreakerDeleteInput{}
+	if err := params.BindingValidParams(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+	tx, err := lib.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2001, err)
+		return
+	}
+	rule := &dao.CircuitBreakerRule{ID: params.ID}
+	rule, err = rule.Find(c, tx, rule)
+	if err != nil {
+		middleware.ResponseError(c, 2002, err)
+		return
+	}
+	if rule.ID == 0 || rule.IsDelete == 1 {
+		middleware.ResponseError(c, 2003, errors.New("熔断规则不存在"))
+		return
+	}
+	serviceInfo := &dao.Serviceinfo{ID: rule.ServiceID}
+	serviceInfo, err = serviceInfo.FindService(c, tx, serviceInfo)
+	if err != nil {
+		middleware.ResponseError(c, 2004, err)
+		return
+	}
+	middleware.ResponseSuccess(c, circuitBreakerItemOutput(rule, serviceInfo.ServiceName))
+}
+
+// CircuitBreakerAdd godoc
+// @Summary 添加熔断规则
+// @Description 为http服务添加熔断规则, 每个服务只能有一条
+// @Tags 熔断管理
+// @ID /circuit_breaker/circuit_breaker_add
+// @Accept  json
+// @Produce  json
+// @Param body body dto.CircuitBreakerAddInput true "body"
+// @Success 200 {object} middleware.Response{data=string} "success"
+// @Router /circuit_breaker/circuit_breaker_add [post]
+func (breaker *CircuitBreakerController) CircuitBreakerAdd(c *gin.Context) {
+	params := &dto.CircuitBreakerAddInput{}
+	if err := params.BindingValidParams(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+	if err := checkCircuitBreakerParams(&params.CircuitBreakerParams); err != nil {
+		middleware.ResponseError(c, 2001, err)
+		return
+	}
+	tx, err := lib.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2002, err)
+		return
+	}
+	serviceInfo := &dao.Serviceinfo{ID: params.ServiceID}
+	serviceInfo, err = serviceInfo.FindService(c, tx, serviceInfo)
+	if err != nil {
+		middleware.ResponseError(c, 2003, err)
+		return
+	}
+	if serviceInfo.ID == 0 || serviceInfo.IsDelete == 1 {
+		middleware.ResponseError(c, 2004, errors.New("服务不存在"))
+		return
+	}
+	if serviceInfo.LoadType != public.LoadTypeHTTP {
+		middleware.ResponseError(c, 2005, errors.New("只支持http服务"))
+		return
+	}
+	search := &dao.CircuitBreakerRule{}
+	exist, err := search.FindByServiceID(c, tx, params.ServiceID)
+	if err != nil {
+		middleware.ResponseError(c, 2006, err)
+		return
+	}
+	if exist != nil {
+		middleware.ResponseError(c, 2007, errors.New("该服务已配置熔断规则"))
+		return
+	}
+	rule := &dao.CircuitBreakerRule{ServiceID: params.ServiceID}
+	setCircuitBreakerParams(rule, &params.CircuitBreakerParams)
+	if err := rule.Save(c, tx); err != nil {
+		middleware.ResponseError(c, 2008, err)
+		return
+	}
+	dao.PublishConfigChange(c, dao.ConfigChangeTypeService, dao.ConfigChangeActionUpdate, rule.ServiceID)
+	middleware.ResponseSuccess(c, "")
+}
+
+// CircuitBreakerUpdate godoc
+// @Summary 修改熔断规则
+// @Description 修改熔断规则, 熔断参数变化后熔断状态重新统计
+// @Tags 熔断管理
+// @ID /circuit_breaker/circuit_breaker_update
+// @Accept  json
+// @Produce  json
+// @Param body body dto.CircuitBreakerUpdateInput true "body"
+// @Success 200 {object} middleware.Response{data=string} "success"
+// @Router /circuit_breaker/circuit_breaker_update [post]
+func (breaker *CircuitBreakerController) CircuitBreakerUpdate(c *gin.Context) {
+	params := &dto.CircuitBreakerUpdateInput{}
+	if err := params.BindingValidParams(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+	if err := checkCircuitBreakerParams(&params.CircuitBreakerParams); err != nil {
+		middleware.ResponseError(c, 2001, err)
+		return
+	}
+	tx, err := lib.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2002, err)
+		return
+	}
+	rule := &dao.CircuitBreakerRule{ID: params.ID}
+	rule, err = rule.Find(c, tx, rule)
+	if err != nil {
+		middleware.ResponseError(c, 2003, err)
+		return
+	}
+	if rule.ID == 0 || rule.IsDelete == 1 {
+		middleware.ResponseError(c, 2004, errors.New("熔断规则不存在"))
+		return
+	}
+	setCircuitBreakerParams(rule, &params.CircuitBreakerParams)
+	if err := rule.Save(c, tx); err != nil {
+		middleware.ResponseError(c, 2005, err)
+		return
+	}
+	dao.PublishConfigChange(c, dao.ConfigChangeTypeService, dao.ConfigChangeActionUpdate, rule.ServiceID)
+	middleware.ResponseSuccess(c, "")
+}
+
+// CircuitBreakerDelete godoc
+// @Summary 删除熔断规则
+// @Description 删除熔断规则
+// @Tags 熔断管理
+// @ID /circuit_breaker/circuit_breaker_delete
+// @Accept  json
+// @Produce  json
+// @Param id query string true "熔断规则ID"
+// @Success 200 {object} middleware.Response{data=string} "success"
+// @Router /circuit_breaker/circuit_breaker_delete [get]
+func (breaker *CircuitBreakerController) CircuitBreakerDelete(c *gin.Context) {
+	params := &dto.CircuitBreakerDeleteInput{}
+	if err := params.BindingValidParams(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+	tx, err := lib.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2001, err)
+		return
+	}
+	rule := &dao.CircuitBreakerRule{ID: params.ID}
+	rule, err = rule.Find(c, tx, rule)
+	if err != nil {
+		middleware.ResponseError(c, 2002, err)
+		return
+	}
+	if rule.ID == 0 {
+		middleware.ResponseError(c, 2003, errors.New("熔断规则不存在"))
+		return
+	}
+	rule.IsDelete = 1
+	if err := rule.Save(c, tx); err != nil {
+		middleware.ResponseError(c, 2004, err)
+		return
+	}
+	dao.PublishConfigChange(c, dao.ConfigChangeTypeService, dao.ConfigChangeActionUpdate, rule.ServiceID)
+	middleware.ResponseSuccess(c, "success")
+}
+
+func checkCircuitBreakerParams(params *dto.CircuitBreakerParams) error {
+	if params.ErrorRate == 0 && params.SlowCallRate == 0 {
+		return errors.New("error_rate和slow_call_rate至少设置一个")
+	}
+	if params.SlowCallRate > 0 && params.SlowCallMs == 0 {
+		return errors.New("按慢调用率熔断时slow_call_ms不能为空")
+	}
+	if params.FallbackStatus > 0 && params.FallbackStatus < 200 {
+		return errors.New("fallback_status取值为200-599")
+	}
+	return nil
+}
+
+func setCircuitBreakerParams(rule *dao.CircuitBreakerRule, params *dto.CircuitBreakerParams) {
+	rule.Window = params.Window
+	rule.MinRequests = params.MinRequests
+	rule.ErrorRate = params.ErrorRate
+	rule.SlowCallRate = params.SlowCallRate
+	rule.SlowCallMs = params.SlowCallMs
+	rule.OpenTime = params.OpenTime
+	rule.HalfOpenRequests = params.HalfOpenRequests
+	rule.FallbackStatus = params.FallbackStatus
+	rule.FallbackContentType = params.FallbackContentType
+	rule.FallbackBody = params.FallbackBody
+}
+
+func circuitBreakerItemOutput(rule *dao.CircuitBreakerRule, serviceName string) dto.CircuitBreakerListItemOutput {
+	return dto.CircuitBreakerListItemOutput{
+		ID:          rule.ID,
+		ServiceID:   rule.ServiceID,
+		ServiceName: serviceName,
+		CircuitBreakerParams: dto.CircuitBreakerParams{
+			Window:              rule.Window,
+			MinRequests:         rule.MinRequests,
+			ErrorRate:           rule.ErrorRate,
+			SlowCallRate:        rule.SlowCallRate,
+			SlowCallMs:          rule.SlowCallMs,
+			OpenTime:            rule.OpenTime,
+			HalfOpenRequests:    rule.HalfOpenRequests,
+			FallbackStatus:      rule.FallbackStatus,
+			FallbackContentType: rule.FallbackContentType,
+			FallbackBody:        rule.FallbackBody,
+		},
+		UpdatedAt: rule.UpdatedAt,
+	}
+}
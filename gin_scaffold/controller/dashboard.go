@@ -1,10 +1,17 @@
 package controller
 
 import (
+	"errors"
+	"fmt"
+	"gin_scaffold/dao"
+	"gin_scaffold/dto"
 	"gin_scaffold/middleware"
+	"gin_scaffold/public"
+	"time"
 
 	"github.com/e421083458/golang_common/lib"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type DashboardController struct{}
@@ -15,6 +22,7 @@ func DashboardRegister(group *gin.RouterGroup) {
 	group.GET("/panel_group_data", service.PanelGroupData)
 	group.GET("/flow_stat", service.FlowStat)
 	group.GET("/service_stat", service.ServiceStat)
+	group.GET("/flow_stat_range", service.FlowStatRange)
 }
 
 // PanelGroupData godoc
@@ -27,47 +35,24 @@ func DashboardRegister(group *gin.RouterGroup) {
 // @Success 200 {object} middleware.Response{data=dto.PanelGroupDataOutput} "success"
 // @Router /dashboard/panel_group_data [get]
 func (service *DashboardController) PanelGroupData(c *gin.Context) {
-	tx,err:=lib.GetGormPool("degault")
-	if err!=nil{
-		middleware.ResponseError(c,2000,err)
-		return
-	}
-	serviceinfo:=
-
-
-
-	out:=dto.da
-
-}
-
-// PanelGroupData godoc
-// @Summary 指标统计
-// @Description 指标统计
-// @Tags 首页大盘
-// @ID /dashboard/panel_group_data
-// @Accept  json
-// @Produce  json
-// @Success 200 {object} middleware.Response{data=dto.PanelGroupDataOutput} "success"
-// @Router /dashboard/panel_group_data [get]
-func (service *DashboardController) FlowStat(c *gin.Context) {
 	tx, err := lib.GetGormPool("default")
 	if err != nil {
 		middleware.ResponseError(c, 2001, err)
 		return
 	}
-	serviceInfo := &dao.ServiceInfo{}
-	_, serviceNum, err := serviceInfo.PageList(c, tx, &dto.ServiceListInput{PageSize: 1, PageNo: 1})
+	serviceInfo := &dao.Serviceinfo{}
+	_, serviceNum, err := serviceInfo.PageList(c, tx, &dto.ServiceListInput{PageSize: 1, PageNumber: 1})
 	if err != nil {
 		middleware.ResponseError(c, 2002, err)
 		return
 	}
 	app := &dao.App{}
-	_, appNum, err := app.APPList(c, tx, &dto.APPListInput{PageNo: 1, PageSize: 1})
+	_, appNum, err := app.AppList(c, tx, &dto.AppListInput{PageNo: 1, PageSize: 1})
 	if err != nil {
 		middleware.ResponseError(c, 2002, err)
 		return
 	}
-	counter, err := public.FlowCounterHandler.GetCounter(public.FlowTotal)
+	counter, err := public.QueryFlowCount(public.FlowTotal, time.Now())
 	if err != nil {
 		middleware.ResponseError(c, 2003, err)
 		return
@@ -81,43 +66,203 @@ func (service *DashboardController) FlowStat(c *gin.Context) {
 	middleware.ResponseSuccess(c, out)
 }
 
-// PanelGroupData godoc
-// @Summary 指标统计
-// @Description 指标统计
+// FlowStat godoc
+// @Summary 服务统计
+// @Description 服务统计
 // @Tags 首页大盘
-// @ID /dashboard/panel_group_data
+// @ID /dashboard/flow_stat
 // @Accept  json
 // @Produce  json
-// @Success 200 {object} middleware.Response{data=dto.PanelGroupDataOutput} "success"
-// @Router /dashboard/panel_group_data [get]
-func (service *DashboardController) ServiceStat(c *gin.Context) {
+// @Success 200 {object} middleware.Response{data=dto.ServiceStatOutput} "success"
+// @Router /dashboard/flow_stat [get]
+func (service *DashboardController) FlowStat(c *gin.Context) {
 	tx, err := lib.GetGormPool("default")
 	if err != nil {
 		middleware.ResponseError(c, 2001, err)
 		return
 	}
-	serviceInfo := &dao.ServiceInfo{}
-	_, serviceNum, err := serviceInfo.PageList(c, tx, &dto.ServiceListInput{PageSize: 1, PageNo: 1})
+	today := flowStatToday()
+	list, err := flowStatDayList(c, tx, public.FlowTotal, today.AddDate(0, 0, -1), today)
 	if err != nil {
 		middleware.ResponseError(c, 2002, err)
 		return
 	}
-	app := &dao.App{}
-	_, appNum, err := app.APPList(c, tx, &dto.APPListInput{PageNo: 1, PageSize: 1})
+	middleware.ResponseSuccess(c, &dto.ServiceStatOutput{
+		Today:          list[1].Request,
+		Yesterday:      list[0].Request,
+		TodayError:     list[1].Error,
+		YesterdayError: list[0].Error,
+		TodayP95:       list[1].P95,
+		YesterdayP95:   list[0].P95,
+	})
+}
+
+// FlowStatRange godoc
+// @Summary 按日期范围查询流量
+// @Description 按日期范围查询全站、服务或租户每小时的请求量、错误量和p95耗时，用于任意两天对比
+// @Tags 首页大盘
+// @ID /dashboard/flow_stat_range
+// @Accept  json
+// @Produce  json
+// @Param stat_type query int false "统计对象 0=全站 1=服务 2=租户"
+// @Param id query int false "服务或租户id"
+// @Param start_date query string true "开始日期"
+// @Param end_date query string true "结束日期"
+// @Success 200 {object} middleware.Response{data=dto.DashFlowStatRangeOutput} "success"
+// @Router /dashboard/flow_stat_range [get]
+func (service *DashboardController) FlowStatRange(c *gin.Context) {
+	params := &dto.DashFlowStatRangeInput{}
+	if err := params.BindValidParam(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+	startDate, err := time.ParseInLocation("2006-01-02", params.StartDate, public.FlowCountLocation())
+	if err != nil {
+		middleware.ResponseError(c, 2001, errors.New("start_date格式应为2006-01-02"))
+		return
+	}
+	endDate, err := time.ParseInLocation("2006-01-02", params.EndDate, public.FlowCountLocation())
+	if err != nil {
+		middleware.ResponseError(c, 2001, errors.New("end_date格式应为2006-01-02"))
+		return
+	}
+	if endDate.Before(startDate) || endDate.Sub(startDate) >= flowStatMaxDays*24*time.Hour {
+		middleware.ResponseError(c, 2001, fmt.Errorf("日期范围应在%d天以内", flowStatMaxDays))
+		return
+	}
+	if params.StatType > 0 && params.ID <= 0 {
+		middleware.ResponseError(c, 2001, errors.New("统计服务或租户时id必填"))
+		return
+	}
+	tx, err := lib.GetGormPool("default")
 	if err != nil {
 		middleware.ResponseError(c, 2002, err)
 		return
 	}
-	counter, err := public.FlowCounterHandler.GetCounter(public.FlowTotal)
+	name := public.FlowTotal
+	switch params.StatType {
+	case 1:
+		serviceInfo := &dao.Serviceinfo{ID: params.ID}
+		if serviceInfo, err = serviceInfo.FindService(c, tx, serviceInfo); err != nil {
+			middleware.ResponseError(c, 2003, err)
+			return
+		}
+		if serviceInfo.ID == 0 {
+			middleware.ResponseError(c, 2003, errors.New("服务不存在"))
+			return
+		}
+		name = public.FlowServicePrefix + serviceInfo.ServiceName
+	case 2:
+		app := &dao.App{ID: params.ID}
+		if app, err = app.Find(c, tx, app); err != nil {
+			middleware.ResponseError(c, 2003, err)
+			return
+		}
+		if app.ID == 0 {
+			middleware.ResponseError(c, 2003, errors.New("租户不存在"))
+			return
+		}
+		name = public.FlowAppPrefix + app.AppID
+	}
+	list, err := flowStatDayList(c, tx, name, startDate, endDate)
 	if err != nil {
-		middleware.ResponseError(c, 2003, err)
+		middleware.ResponseError(c, 2004, err)
 		return
 	}
-	out := &dto.PanelGroupDataOutput{
-		ServiceNum:      serviceNum,
-		AppNum:          appNum,
-		TodayRequestNum: counter.TotalCount,
-		CurrentQPS:      counter.QPS,
+	middleware.ResponseSuccess(c, &dto.DashFlowStatRangeOutput{List: list})
+}
+
+// ServiceStat godoc
+// @Summary 服务类型占比
+// @Description 服务类型占比
+// @Tags 首页大盘
+// @ID /dashboard/service_stat
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} middleware.Response{data=dto.DashServiceStatOutput} "success"
+// @Router /dashboard/service_stat [get]
+func (service *DashboardController) ServiceStat(c *gin.Context) {
+	tx, err := lib.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2001, err)
+		return
 	}
-	middleware.ResponseSuccess(c, out)
+	serviceInfo := &dao.Serviceinfo{}
+	list, err := serviceInfo.GroupByLoadType(c, tx)
+	if err != nil {
+		middleware.ResponseError(c, 2002, err)
+		return
+	}
+	legend := []string{}
+	for index, item := range list {
+		name, ok := public.LoadTypeMap[item.LoadType]
+		if !ok {
+			middleware.ResponseError(c, 2003, errors.New("load_type not found"))
+			return
+		}
+		list[index].Name = name
+		legend = append(legend, name)
+	}
+	middleware.ResponseSuccess(c, &dto.DashServiceStatOutput{
+		Legend: legend,
+		Data:   list,
+	})
+}
+
+// 日期范围查询最多的天数
+const flowStatMaxDays = 31
+
+// 统计时区下今天的零点
+func flowStatToday() time.Time {
+	now := time.Now().In(public.FlowCountLocation())
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+}
+
+// 从db读取按小时持久化的统计，当天缺失的小时从redis读取，其余缺失的小时补0，当天只返回到当前小时
+func flowStatDayList(c *gin.Context, tx *gorm.DB, name string, startDate time.Time, endDate time.Time) ([]dto.DashFlowStatDayOutput, error) {
+	flowStat := &dao.FlowStat{}
+	rows, err := flowStat.RangeList(c, tx, name, startDate.Format("2006-01-02"), endDate.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	rowMap := map[string]dao.FlowStat{}
+	for _, row := range rows {
+		rowMap[fmt.Sprintf("%s_%d", row.StatDate, row.Hour)] = row
+	}
+	now := time.Now()
+	today := flowStatToday()
+	redisDown := false
+	list := []dto.DashFlowStatDayOutput{}
+	for day := startDate; !day.After(endDate); day = day.AddDate(0, 0, 1) {
+		item := dto.DashFlowStatDayOutput{
+			Date:    day.Format("2006-01-02"),
+			Request: []int64{},
+			Error:   []int64{},
+			P95:     []int64{},
+		}
+		for hour := 0; hour <= 23; hour++ {
+			hourTime := time.Date(day.Year(), day.Month(), day.Day(), hour, 0, 0, 0, day.Location())
+			if hourTime.After(now) {
+				break
+			}
+			row, ok := rowMap[fmt.Sprintf("%s_%d", item.Date, hour)]
+			//未到持久化周期或未开启持久化时，当天的数据只在redis中
+			if !ok && !redisDown && !day.Before(today) {
+				if stat, err := public.QueryFlowHourStat(name, hourTime); err == nil {
+					row = dao.FlowStat{RequestCount: stat.RequestCount, ErrorCount: stat.ErrorCount, P95: stat.P95}
+				} else {
+					redisDown = true
+					public.ContextWarning(c, "_com_flow_stat_redis_failure", map[string]interface{}{
+						"name": name,
+						"err":  err.Error(),
+					})
+				}
+			}
+			item.Request = append(item.Request, row.RequestCount)
+			item.Error = append(item.Error, row.ErrorCount)
+			item.P95 = append(item.P95, row.P95)
+		}
+		list = append(list, item)
+	}
+	return list, nil
 }
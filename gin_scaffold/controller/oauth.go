@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"errors"
+	"gin_scaffold/dao"
+	"gin_scaffold/dto"
+	"gin_scaffold/middleware"
+	"gin_scaffold/public"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+type OAuthController struct{}
+
+// 代理上的token接口，租户用app_id和secret换取访问令牌
+func OAuthRegister(group *gin.RouterGroup) {
+	oauth := &OAuthController{}
+	group.POST("/tokens", oauth.Tokens)
+}
+
+// Tokens godoc
+// @Summary 获取TOKEN
+// @Description OAuth2 client_credentials模式, 使用Basic认证或client_id/client_secret传入租户id和密钥
+// @Tags OAUTH
+// @ID /oauth/tokens
+// @Accept  x-www-form-urlencoded
+// @Produce  json
+// @Param grant_type formData string true "固定为client_credentials"
+// @Param scope formData string false "权限范围"
+// @Success 200 {object} middleware.Response{data=dto.TokensOutput} "success"
+// @Router /oauth/tokens [post]
+func (oauth *OAuthController) Tokens(c *gin.Context) {
+	params := &dto.TokensInput{}
+	if err := params.BindValidParam(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+	if params.GrantType != "client_credentials" {
+		middleware.ResponseErrorWithStatus(c, http.StatusBadRequest, 2001, errors.New("unsupported grant_type"))
+		return
+	}
+	appID, secret, ok := c.Request.BasicAuth()
+	if !ok {
+		appID, secret = params.ClientID, params.ClientSecret
+	}
+	if appID == "" || secret == "" {
+		middleware.ResponseErrorWithStatus(c, http.StatusUnauthorized, 2002, errors.New("client credentials required"))
+		return
+	}
+	app, ok := dao.AppManagerHandler.GetApp(appID)
+	if !ok || subtle.ConstantTimeCompare([]byte(app.Secret), []byte(secret)) != 1 {
+		middleware.ResponseErrorWithStatus(c, http.StatusUnauthorized, 2003, errors.New("invalid client credentials"))
+		return
+	}
+	now := time.Now()
+	expiresIn := public.GetJwtExpiresIn()
+	token, err := public.JwtEncode(jwt.RegisteredClaims{
+		Issuer:    public.JwtIssuer,
+		Subject:   app.AppID,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+	})
+	if err != nil {
+		middleware.ResponseError(c, 2004, err)
+		return
+	}
+	middleware.ResponseSuccess(c, &dto.TokensOutput{
+		AccessToken: token,
+		ExpiresIn:   int(expiresIn / time.Second),
+		TokenType:   "Bearer",
+		Scope:       params.Scope,
+	})
+}
@@ -8,7 +8,6 @@ import (
 	"gin_scaffold/middleware"
 	"gin_scaffold/public"
 	"strings"
-	"time"
 
 	"github.com/e421083458/golang_common/lib"
 	"github.com/gin-gonic/gin"
@@ -123,7 +122,7 @@ func (service *ServiceController) ServiceList(c *gin.Context) {
 // @Accept  json
 // @Produce  json
 // @Param info query string false "id"
-// @Success 200 {object} middleware.Response{data=dto.ServiceDetial} "success"
+// @Success 200 {object} middleware.Response{data=dao.ServiceDetialOutput} "success"
 // @Router /service/service_list [get]
 func (service *ServiceController) ServiceDetial(c *gin.Context) {
 	params := &dto.ServiceDeleteInput{}
@@ -148,7 +147,33 @@ func (service *ServiceController) ServiceDetial(c *gin.Context) {
 		middleware.ResponseError(c, 2006, err)
 		return
 	}
-	middleware.ResponseSuccess(c, servicedetial)
+	//节点健康状态只用于展示，redis不可用时按ip_list返回默认状态
+	nodeHealth, err := servicedetial.LoadBalance.GetNodeHealth(serviceInfo.ServiceName)
+	if err != nil {
+		public.ContextWarning(c, "_com_node_health_failure", map[string]interface{}{
+			"service": serviceInfo.ServiceName,
+			"err":     err.Error(),
+		})
+		if nodeHealth, err = servicedetial.LoadBalance.DefaultNodeHealth(); err != nil {
+			middleware.ResponseError(c, 2007, err)
+			return
+		}
+	}
+	//传输统计只用于展示，redis不可用时返回空列表
+	transportStats, err := servicedetial.LoadBalance.GetTransportStats(serviceInfo.ServiceName)
+	if err != nil {
+		public.ContextWarning(c, "_com_transport_stats_failure", map[string]interface{}{
+			"service": serviceInfo.ServiceName,
+			"err":     err.Error(),
+		})
+		transportStats = []*dao.TransportStats{}
+	}
+	out := &dao.ServiceDetialOutput{
+		ServiceDetial:  servicedetial,
+		NodeHealth:     nodeHealth,
+		TransportStats: transportStats,
+	}
+	middleware.ResponseSuccess(c, out)
 }
 
 // DeleteService godoc
@@ -183,6 +208,7 @@ func (service *ServiceController) DeleteService(c *gin.Context) {
 		middleware.ResponseError(c, 2003, err)
 		return
 	}
+	dao.PublishConfigChange(c, dao.ConfigChangeTypeService, dao.ConfigChangeActionDelete, serviceinfo.ID)
 	middleware.ResponseSuccess(c, "success")
 }
 
@@ -202,8 +228,13 @@ func (service *ServiceController) CreateHTTPService(c *gin.Context) {
 		middleware.ResponseError(c, 2000, err)
 		return
 	}
+	if _, err := dao.ParseRetryOn(param.RetryOn); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
 	if len(strings.Split(param.IpList, ",")) != len(strings.Split(param.WeightList, ",")) {
 		middleware.ResponseError(c, 2004, errors.New("ip list wrong"))
+		return
 	}
 	tx, err := lib.GetGormPool("default")
 	if err != nil {
@@ -212,14 +243,25 @@ func (service *ServiceController) CreateHTTPService(c *gin.Context) {
 	}
 	tx = tx.Begin()
 	//先创建serviceinfo还是先创建httprule，因为主键是确定的 ,用事务开始!!!
+	//Find查不到记录时不返回错误，按id判断是否已存在
 	serviceinfo := &dao.Serviceinfo{ServiceName: param.ServiceName}
-	if _, err = serviceinfo.FindService(c, tx, serviceinfo); err == nil {
+	if serviceinfo, err = serviceinfo.FindService(c, tx, serviceinfo); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2002, err)
+		return
+	}
+	if serviceinfo.ID != 0 {
 		tx.Rollback()
 		middleware.ResponseError(c, 2002, errors.New("服务已存在"))
 		return
 	}
 	httpUrl := &dao.HttpRule{RuleType: param.RuleType, Rule: param.Rule}
-	if _, err = httpUrl.Find(c, tx, httpUrl); err == nil {
+	if httpUrl, err = httpUrl.Find(c, tx, httpUrl); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2003, err)
+		return
+	}
+	if httpUrl.ID != 0 {
 		tx.Rollback()
 		middleware.ResponseError(c, 2003, errors.New("httpurl已存在"))
 		return
@@ -233,14 +275,18 @@ func (service *ServiceController) CreateHTTPService(c *gin.Context) {
 		return
 	}
 	httprule := &dao.HttpRule{
-		ServiceID:      servicenew.ID,
-		RuleType:       public.LoadTypeHTTP,
-		Rule:           param.Rule,
-		NeedHttps:      param.NeedHttps,
-		NeedWebsocket:  param.NeedWebsocket,
-		NeedStripUri:   param.NeedStripUri,
-		UrlRewrite:     param.UrlRewrite,
-		HeaderTransfor: param.HeaderTransfor,
+		ServiceID:             servicenew.ID,
+		RuleType:              param.RuleType,
+		Rule:                  param.Rule,
+		NeedHttps:             param.NeedHttps,
+		HstsMaxAge:            param.HstsMaxAge,
+		HstsIncludeSubdomains: param.HstsIncludeSubdomains,
+		NeedWebsocket:         param.NeedWebsocket,
+		WebsocketMaxConn:      param.WebsocketMaxConn,
+		WebsocketIdleTimeout:  param.WebsocketIdleTimeout,
+		NeedStripUri:          param.NeedStripUri,
+		UrlRewrite:            param.UrlRewrite,
+		HeaderTransfor:        param.HeaderTransfor,
 	}
 	if err := httprule.Save(c, tx); err != nil {
 		tx.Rollback()
@@ -250,10 +296,14 @@ func (service *ServiceController) CreateHTTPService(c *gin.Context) {
 	acesscontrol := &dao.AcccessControll{
 		ServiceID:         servicenew.ID,
 		OpenAuth:          param.OpenAuth,
+		AuthType:          param.AuthType,
 		BlackList:         param.BlackList,
 		WhiteList:         param.WhiteList,
+		WhiteHostName:     param.WhiteHostName,
 		ClientIPFlowLimit: param.ClientipFlowLimit,
 		ServiceFlowLimit:  param.ServiceFlowLimit,
+		FlowLimitType:     param.FlowLimitType,
+		ClientCa:          param.ClientCa,
 	}
 	if err := acesscontrol.Save(c, tx); err != nil {
 		tx.Rollback()
@@ -263,18 +313,47 @@ func (service *ServiceController) CreateHTTPService(c *gin.Context) {
 	loadbalance := &dao.LoadBalance{
 		ServiceID:              servicenew.ID,
 		RoundType:              param.RoundType,
+		CheckMethod:            param.CheckMethod,
+		CheckTimeout:           param.CheckTimeout,
+		CheckInterval:          param.CheckInterval,
+		CheckPath:              param.CheckPath,
+		CheckExpectStatus:      param.CheckExpectStatus,
+		HashKey:                param.HashKey,
 		IpList:                 param.IpList,
 		WeightList:             param.WeightList,
 		UpstreamConnectTimeout: param.UpstreamConnectTimeout,
 		UpstreamHeaderTimeout:  param.UpstreamHeaderTimeout,
 		UpstreamIdleTimeout:    param.UpstreamIdleTimeout,
 		UpstreamMaxIdle:        param.UpstreamMaxIdle,
+		UpstreamTls:            param.UpstreamTls,
+		UpstreamCaCert:         param.UpstreamCaCert,
+		UpstreamClientCert:     param.UpstreamClientCert,
+		UpstreamClientKey:      param.UpstreamClientKey,
+		UpstreamServerName:     param.UpstreamServerName,
+		RetryMaxAttempts:       param.RetryMaxAttempts,
+		RetryOn:                param.RetryOn,
+		RetryNonIdempotent:     param.RetryNonIdempotent,
+		RetryBackoff:           param.RetryBackoff,
+		RetryMaxBackoff:        param.RetryMaxBackoff,
+		RetryBudget:            param.RetryBudget,
+	}
+	if err := checkServiceTLS(loadbalance, acesscontrol); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2009, err)
+		return
 	}
 	if err := loadbalance.Save(c, tx); err != nil {
 		tx.Rollback()
 		middleware.ResponseError(c, 2008, errors.New("创建负载均衡信息失败"))
 		return
 	}
+	//提交后才通知代理重新加载，否则代理读不到新服务
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2010, err)
+		return
+	}
+	dao.PublishConfigChange(c, dao.ConfigChangeTypeService, dao.ConfigChangeActionCreate, servicenew.ID)
 	middleware.ResponseSuccess(c, "success")
 }
 
@@ -294,6 +373,10 @@ func (service *ServiceController) UpdateHTTPService(c *gin.Context) {
 		middleware.ResponseError(c, 2000, err)
 		return
 	}
+	if _, err := dao.ParseRetryOn(param.RetryOn); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
 	if len(strings.Split(param.IpList, ",")) != len(strings.Split(param.WeightList, ",")) {
 		middleware.ResponseError(c, 2001, errors.New("IP列表和权重列表不匹配"))
 		return
@@ -328,8 +411,12 @@ func (service *ServiceController) UpdateHTTPService(c *gin.Context) {
 	}
 	httprule := detail.HTTPRule
 	httprule.NeedHttps = param.NeedHttps
+	httprule.HstsMaxAge = param.HstsMaxAge
+	httprule.HstsIncludeSubdomains = param.HstsIncludeSubdomains
 	httprule.NeedStripUri = param.NeedStripUri
 	httprule.NeedWebsocket = param.NeedWebsocket
+	httprule.WebsocketMaxConn = param.WebsocketMaxConn
+	httprule.WebsocketIdleTimeout = param.WebsocketIdleTimeout
 	httprule.UrlRewrite = param.UrlRewrite
 	httprule.HeaderTransfor = param.HeaderTransfor
 	if err := httprule.Save(c, tx); err != nil {
@@ -343,12 +430,34 @@ func (service *ServiceController) UpdateHTTPService(c *gin.Context) {
 	}
 	Loadbalance.ID = info.ID
 	Loadbalance.RoundType = param.RoundType
+	Loadbalance.CheckMethod = param.CheckMethod
+	Loadbalance.CheckTimeout = param.CheckTimeout
+	Loadbalance.CheckInterval = param.CheckInterval
+	Loadbalance.CheckPath = param.CheckPath
+	Loadbalance.CheckExpectStatus = param.CheckExpectStatus
+	Loadbalance.HashKey = param.HashKey
 	Loadbalance.IpList = param.IpList
 	Loadbalance.WeightList = param.WeightList
 	Loadbalance.UpstreamConnectTimeout = param.UpstreamConnectTimeout
 	Loadbalance.UpstreamHeaderTimeout = param.UpstreamHeaderTimeout
 	Loadbalance.UpstreamIdleTimeout = param.UpstreamIdleTimeout
 	Loadbalance.UpstreamMaxIdle = param.UpstreamMaxIdle
+	Loadbalance.UpstreamTls = param.UpstreamTls
+	Loadbalance.UpstreamCaCert = param.UpstreamCaCert
+	Loadbalance.UpstreamClientCert = param.UpstreamClientCert
+	Loadbalance.UpstreamClientKey = param.UpstreamClientKey
+	Loadbalance.UpstreamServerName = param.UpstreamServerName
+	Loadbalance.RetryMaxAttempts = param.RetryMaxAttempts
+	Loadbalance.RetryOn = param.RetryOn
+	Loadbalance.RetryNonIdempotent = param.RetryNonIdempotent
+	Loadbalance.RetryBackoff = param.RetryBackoff
+	Loadbalance.RetryMaxBackoff = param.RetryMaxBackoff
+	Loadbalance.RetryBudget = param.RetryBudget
+	if err := checkServiceTLS(Loadbalance, &dao.AcccessControll{OpenAuth: param.OpenAuth, AuthType: param.AuthType, ClientCa: param.ClientCa}); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2009, err)
+		return
+	}
 	if err := Loadbalance.Save(c, tx); err != nil {
 		tx.Rollback()
 		middleware.ResponseError(c, 2007, err)
@@ -360,16 +469,21 @@ func (service *ServiceController) UpdateHTTPService(c *gin.Context) {
 	}
 	accesscontrol.ServiceID = info.ID
 	accesscontrol.OpenAuth = param.OpenAuth
+	accesscontrol.AuthType = param.AuthType
 	accesscontrol.BlackList = param.BlackList
 	accesscontrol.WhiteList = param.WhiteList
+	accesscontrol.WhiteHostName = param.WhiteHostName
 	accesscontrol.ServiceFlowLimit = param.ServiceFlowLimit
+	accesscontrol.FlowLimitType = param.FlowLimitType
 	accesscontrol.ClientIPFlowLimit = param.ClientipFlowLimit
+	accesscontrol.ClientCa = param.ClientCa
 	if err := accesscontrol.Save(c, tx); err != nil {
 		tx.Rollback()
 		middleware.ResponseError(c, 2008, err)
 		return
 	}
 	tx.Commit()
+	dao.PublishConfigChange(c, dao.ConfigChangeTypeService, dao.ConfigChangeActionUpdate, info.ID)
 	middleware.ResponseSuccess(c, "success")
 }
 
@@ -447,6 +561,7 @@ func (service *ServiceController) CreateGrpcService(c *gin.Context) {
 		WhiteList:         param.WhiteList,
 		ClientIPFlowLimit: param.ClientIPFlowLimit,
 		ServiceFlowLimit:  param.ServiceFlowLimit,
+		FlowLimitType:     param.FlowLimitType,
 	}
 	if err := acesscontrol.Save(c, tx); err != nil {
 		tx.Rollback()
@@ -454,11 +569,25 @@ func (service *ServiceController) CreateGrpcService(c *gin.Context) {
 		return
 	}
 	loadbalance := &dao.LoadBalance{
-		ServiceID:  info.ID,
-		RoundType:  param.RoundType,
-		IpList:     param.IpList,
-		WeightList: param.WeightList,
-		ForbidList: param.ForbidList,
+		ServiceID:     info.ID,
+		RoundType:     param.RoundType,
+		CheckMethod:   param.CheckMethod,
+		CheckTimeout:  param.CheckTimeout,
+		CheckInterval: param.CheckInterval,
+		IpList:        param.IpList,
+		WeightList:    param.WeightList,
+		ForbidList:    param.ForbidList,
+
+		UpstreamTls:        param.UpstreamTls,
+		UpstreamCaCert:     param.UpstreamCaCert,
+		UpstreamClientCert: param.UpstreamClientCert,
+		UpstreamClientKey:  param.UpstreamClientKey,
+		UpstreamServerName: param.UpstreamServerName,
+	}
+	if err := checkServiceTLS(loadbalance, nil); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2010, err)
+		return
 	}
 	if err := loadbalance.Save(c, tx); err != nil {
 		tx.Rollback()
@@ -466,6 +595,7 @@ func (service *ServiceController) CreateGrpcService(c *gin.Context) {
 		return
 	}
 	tx.Commit()
+	dao.PublishConfigChange(c, dao.ConfigChangeTypeService, dao.ConfigChangeActionCreate, info.ID)
 	middleware.ResponseSuccess(c, "success")
 }
 
@@ -517,9 +647,22 @@ func (service *ServiceController) UpdateGrpcService(c *gin.Context) {
 	}
 	Loadbalance.ServiceID = info.ID
 	Loadbalance.RoundType = param.RoundType
+	Loadbalance.CheckMethod = param.CheckMethod
+	Loadbalance.CheckTimeout = param.CheckTimeout
+	Loadbalance.CheckInterval = param.CheckInterval
 	Loadbalance.IpList = param.IpList
 	Loadbalance.WeightList = param.WeightList
 	Loadbalance.ForbidList = param.ForbidList
+	Loadbalance.UpstreamTls = param.UpstreamTls
+	Loadbalance.UpstreamCaCert = param.UpstreamCaCert
+	Loadbalance.UpstreamClientCert = param.UpstreamClientCert
+	Loadbalance.UpstreamClientKey = param.UpstreamClientKey
+	Loadbalance.UpstreamServerName = param.UpstreamServerName
+	if err := checkServiceTLS(Loadbalance, nil); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2010, err)
+		return
+	}
 	if err := Loadbalance.Save(c, tx); err != nil {
 		tx.Rollback()
 		middleware.ResponseError(c, 2005, err)
@@ -547,12 +690,14 @@ func (service *ServiceController) UpdateGrpcService(c *gin.Context) {
 	accesscontrol.WhiteList = param.WhiteList
 	accesscontrol.ClientIPFlowLimit = param.ClientIPFlowLimit
 	accesscontrol.ServiceFlowLimit = param.ServiceFlowLimit
+	accesscontrol.FlowLimitType = param.FlowLimitType
 	if err := accesscontrol.Save(c, tx); err != nil {
 		tx.Rollback()
 		middleware.ResponseError(c, 2007, err)
 		return
 	}
 	tx.Commit()
+	dao.PublishConfigChange(c, dao.ConfigChangeTypeService, dao.ConfigChangeActionUpdate, info.ID)
 	middleware.ResponseSuccess(c, "success")
 }
 
@@ -628,6 +773,9 @@ func (service *ServiceController) CreateTcpService(c *gin.Context) {
 		WhiteList:         param.WhiteList,
 		ClientIPFlowLimit: param.ClientIPFlowLimit,
 		ServiceFlowLimit:  param.ServiceFlowLimit,
+		FlowLimitType:     param.FlowLimitType,
+		ServiceMaxConn:    param.ServiceMaxConn,
+		ClientIPMaxConn:   param.ClientIPMaxConn,
 	}
 	if err := acesscontrol.Save(c, tx); err != nil {
 		tx.Rollback()
@@ -635,11 +783,14 @@ func (service *ServiceController) CreateTcpService(c *gin.Context) {
 		return
 	}
 	loadbalance := &dao.LoadBalance{
-		ServiceID:  info.ID,
-		RoundType:  param.RoundType,
-		IpList:     param.IpList,
-		WeightList: param.WeightList,
-		ForbidList: param.ForbidList,
+		ServiceID:     info.ID,
+		RoundType:     param.RoundType,
+		CheckMethod:   param.CheckMethod,
+		CheckTimeout:  param.CheckTimeout,
+		CheckInterval: param.CheckInterval,
+		IpList:        param.IpList,
+		WeightList:    param.WeightList,
+		ForbidList:    param.ForbidList,
 	}
 	if err := loadbalance.Save(c, tx); err != nil {
 		tx.Rollback()
@@ -647,6 +798,7 @@ func (service *ServiceController) CreateTcpService(c *gin.Context) {
 		return
 	}
 	tx.Commit()
+	dao.PublishConfigChange(c, dao.ConfigChangeTypeService, dao.ConfigChangeActionCreate, info.ID)
 	middleware.ResponseSuccess(c, "success")
 }
 
@@ -698,6 +850,9 @@ func (service *ServiceController) UpdateTcpService(c *gin.Context) {
 	}
 	Loadbalance.ServiceID = info.ID
 	Loadbalance.RoundType = param.RoundType
+	Loadbalance.CheckMethod = param.CheckMethod
+	Loadbalance.CheckTimeout = param.CheckTimeout
+	Loadbalance.CheckInterval = param.CheckInterval
 	Loadbalance.IpList = param.IpList
 	Loadbalance.WeightList = param.WeightList
 	Loadbalance.ForbidList = param.ForbidList
@@ -728,12 +883,16 @@ func (service *ServiceController) UpdateTcpService(c *gin.Context) {
 	accesscontrol.WhiteList = param.WhiteList
 	accesscontrol.ClientIPFlowLimit = param.ClientIPFlowLimit
 	accesscontrol.ServiceFlowLimit = param.ServiceFlowLimit
+	accesscontrol.FlowLimitType = param.FlowLimitType
+	accesscontrol.ServiceMaxConn = param.ServiceMaxConn
+	accesscontrol.ClientIPMaxConn = param.ClientIPMaxConn
 	if err := accesscontrol.Save(c, tx); err != nil {
 		tx.Rollback()
 		middleware.ResponseError(c, 2007, err)
 		return
 	}
 	tx.Commit()
+	dao.PublishConfigChange(c, dao.ConfigChangeTypeService, dao.ConfigChangeActionUpdate, info.ID)
 	middleware.ResponseSuccess(c, "success")
 }
 
@@ -751,29 +910,49 @@ func (service *ServiceController) ServiceStat(c *gin.Context) {
 	param := &dto.ServiceDeleteInput{}
 	if err := param.BindValidParam(c); err != nil {
 		middleware.ResponseError(c, 2000, err)
+		return
 	}
-	//怎么计算流量
 	tx, err := lib.GetGormPool("default")
 	if err != nil {
 		middleware.ResponseError(c, 2001, err)
+		return
 	}
 	serviceinfo := &dao.Serviceinfo{ID: param.ID}
-	if serviceinfo, err := serviceinfo.FindService(c, tx, serviceinfo); err != nil {
+	serviceinfo, err = serviceinfo.FindService(c, tx, serviceinfo)
+	if err != nil {
 		middleware.ResponseError(c, 2002, err)
+		return
 	}
-	servicedetial, err := serviceinfo.ServiceDetial(c, tx, serviceinfo)
+	//流量由代理按服务名计数，按小时写入db
+	today := flowStatToday()
+	list, err := flowStatDayList(c, tx, public.FlowServicePrefix+serviceinfo.ServiceName, today.AddDate(0, 0, -1), today)
 	if err != nil {
 		middleware.ResponseError(c, 2003, err)
+		return
 	}
-	todayList := []int64{}
-	yesterdayList := []int64{}
-	for i := 0; i <= time.Now().Hour(); i++ {
-		todayList = append(todayList, 0)
-	}
-	for i := 0; i <= 23; i++ {
-		yesterdayList = append(yesterdayList, 0)
+	out := &dto.ServiceStatOutput{
+		Today:          list[1].Request,
+		Yesterday:      list[0].Request,
+		TodayError:     list[1].Error,
+		YesterdayError: list[0].Error,
+		TodayP95:       list[1].P95,
+		YesterdayP95:   list[0].P95,
 	}
-	out := &dto.ServiceStatOutput{Today: todayList, Yesterday: yesterdayList}
-
 	middleware.ResponseSuccess(c, out)
 }
+
+// 保存前校验证书配置，避免代理加载后所有请求失败
+func checkServiceTLS(loadbalance *dao.LoadBalance, access *dao.AcccessControll) error {
+	if _, err := loadbalance.GetUpstreamTLSConfig(); err != nil {
+		return fmt.Errorf("下游tls配置错误: %v", err)
+	}
+	if access != nil {
+		if access.OpenAuth == 1 && access.AuthType == public.AuthTypeClientCert && access.ClientCa == "" {
+			return errors.New("权限验证方式为客户端证书时client_ca不能为空")
+		}
+		if _, err := access.GetClientCaPool(); err != nil {
+			return fmt.Errorf("客户端CA配置错误: %v", err)
+		}
+	}
+	return nil
+}
@@ -1,6 +1,10 @@
 package dao
 
 import (
+	"context"
+	"crypto/x509"
+	"gin_scaffold/public"
+
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
@@ -9,11 +13,101 @@ type AcccessControll struct {
 	ID                int64  `json:"id" gorm:"primary_key"`
 	ServiceID         int64  `json:"service_id" gorm:"column:service_id" description:"服务id"`
 	OpenAuth          int    `json:"open_auth" gorm:"column:open_auth" description:"是否开启权限 1=开启"`
+	AuthType          int    `json:"auth_type" gorm:"column:auth_type" description:"权限验证方式 0=JWT token 1=HMAC签名 2=客户端证书, 仅http服务"`
 	BlackList         string `json:"black_list" gorm:"column:black_list" description:"黑名单ip	"`
 	WhiteList         string `json:"white_list" gorm:"column:white_list" description:"白名单ip	"`
 	WhiteHostName     string `json:"white_host_name" gorm:"column:white_host_name" description:"白名单主机	"`
 	ClientIPFlowLimit int    `json:"clientip_flow_limit" gorm:"column:clientip_flow_limit" description:"客户端ip限流	"`
 	ServiceFlowLimit  int    `json:"service_flow_limit" gorm:"column:service_flow_limit" description:"服务端限流	"`
+	FlowLimitType     int    `json:"flow_limit_type" gorm:"column:flow_limit_type" description:"限流方式 0=单机令牌桶 1=redis滑动窗口 2=redis GCRA"`
+	ServiceMaxConn    int    `json:"service_max_conn" gorm:"column:service_max_conn" description:"服务最大并发连接数, 0=不限制"`
+	ClientIPMaxConn   int    `json:"clientip_max_conn" gorm:"column:clientip_max_conn" description:"客户端ip最大并发连接数, 0=不限制"`
+	ClientCa          string `json:"client_ca" gorm:"column:client_ca;type:text" description:"校验客户端证书的CA, pem内容或文件路径, 为空不要求客户端证书, 仅https"`
+
+	blackListMatcher     *public.IPMatcher   `gorm:"-"`
+	whiteListMatcher     *public.IPMatcher   `gorm:"-"`
+	whiteHostNameMatcher *public.HostMatcher `gorm:"-"`
+	clientCaPool         *x509.CertPool      `gorm:"-"`
+}
+
+// 加载服务时把名单解析为匹配器，请求时不再重复解析
+func (access *AcccessControll) compileAccessList() {
+	access.blackListMatcher = public.NewIPMatcher(access.BlackList)
+	access.whiteListMatcher = public.NewIPMatcher(access.WhiteList)
+	access.whiteHostNameMatcher = public.NewHostMatcher(access.WhiteHostName)
+	logInvalidIPList("service", access.ServiceID, "black_list", access.blackListMatcher)
+	logInvalidIPList("service", access.ServiceID, "white_list", access.whiteListMatcher)
+	if access.ClientCa != "" {
+		access.clientCaPool, _ = access.parseClientCa()
+	}
+}
+
+func (access *AcccessControll) parseClientCa() (*x509.CertPool, error) {
+	pemData, err := public.LoadPemOrFile(access.ClientCa)
+	if err != nil {
+		return nil, err
+	}
+	return public.NewCertPool(pemData)
+}
+
+// 未配置CA时返回nil，CA无法加载时返回错误，请求应被拒绝
+func (access *AcccessControll) GetClientCaPool() (*x509.CertPool, error) {
+	if access.ClientCa == "" {
+		return nil, nil
+	}
+	if access.clientCaPool == nil {
+		return access.parseClientCa()
+	}
+	return access.clientCaPool, nil
+}
+
+func (access *AcccessControll) GetBlackListMatcher() *public.IPMatcher {
+	if access.blackListMatcher == nil {
+		return public.NewIPMatcher(access.BlackList)
+	}
+	return access.blackListMatcher
+}
+
+func (access *AcccessControll) GetWhiteListMatcher() *public.IPMatcher {
+	if access.whiteListMatcher == nil {
+		return public.NewIPMatcher(access.WhiteList)
+	}
+	return access.whiteListMatcher
+}
+
+func (access *AcccessControll) GetWhiteHostNameMatcher() *public.HostMatcher {
+	if access.whiteHostNameMatcher == nil {
+		return public.NewHostMatcher(access.WhiteHostName)
+	}
+	return access.whiteHostNameMatcher
+}
+
+// 名单中无法解析的条目被忽略，记录日志便于排查
+func logInvalidIPList(owner string, id int64, field string, matcher *public.IPMatcher) {
+	if len(matcher.Invalid()) == 0 {
+		return
+	}
+	public.ContextWarning(context.Background(), "_com_access_list_invalid", map[string]interface{}{
+		"owner":   owner,
+		"id":      id,
+		"field":   field,
+		"invalid": matcher.Invalid(),
+	})
+}
+
+// 配置了白名单时只允许白名单内ip，白名单优先级高于黑名单；
+// 白名单条目全部无法解析时拒绝所有ip，不能因配置错误放开访问
+func (access *AcccessControll) AllowClientIP(clientIP string) bool {
+	if whiteList := access.GetWhiteListMatcher(); whiteList.Configured() {
+		return whiteList.Match(clientIP)
+	}
+	return !access.GetBlackListMatcher().Match(clientIP)
+}
+
+// 未配置主机白名单时不限制
+func (access *AcccessControll) AllowHost(host string) bool {
+	hostMatcher := access.GetWhiteHostNameMatcher()
+	return hostMatcher.Len() == 0 || hostMatcher.Match(host)
 }
 
 func (access *AcccessControll) TableName() string {
@@ -1,29 +1,57 @@
 package dao
 
 import (
+	"crypto/x509"
 	"gin_scaffold/dto"
+	"gin_scaffold/public"
+	"net/http/httptest"
+	"sort"
+	"sync"
 	"time"
 
+	"github.com/e421083458/golang_common/lib"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type App struct {
-	ID        int64     `json:"id" gorm:"primary_key"`
-	AppID     string    `json:"app_id" gorm:"column:app_id" description:"租户id	"`
-	Name      string    `json:"name" gorm:"column:name" description:"租户名称	"`
-	Secret    string    `json:"secret" gorm:"column:secret" description:"密钥"`
-	WhiteIPS  string    `json:"white_ips" gorm:"column:white_ips" description:"ip白名单，支持前缀匹配"`
-	Qpd       int64     `json:"qpd" gorm:"column:qpd" description:"日请求量限制"`
-	Qps       int64     `json:"qps" gorm:"column:qps" description:"每秒请求量限制"`
-	CreatedAt time.Time `json:"create_at" gorm:"column:create_at" description:"添加时间	"`
-	UpdatedAt time.Time `json:"update_at" gorm:"column:update_at" description:"更新时间"`
-	IsDelete  int8      `json:"is_delete" gorm:"column:is_delete" description:"是否已删除；0：否；1：是"`
+	ID          int64     `json:"id" gorm:"primary_key"`
+	AppID       string    `json:"app_id" gorm:"column:app_id" description:"租户id	"`
+	Name        string    `json:"name" gorm:"column:name" description:"租户名称	"`
+	Secret      string    `json:"secret" gorm:"column:secret" description:"密钥"`
+	WhiteIPS    string    `json:"white_ips" gorm:"column:white_ips" description:"ip白名单，支持前缀匹配"`
+	Qpd         int64     `json:"qpd" gorm:"column:qpd" description:"日请求量限制"`
+	Qps         int64     `json:"qps" gorm:"column:qps" description:"每秒请求量限制"`
+	CertSubject string    `json:"cert_subject" gorm:"column:cert_subject" description:"客户端证书subject, 如CN=app1,O=org, 为空时按证书CN匹配app_id"`
+	CreatedAt   time.Time `json:"create_at" gorm:"column:create_at" description:"添加时间	"`
+	UpdatedAt   time.Time `json:"update_at" gorm:"column:update_at" description:"更新时间"`
+	IsDelete    int8      `json:"is_delete" gorm:"column:is_delete" description:"是否已删除；0：否；1：是"`
+
+	whiteIPSMatcher *public.IPMatcher `gorm:"-"`
 }
 
 func (app *App) TableName() string {
 	return "gateway_apps"
 }
+
+// 加载租户时解析ip白名单，支持前缀匹配
+func (app *App) compileWhiteIPS() {
+	app.whiteIPSMatcher = public.NewIPMatcher(app.WhiteIPS)
+	logInvalidIPList("app", app.ID, "white_ips", app.whiteIPSMatcher)
+}
+
+func (app *App) GetWhiteIPSMatcher() *public.IPMatcher {
+	if app.whiteIPSMatcher == nil {
+		return public.NewIPMatcher(app.WhiteIPS)
+	}
+	return app.whiteIPSMatcher
+}
+
+// 未配置白名单时不限制，配置了但无法解析时拒绝
+func (app *App) AllowClientIP(clientIP string) bool {
+	matcher := app.GetWhiteIPSMatcher()
+	return !matcher.Configured() || matcher.Match(clientIP)
+}
 func (app *App) Find(c *gin.Context, tx *gorm.DB, search *App) (*App, error) {
 	model := &App{}
 	err := tx.WithContext(c).Where(search).Find(model).Error
@@ -46,10 +74,146 @@ func (app *App) AppList(c *gin.Context, tx *gorm.DB, search *dto.AppListInput) (
 	offset := (search.PageNo - 1) * search.PageSize
 	//where是模糊查询
 	query := tx.WithContext(c)
-	query = query.Table(app.TableName()).Where("is_delete=0", "name LIKE %?% or describ like %?%", search.Info, search.Info)
+	query = query.Table(app.TableName()).Where("is_delete=0")
+	if search.Info != "" {
+		query = query.Where("(name like ? or app_id like ?)", "%"+search.Info+"%", "%"+search.Info+"%")
+	}
+	//count与分页查询共用查询条件
+	query = query.Session(&gorm.Session{})
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
 	if err := query.Limit(search.PageSize).Offset(offset).Order("id desc").Find(&pagelist).Error; err != nil && err != gorm.ErrRecordNotFound {
 		return nil, 0, err
 	}
-	query.Limit(search.PageSize).Offset(offset).Count(&total)
 	return pagelist, total, nil
 }
+
+var AppManagerHandler *AppManager
+
+func init() {
+	AppManagerHandler = NewAppManager()
+}
+
+// AppMap、AppSlice只整体替换不原地修改
+type AppManager struct {
+	AppMap       map[string]*App
+	AppSlice     []*App
+	Locker       sync.RWMutex
+	init         sync.Once
+	err          error
+	reloadLocker sync.Mutex
+}
+
+func NewAppManager() *AppManager {
+	return &AppManager{
+		AppMap:   map[string]*App{},
+		AppSlice: []*App{},
+		Locker:   sync.RWMutex{},
+		init:     sync.Once{},
+	}
+}
+
+func (s *AppManager) GetAppList() []*App {
+	s.Locker.RLock()
+	defer s.Locker.RUnlock()
+	return s.AppSlice
+}
+
+func (s *AppManager) GetApp(appID string) (*App, bool) {
+	s.Locker.RLock()
+	defer s.Locker.RUnlock()
+	app, ok := s.AppMap[appID]
+	return app, ok
+}
+
+// 按客户端证书subject查找租户，优先完整subject匹配，其次CN匹配未配置subject的租户
+func (s *AppManager) GetAppByCert(cert *x509.Certificate) (*App, bool) {
+	subject := cert.Subject.String()
+	s.Locker.RLock()
+	defer s.Locker.RUnlock()
+	for _, app := range s.AppSlice {
+		if app.CertSubject != "" && app.CertSubject == subject {
+			return app, true
+		}
+	}
+	if app, ok := s.AppMap[cert.Subject.CommonName]; ok && app.CertSubject == "" {
+		return app, true
+	}
+	return nil, false
+}
+
+func (s *AppManager) LoadOnce() error {
+	s.init.Do(func() {
+		s.err = s.Reload()
+	})
+	return s.err
+}
+
+// 从db重新加载全部租户
+func (s *AppManager) Reload() error {
+	s.reloadLocker.Lock()
+	defer s.reloadLocker.Unlock()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	tx, err := lib.GetGormPool("default")
+	if err != nil {
+		return err
+	}
+	appInfo := &App{}
+	list, _, err := appInfo.AppList(c, tx, &dto.AppListInput{PageNo: 1, PageSize: 99999})
+	if err != nil {
+		return err
+	}
+	appMap := map[string]*App{}
+	appSlice := []*App{}
+	for _, item := range list {
+		tmpItem := item
+		tmpItem.compileWhiteIPS()
+		appMap[item.AppID] = &tmpItem
+		appSlice = append(appSlice, &tmpItem)
+	}
+	s.Locker.Lock()
+	s.AppMap = appMap
+	s.AppSlice = appSlice
+	s.Locker.Unlock()
+	return nil
+}
+
+// 只重新加载单个租户，租户已删除时从内存中移除
+func (s *AppManager) ReloadApp(id int64) error {
+	s.reloadLocker.Lock()
+	defer s.reloadLocker.Unlock()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	tx, err := lib.GetGormPool("default")
+	if err != nil {
+		return err
+	}
+	search := &App{ID: id}
+	app, err := search.Find(c, tx, search)
+	if err != nil {
+		return err
+	}
+	s.Locker.RLock()
+	oldSlice := s.AppSlice
+	s.Locker.RUnlock()
+	appMap := map[string]*App{}
+	appSlice := []*App{}
+	for _, item := range oldSlice {
+		if item.ID == id {
+			continue
+		}
+		appMap[item.AppID] = item
+		appSlice = append(appSlice, item)
+	}
+	if app.ID != 0 && app.IsDelete == 0 {
+		app.compileWhiteIPS()
+		appMap[app.AppID] = app
+		appSlice = append(appSlice, app)
+		sort.Slice(appSlice, func(i, j int) bool { return appSlice[i].ID > appSlice[j].ID })
+	}
+	s.Locker.Lock()
+	s.AppMap = appMap
+	s.AppSlice = appSlice
+	s.Locker.Unlock()
+	return nil
+}
@@ -0,0 +1,478 @@
+package dao
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"gin_scaffold/public"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/e421083458/golang_common/lib"
+	"github.com/garyburd/redigo/redis"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 内部CA，用本地的CA证书和私钥签发叶子证书，不依赖外部网络
+type CertIssuer struct {
+	caCert *x509.Certificate
+	caKey  crypto.Signer
+	caPem  []byte
+}
+
+func NewCertIssuer(caCertPem []byte, caKeyPem []byte) (*CertIssuer, error) {
+	certBlock, _ := pem.Decode(caCertPem)
+	if certBlock == nil {
+		return nil, errors.New("ca certificate is not pem")
+	}
+	caCert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	keyBlock, _ := pem.Decode(caKeyPem)
+	if keyBlock == nil {
+		return nil, errors.New("ca key is not pem")
+	}
+	caKey, err := parsePrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return &CertIssuer{
+		caCert: caCert,
+		caKey:  caKey,
+		caPem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}),
+	}, nil
+}
+
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, errors.New("unsupported ca key")
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported ca key")
+	}
+	return signer, nil
+}
+
+// 签发只包含单个域名的服务端证书，证书链附带CA证书
+func (issuer *CertIssuer) Issue(domain string, validity time.Duration) (*Cert, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	//证书有效期不能超过CA
+	if template.NotAfter.After(issuer.caCert.NotAfter) {
+		template.NotAfter = issuer.caCert.NotAfter
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer.caCert, &key.PublicKey, issuer.caKey)
+	if err != nil {
+		return nil, err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return &Cert{
+		Name:      domain,
+		StoreType: CertStoreTypeDB,
+		Source:    CertSourceAuto,
+		CertPem:   string(certPem) + string(issuer.caPem),
+		KeyPem:    string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})),
+		Domains:   domain,
+		NotBefore: template.NotBefore,
+		NotAfter:  template.NotAfter,
+	}, nil
+}
+
+// 最新一张未删除的自动签发证书
+func (cert *Cert) FindAutoCert(c *gin.Context, tx *gorm.DB, domain string) (*Cert, error) {
+	model := &Cert{}
+	err := tx.WithContext(c).Where("is_delete=0 and source = ? and domains = ?", CertSourceAuto, domain).
+		Order("id desc").Limit(1).Find(model).Error
+	if err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+// 续期后删除同域名的旧证书
+func (cert *Cert) DeleteOldAutoCert(c *gin.Context, tx *gorm.DB) error {
+	return tx.WithContext(c).Model(&Cert{}).
+		Where("is_delete=0 and source = ? and domains = ? and id <> ?", CertSourceAuto, cert.Domains, cert.ID).
+		Update("is_delete", 1).Error
+}
+
+type autoCertCacheItem struct {
+	ID       int64     `json:"id"`
+	CertPem  string    `json:"cert_pem"`
+	KeyPem   string    `json:"key_pem"`
+	NotAfter time.Time `json:"not_after"`
+}
+
+func GetAutoCertValidity() time.Duration {
+	if days := lib.GetIntConf("proxy.auto_cert.valid_days"); days > 0 {
+		return time.Duration(days) * 24 * time.Hour
+	}
+	return 90 * 24 * time.Hour
+}
+
+func GetAutoCertRenewBefore() time.Duration {
+	if days := lib.GetIntConf("proxy.auto_cert.renew_before_days"); days > 0 {
+		return time.Duration(days) * 24 * time.Hour
+	}
+	return 30 * 24 * time.Hour
+}
+
+// 握手时最多等待签发的时间，超时后签发在后台继续，之后的握手使用新证书
+const autoCertHandshakeWait = 2 * time.Second
+
+// 只删除自己持有的锁，避免锁过期被其他节点获取后误删
+// KEYS[1]=lock_key ARGV: token
+var autoCertUnlockScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+var AutoCertManagerHandler *AutoCertManager
+
+func init() {
+	AutoCertManagerHandler = NewAutoCertManager()
+}
+
+// 为域名接入的服务自动签发证书，证书存db供各代理加载，redis缓存用于快速获取和跨节点加锁
+// 同一域名同时只有一个签发流程，不同域名互不影响
+type AutoCertManager struct {
+	issuer       *CertIssuer
+	issuerLocker sync.Mutex
+	locker       sync.Mutex
+	failures     map[string]time.Time
+	calls        map[string]*autoCertCall
+}
+
+// 一次进行中的签发，done关闭后cert、keyPair、err可读
+type autoCertCall struct {
+	done    chan struct{}
+	cert    *Cert
+	keyPair *tls.Certificate
+	err     error
+}
+
+func NewAutoCertManager() *AutoCertManager {
+	return &AutoCertManager{
+		failures: map[string]time.Time{},
+		calls:    map[string]*autoCertCall{},
+	}
+}
+
+func (s *AutoCertManager) Enabled() bool {
+	return lib.GetBoolConf("proxy.auto_cert.enable")
+}
+
+// CA加载成功后缓存，失败时下次调用重试
+func (s *AutoCertManager) getIssuer() (*CertIssuer, error) {
+	s.issuerLocker.Lock()
+	defer s.issuerLocker.Unlock()
+	if s.issuer != nil {
+		return s.issuer, nil
+	}
+	caCertPem, err := os.ReadFile(lib.GetStringConf("proxy.auto_cert.ca_cert_file"))
+	if err != nil {
+		return nil, err
+	}
+	caKeyPem, err := os.ReadFile(lib.GetStringConf("proxy.auto_cert.ca_key_file"))
+	if err != nil {
+		return nil, err
+	}
+	issuer, err := NewCertIssuer(caCertPem, caKeyPem)
+	if err != nil {
+		return nil, err
+	}
+	s.issuer = issuer
+	return issuer, nil
+}
+
+// 设置签发使用的CA，主要用于测试
+func (s *AutoCertManager) SetIssuer(issuer *CertIssuer) {
+	s.issuerLocker.Lock()
+	defer s.issuerLocker.Unlock()
+	s.issuer = issuer
+}
+
+// 获取域名的有效证书并加载到CertManager，缓存和db中都没有或即将过期时签发新证书，force=true时总是签发
+func (s *AutoCertManager) Ensure(c *gin.Context, domain string, force bool) (*Cert, error) {
+	call := s.startCall(c, domain, force)
+	<-call.done
+	return call.cert, call.err
+}
+
+// 同一域名已有签发流程时复用，否则在后台启动，调用方自行决定等待多久
+func (s *AutoCertManager) startCall(c *gin.Context, domain string, force bool) *autoCertCall {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	s.locker.Lock()
+	if call, ok := s.calls[domain]; ok {
+		s.locker.Unlock()
+		return call
+	}
+	call := &autoCertCall{done: make(chan struct{})}
+	s.calls[domain] = call
+	s.locker.Unlock()
+	go func() {
+		call.cert, call.keyPair, call.err = s.ensureKeyPair(c, domain, force)
+		s.locker.Lock()
+		delete(s.calls, domain)
+		if call.err != nil {
+			s.failures[domain] = time.Now()
+		} else {
+			delete(s.failures, domain)
+		}
+		s.locker.Unlock()
+		close(call.done)
+	}()
+	return call
+}
+
+func (s *AutoCertManager) ensureKeyPair(c *gin.Context, domain string, force bool) (*Cert, *tls.Certificate, error) {
+	cert, err := s.ensure(c, domain, force)
+	if err == nil {
+		var keyPair *tls.Certificate
+		if keyPair, err = cert.LoadKeyPair(); err == nil {
+			CertManagerHandler.AddCert(keyPair)
+			return cert, keyPair, nil
+		}
+	}
+	public.ContextWarning(c, "_com_auto_cert_failure", map[string]interface{}{
+		"domain": domain,
+		"err":    err.Error(),
+	})
+	return nil, nil, err
+}
+
+func (s *AutoCertManager) ensure(c *gin.Context, domain string, force bool) (*Cert, error) {
+	renewBefore := GetAutoCertRenewBefore()
+	if !force {
+		if cert := s.getFreshCert(c, domain, renewBefore); cert != nil {
+			return cert, nil
+		}
+	}
+	//同一时间只允许一个节点签发，redis不可用时各节点独立签发
+	lockKey := public.RedisAutoCertLockPrefix + domain
+	token := newAutoCertLockToken()
+	reply, err := public.RedisConfDo("SET", lockKey, token, "EX", 30, "NX")
+	if err == nil && reply == nil {
+		for i := 0; i < 25; i++ {
+			time.Sleep(200 * time.Millisecond)
+			if cert := s.getCacheCert(domain, renewBefore); cert != nil {
+				return cert, nil
+			}
+		}
+		return nil, fmt.Errorf("certificate of %s is being issued by another node", domain)
+	}
+	if err == nil {
+		defer releaseAutoCertLock(lockKey, token)
+	}
+	issuer, err := s.getIssuer()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := issuer.Issue(domain, GetAutoCertValidity())
+	if err != nil {
+		return nil, err
+	}
+	if tx, err := lib.GetGormPool("default"); err == nil {
+		if err = cert.Save(c, tx); err == nil {
+			err = cert.DeleteOldAutoCert(c, tx)
+		}
+		if err != nil {
+			logCertWarning(c, cert, err)
+		}
+	}
+	s.setCacheCert(cert)
+	if cert.ID > 0 {
+		PublishConfigChange(c, ConfigChangeTypeCert, ConfigChangeActionCreate, cert.ID)
+	}
+	public.ComLogNotice(c, "_com_auto_cert_issued", map[string]interface{}{
+		"domain":    domain,
+		"id":        cert.ID,
+		"not_after": cert.NotAfter,
+	})
+	return cert, nil
+}
+
+func newAutoCertLockToken() string {
+	token := make([]byte, 16)
+	rand.Read(token)
+	return hex.EncodeToString(token)
+}
+
+func releaseAutoCertLock(lockKey string, token string) {
+	conn, err := lib.RedisConnFactory("default")
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	autoCertUnlockScript.Do(conn, lockKey, token)
+}
+
+func (s *AutoCertManager) getFreshCert(c *gin.Context, domain string, renewBefore time.Duration) *Cert {
+	if cert := s.getCacheCert(domain, renewBefore); cert != nil {
+		return cert
+	}
+	tx, err := lib.GetGormPool("default")
+	if err != nil {
+		return nil
+	}
+	search := &Cert{}
+	cert, err := search.FindAutoCert(c, tx, domain)
+	if err != nil || cert.ID == 0 || time.Until(cert.NotAfter) <= renewBefore {
+		return nil
+	}
+	s.setCacheCert(cert)
+	return cert
+}
+
+func (s *AutoCertManager) getCacheCert(domain string, renewBefore time.Duration) *Cert {
+	data, err := redis.Bytes(public.RedisConfDo("GET", public.RedisAutoCertPrefix+domain))
+	if err != nil {
+		return nil
+	}
+	item := &autoCertCacheItem{}
+	if err := json.Unmarshal(data, item); err != nil || time.Until(item.NotAfter) <= renewBefore {
+		return nil
+	}
+	return &Cert{
+		ID:        item.ID,
+		Name:      domain,
+		StoreType: CertStoreTypeDB,
+		Source:    CertSourceAuto,
+		CertPem:   item.CertPem,
+		KeyPem:    item.KeyPem,
+		Domains:   domain,
+		NotAfter:  item.NotAfter,
+	}
+}
+
+func (s *AutoCertManager) setCacheCert(cert *Cert) {
+	ttl := int(time.Until(cert.NotAfter).Seconds())
+	if ttl <= 0 {
+		return
+	}
+	item := &autoCertCacheItem{ID: cert.ID, CertPem: cert.CertPem, KeyPem: cert.KeyPem, NotAfter: cert.NotAfter}
+	public.RedisConfDo("SET", public.RedisAutoCertPrefix+cert.Domains, public.Obj2json(item), "EX", ttl)
+}
+
+// 握手时发现域名接入的服务没有证书，启动签发并最多等待autoCertHandshakeWait，失败后一分钟内不再重试
+func (s *AutoCertManager) OnDemand(serverName string) *tls.Certificate {
+	if serverName == "" || !s.Enabled() || !isHTTPDomainRule(serverName) {
+		return nil
+	}
+	s.locker.Lock()
+	failAt, failed := s.failures[serverName]
+	s.locker.Unlock()
+	if failed && time.Since(failAt) < time.Minute {
+		return nil
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	call := s.startCall(c, serverName, false)
+	timer := time.NewTimer(autoCertHandshakeWait)
+	defer timer.Stop()
+	select {
+	case <-call.done:
+		return call.keyPair
+	case <-timer.C:
+		return nil
+	}
+}
+
+// 检查所有域名接入的服务，没有证书或证书即将过期时签发
+func (s *AutoCertManager) RenewAll() {
+	if !s.Enabled() {
+		return
+	}
+	renewBefore := GetAutoCertRenewBefore()
+	for _, domain := range httpDomainRules() {
+		//上传的证书优先，由管理员负责更新
+		if CertManagerHandler.IsUploaded(domain) {
+			continue
+		}
+		if cert := CertManagerHandler.Lookup(domain); cert != nil && time.Until(cert.Leaf.NotAfter) > renewBefore {
+			continue
+		}
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		s.Ensure(c, domain, false)
+	}
+}
+
+func StartAutoCertRenew(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		AutoCertManagerHandler.RenewAll()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			AutoCertManagerHandler.RenewAll()
+		}
+	}()
+}
+
+func httpDomainRules() []string {
+	domains := []string{}
+	for _, service := range ServiceManagerHandler.GetServiceSlice() {
+		if service.Info.LoadType != public.LoadTypeHTTP || service.HTTPRule == nil {
+			continue
+		}
+		if service.HTTPRule.RuleType == public.HTTPRuleTypeDomain {
+			domains = append(domains, strings.ToLower(service.HTTPRule.Rule))
+		}
+	}
+	return domains
+}
+
+func isHTTPDomainRule(host string) bool {
+	for _, domain := range httpDomainRules() {
+		if domain == host {
+			return true
+		}
+	}
+	return false
+}
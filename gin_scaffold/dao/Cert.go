@@ -0,0 +1,345 @@
+package dao
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"gin_scaffold/public"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/e421083458/golang_common/lib"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	CertStoreTypeDB   = 0
+	CertStoreTypeDisk = 1
+
+	CertSourceUpload = 0
+	CertSourceAuto   = 1
+)
+
+// 证书可以把pem内容存在db，也可以只记录代理机器上的文件路径
+type Cert struct {
+	ID        int64     `json:"id" gorm:"primary_key"`
+	Name      string    `json:"name" gorm:"column:name" description:"证书名称"`
+	StoreType int       `json:"store_type" gorm:"column:store_type" description:"存储方式 0=db 1=磁盘文件"`
+	Source    int       `json:"source" gorm:"column:source" description:"来源 0=上传 1=内部CA自动签发"`
+	CertPem   string    `json:"-" gorm:"column:cert_pem;type:text" description:"证书链pem, store_type=0时使用"`
+	KeyPem    string    `json:"-" gorm:"column:key_pem;type:text" description:"私钥pem, store_type=0时使用"`
+	CertFile  string    `json:"cert_file" gorm:"column:cert_file" description:"证书文件路径, store_type=1时使用"`
+	KeyFile   string    `json:"key_file" gorm:"column:key_file" description:"私钥文件路径, store_type=1时使用"`
+	Domains   string    `json:"domains" gorm:"column:domains" description:"证书包含的域名, 逗号间隔, db证书上传时解析, 磁盘证书由代理加载后写回"`
+	NotBefore time.Time `json:"not_before" gorm:"column:not_before" description:"生效时间"`
+	NotAfter  time.Time `json:"not_after" gorm:"column:not_after" description:"过期时间"`
+	CreatedAt time.Time `json:"create_at" gorm:"column:create_at" description:"添加时间"`
+	UpdatedAt time.Time `json:"update_at" gorm:"column:update_at" description:"更新时间"`
+	IsDelete  int8      `json:"is_delete" gorm:"column:is_delete" description:"是否已删除；0：否；1：是"`
+}
+
+func (cert *Cert) TableName() string {
+	return "gateway_cert"
+}
+
+func (cert *Cert) Find(c *gin.Context, tx *gorm.DB, search *Cert) (*Cert, error) {
+	model := &Cert{}
+	err := tx.WithContext(c).Where(search).Find(model).Error
+	if err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+func (cert *Cert) Save(c *gin.Context, tx *gorm.DB) error {
+	if err := tx.WithContext(c).Save(cert).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (cert *Cert) CertList(c *gin.Context, tx *gorm.DB) ([]Cert, error) {
+	list := []Cert{}
+	if err := tx.WithContext(c).Where("is_delete=0").Order("id desc").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// 读取证书和私钥，按存储方式取db中的pem或磁盘文件
+func (cert *Cert) LoadKeyPair() (*tls.Certificate, error) {
+	var certPem, keyPem []byte
+	if cert.StoreType == CertStoreTypeDisk {
+		var err error
+		if certPem, err = os.ReadFile(cert.CertFile); err != nil {
+			return nil, err
+		}
+		if keyPem, err = os.ReadFile(cert.KeyFile); err != nil {
+			return nil, err
+		}
+	} else {
+		certPem, keyPem = []byte(cert.CertPem), []byte(cert.KeyPem)
+	}
+	return ParseKeyPair(certPem, keyPem)
+}
+
+// 磁盘证书只有代理能读取，加载后把域名和有效期写回db供dashboard展示，文件被替换后同样更新
+func (cert *Cert) syncDiskInfo(c *gin.Context, tx *gorm.DB, keyPair *tls.Certificate) {
+	domains := strings.Join(CertDomains(keyPair.Leaf), ",")
+	if cert.Domains == domains && cert.NotBefore.Equal(keyPair.Leaf.NotBefore) && cert.NotAfter.Equal(keyPair.Leaf.NotAfter) {
+		return
+	}
+	err := tx.WithContext(c).Model(&Cert{}).Where("id = ?", cert.ID).Updates(map[string]interface{}{
+		"domains":    domains,
+		"not_before": keyPair.Leaf.NotBefore,
+		"not_after":  keyPair.Leaf.NotAfter,
+	}).Error
+	if err != nil {
+		logCertWarning(c, cert, err)
+	}
+}
+
+// 校验证书与私钥匹配，并解析出叶子证书
+func ParseKeyPair(certPem []byte, keyPem []byte) (*tls.Certificate, error) {
+	keyPair, err := tls.X509KeyPair(certPem, keyPem)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	keyPair.Leaf = leaf
+	return &keyPair, nil
+}
+
+// 证书包含的域名，优先使用SAN，没有SAN时使用CN
+func CertDomains(leaf *x509.Certificate) []string {
+	domains := []string{}
+	for _, name := range leaf.DNSNames {
+		domains = append(domains, strings.ToLower(name))
+	}
+	if len(domains) == 0 && leaf.Subject.CommonName != "" {
+		domains = append(domains, strings.ToLower(leaf.Subject.CommonName))
+	}
+	return domains
+}
+
+// 域名是否被证书域名覆盖，通配符只匹配一级子域名
+func CertDomainMatch(certDomain string, host string) bool {
+	certDomain = strings.ToLower(certDomain)
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if certDomain == host {
+		return true
+	}
+	if strings.HasPrefix(certDomain, "*.") {
+		if index := strings.Index(host, "."); index > 0 {
+			return host[index:] == certDomain[1:]
+		}
+	}
+	return false
+}
+
+var CertManagerHandler *CertManager
+
+func init() {
+	CertManagerHandler = NewCertManager()
+}
+
+// 按SNI选择证书，CertMap、WildcardMap、UploadedCerts只整体替换不原地修改
+type CertManager struct {
+	CertMap       map[string]*tls.Certificate
+	WildcardMap   map[string]*tls.Certificate
+	UploadedCerts map[*tls.Certificate]bool
+	DefaultCert   *tls.Certificate
+	Locker        sync.RWMutex
+	init          sync.Once
+	err           error
+	reloadLocker  sync.Mutex
+}
+
+func NewCertManager() *CertManager {
+	return &CertManager{
+		CertMap:       map[string]*tls.Certificate{},
+		WildcardMap:   map[string]*tls.Certificate{},
+		UploadedCerts: map[*tls.Certificate]bool{},
+	}
+}
+
+func (s *CertManager) LoadOnce() error {
+	s.init.Do(func() {
+		s.err = s.Reload()
+	})
+	return s.err
+}
+
+// 从db和磁盘重新加载全部证书，单个证书加载失败只跳过该证书
+func (s *CertManager) Reload() error {
+	s.reloadLocker.Lock()
+	defer s.reloadLocker.Unlock()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	tx, err := lib.GetGormPool("default")
+	if err != nil {
+		return err
+	}
+	certInfo := &Cert{}
+	list, err := certInfo.CertList(c, tx)
+	if err != nil {
+		return err
+	}
+	s.setCerts(c, tx, list)
+	return nil
+}
+
+func (s *CertManager) setCerts(c *gin.Context, tx *gorm.DB, list []Cert) {
+	certMap := map[string]*tls.Certificate{}
+	wildcardMap := map[string]*tls.Certificate{}
+	uploadedCerts := map[*tls.Certificate]bool{}
+	//先加载上传的证书，自动签发的证书只用于没有上传证书覆盖的域名
+	for _, source := range []int{CertSourceUpload, CertSourceAuto} {
+		//按id倒序，同一域名以最新的证书为准
+		for _, item := range list {
+			if item.Source != source {
+				continue
+			}
+			keyPair, err := item.LoadKeyPair()
+			if err != nil {
+				logCertWarning(c, &item, err)
+				continue
+			}
+			if source == CertSourceUpload {
+				uploadedCerts[keyPair] = true
+			}
+			if item.StoreType == CertStoreTypeDisk {
+				item.syncDiskInfo(c, tx, keyPair)
+			}
+			for _, domain := range CertDomains(keyPair.Leaf) {
+				if source == CertSourceAuto && uploadedCertFor(certMap, wildcardMap, uploadedCerts, domain) != nil {
+					continue
+				}
+				target := certMap
+				if strings.HasPrefix(domain, "*.") {
+					target, domain = wildcardMap, domain[1:]
+				}
+				if _, ok := target[domain]; !ok {
+					target[domain] = keyPair
+				}
+			}
+		}
+	}
+	var defaultCert *tls.Certificate
+	certFile := lib.GetStringConf("proxy.https.default_cert_file")
+	keyFile := lib.GetStringConf("proxy.https.default_key_file")
+	if certFile != "" && keyFile != "" {
+		defaultItem := &Cert{Name: "default", StoreType: CertStoreTypeDisk, CertFile: certFile, KeyFile: keyFile}
+		if keyPair, err := defaultItem.LoadKeyPair(); err == nil {
+			defaultCert = keyPair
+		} else {
+			logCertWarning(c, defaultItem, err)
+		}
+	}
+	s.Locker.Lock()
+	s.CertMap = certMap
+	s.WildcardMap = wildcardMap
+	s.UploadedCerts = uploadedCerts
+	s.DefaultCert = defaultCert
+	s.Locker.Unlock()
+}
+
+// 覆盖域名的上传证书，精确匹配或通配符匹配
+func uploadedCertFor(certMap, wildcardMap map[string]*tls.Certificate, uploadedCerts map[*tls.Certificate]bool, domain string) *tls.Certificate {
+	if cert, ok := certMap[domain]; ok && uploadedCerts[cert] {
+		return cert
+	}
+	if index := strings.Index(domain, "."); index > 0 {
+		if cert, ok := wildcardMap[domain[index:]]; ok && uploadedCerts[cert] {
+			return cert
+		}
+	}
+	return nil
+}
+
+// 用于tls.Config.GetCertificate，先精确匹配，再匹配通配符，域名接入的服务没有证书时由内部CA签发，最后使用默认证书
+func (s *CertManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	serverName := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if cert := s.Lookup(serverName); cert != nil {
+		return cert, nil
+	}
+	if cert := AutoCertManagerHandler.OnDemand(serverName); cert != nil {
+		return cert, nil
+	}
+	s.Locker.RLock()
+	defer s.Locker.RUnlock()
+	if s.DefaultCert != nil {
+		return s.DefaultCert, nil
+	}
+	return nil, fmt.Errorf("no certificate for %q", hello.ServerName)
+}
+
+// 查找覆盖域名的证书，不含默认证书
+func (s *CertManager) Lookup(serverName string) *tls.Certificate {
+	s.Locker.RLock()
+	defer s.Locker.RUnlock()
+	if cert, ok := s.CertMap[serverName]; ok {
+		return cert
+	}
+	if index := strings.Index(serverName, "."); index > 0 {
+		if cert, ok := s.WildcardMap[serverName[index:]]; ok {
+			return cert
+		}
+	}
+	return nil
+}
+
+// 域名是否由上传的证书提供服务
+func (s *CertManager) IsUploaded(serverName string) bool {
+	s.Locker.RLock()
+	defer s.Locker.RUnlock()
+	return uploadedCertFor(s.CertMap, s.WildcardMap, s.UploadedCerts, serverName) != nil
+}
+
+// 新签发的证书立即生效，不必等待全量重新加载，已由上传证书覆盖的域名不替换
+func (s *CertManager) AddCert(keyPair *tls.Certificate) {
+	s.Locker.Lock()
+	defer s.Locker.Unlock()
+	certMap := make(map[string]*tls.Certificate, len(s.CertMap)+1)
+	for domain, cert := range s.CertMap {
+		certMap[domain] = cert
+	}
+	wildcardMap := make(map[string]*tls.Certificate, len(s.WildcardMap))
+	for domain, cert := range s.WildcardMap {
+		wildcardMap[domain] = cert
+	}
+	for _, domain := range CertDomains(keyPair.Leaf) {
+		if uploadedCertFor(s.CertMap, s.WildcardMap, s.UploadedCerts, domain) != nil {
+			continue
+		}
+		if strings.HasPrefix(domain, "*.") {
+			wildcardMap[domain[1:]] = keyPair
+			continue
+		}
+		certMap[domain] = keyPair
+	}
+	s.CertMap = certMap
+	s.WildcardMap = wildcardMap
+}
+
+// 当前已加载的证书数量，不含默认证书
+func (s *CertManager) CertCount() int {
+	s.Locker.RLock()
+	defer s.Locker.RUnlock()
+	return len(s.CertMap) + len(s.WildcardMap)
+}
+
+func logCertWarning(c *gin.Context, cert *Cert, err error) {
+	public.ContextWarning(c, "_com_cert_load_failure", map[string]interface{}{
+		"id":        cert.ID,
+		"name":      cert.Name,
+		"cert_file": cert.CertFile,
+		"err":       err.Error(),
+	})
+}
@@ -0,0 +1,219 @@
+package dao
+
+import (
+	"context"
+	"gin_scaffold/dto"
+	"gin_scaffold/public"
+	"gin_scaffold/reverse_proxy/load_balance"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CircuitBreakerRule struct {
+	ID                  int64     `json:"id" gorm:"primary_key"`
+	ServiceID           int64     `json:"service_id" gorm:"column:service_id" description:"服务id"`
+	Window              int       `json:"window" gorm:"column:window" description:"统计滑动窗口, 单位s, 0=10s"`
+	MinRequests         int       `json:"min_requests" gorm:"column:min_requests" description:"窗口内请求数达到该值才判断是否熔断, 0=20"`
+	ErrorRate           int       `json:"error_rate" gorm:"column:error_rate" description:"错误率阈值, 百分比, 连接错误和5xx计为错误, 0=不按错误率熔断"`
+	SlowCallRate        int       `json:"slow_call_rate" gorm:"column:slow_call_rate" description:"慢调用率阈值, 百分比, 0=不按慢调用率熔断"`
+	SlowCallMs          int       `json:"slow_call_ms" gorm:"column:slow_call_ms" description:"耗时超过该值计为慢调用, 单位ms"`
+	OpenTime            int       `json:"open_time" gorm:"column:open_time" description:"熔断持续时间, 单位s, 之后放行探测请求, 0=30s"`
+	HalfOpenRequests    int       `json:"half_open_requests" gorm:"column:half_open_requests" description:"半开状态放行的探测请求数, 全部成功后恢复, 0=5"`
+	FallbackStatus      int       `json:"fallback_status" gorm:"column:fallback_status" description:"熔断时返回的http状态码, 0=503"`
+	FallbackContentType string    `json:"fallback_content_type" gorm:"column:fallback_content_type" description:"熔断时返回的Content-Type"`
+	FallbackBody        string    `json:"fallback_body" gorm:"column:fallback_body;type:text" description:"熔断时返回的内容, 为空返回网关错误信息"`
+	UpdatedAt           time.Time `json:"update_at" gorm:"column:update_at" description:"更新时间"`
+	CreatedAt           time.Time `json:"create_at" gorm:"column:create_at" description:"添加时间"`
+	IsDelete            int8      `json:"is_delete" gorm:"column:is_delete" description:"是否已删除；0：否；1：是"`
+}
+
+func (rule *CircuitBreakerRule) TableName() string {
+	return "gateway_service_circuit_breaker"
+}
+
+func (rule *CircuitBreakerRule) Find(c *gin.Context, tx *gorm.DB, search *CircuitBreakerRule) (*CircuitBreakerRule, error) {
+	model := &CircuitBreakerRule{}
+	err := tx.WithContext(c).Where(search).Find(model).Error
+	if err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+func (rule *CircuitBreakerRule) Save(c *gin.Context, tx *gorm.DB) error {
+	if err := tx.WithContext(c).Save(rule).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// 服务当前生效的熔断规则，没有时返回nil
+func (rule *CircuitBreakerRule) FindByServiceID(c *gin.Context, tx *gorm.DB, serviceID int64) (*CircuitBreakerRule, error) {
+	model := &CircuitBreakerRule{}
+	err := tx.WithContext(c).Where("service_id = ? and is_delete = 0", serviceID).Order("id desc").Limit(1).Find(model).Error
+	if err != nil {
+		return nil, err
+	}
+	if model.ID == 0 {
+		return nil, nil
+	}
+	return model, nil
+}
+
+func (rule *CircuitBreakerRule) PageList(c *gin.Context, tx *gorm.DB, param *dto.CircuitBreakerListInput) ([]CircuitBreakerRule, int64, error) {
+	total := int64(0)
+	list := []CircuitBreakerRule{}
+	offset := (param.PageNo - 1) * param.PageSize
+	query := tx.WithContext(c).Table(rule.TableName()).Where("is_delete=0")
+	if param.ServiceID > 0 {
+		query = query.Where("service_id = ?", param.ServiceID)
+	}
+	query = query.Session(&gorm.Session{})
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := query.Limit(param.PageSize).Offset(offset).Order("id desc").Find(&list).Error; err != nil && err != gorm.ErrRecordNotFound {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+func (rule *CircuitBreakerRule) GetBreakerConf() load_balance.CircuitBreakerConf {
+	return load_balance.CircuitBreakerConf{
+		Window:           time.Duration(rule.Window) * time.Second,
+		MinRequests:      rule.MinRequests,
+		ErrorRate:        rule.ErrorRate,
+		SlowCallRate:     rule.SlowCallRate,
+		SlowCallDuration: time.Duration(rule.SlowCallMs) * time.Millisecond,
+		OpenTime:         time.Duration(rule.OpenTime) * time.Second,
+		HalfOpenMax:      rule.HalfOpenRequests,
+	}
+}
+
+// 只使用200-599的状态码，其他值按503处理
+func (rule *CircuitBreakerRule) GetFallbackStatus() int {
+	if rule.FallbackStatus >= 200 && rule.FallbackStatus <= 599 {
+		return rule.FallbackStatus
+	}
+	return http.StatusServiceUnavailable
+}
+
+var CircuitBreakerHandler *CircuitBreakerManager
+
+func init() {
+	CircuitBreakerHandler = NewCircuitBreakerManager()
+}
+
+// 服务级熔断器以及各节点的熔断器，节点熔断器在首次请求该节点时创建
+type CircuitBreakerItem struct {
+	ServiceName string
+	conf        load_balance.CircuitBreakerConf
+	service     *load_balance.CircuitBreaker
+	nodes       map[string]*load_balance.CircuitBreaker
+	locker      sync.Mutex
+}
+
+func (item *CircuitBreakerItem) Service() *load_balance.CircuitBreaker {
+	return item.service
+}
+
+func (item *CircuitBreakerItem) Node(addr string) *load_balance.CircuitBreaker {
+	item.locker.Lock()
+	defer item.locker.Unlock()
+	breaker, ok := item.nodes[addr]
+	if !ok {
+		breaker = load_balance.NewCircuitBreaker(item.conf, item.logChange(addr))
+		item.nodes[addr] = breaker
+	}
+	return breaker
+}
+
+// 丢弃已不在ip_list中的节点熔断器，节点重新加入时从关闭状态开始
+func (item *CircuitBreakerItem) pruneNodes(addrs []string) {
+	keep := map[string]bool{}
+	for _, addr := range addrs {
+		keep[addr] = true
+	}
+	item.locker.Lock()
+	defer item.locker.Unlock()
+	for addr := range item.nodes {
+		if !keep[addr] {
+			delete(item.nodes, addr)
+		}
+	}
+}
+
+func (item *CircuitBreakerItem) logChange(addr string) func(from, to load_balance.BreakerState) {
+	return func(from, to load_balance.BreakerState) {
+		public.ContextWarning(context.Background(), "_com_circuit_breaker_change", map[string]interface{}{
+			"service": item.ServiceName,
+			"node":    addr,
+			"from":    from.String(),
+			"to":      to.String(),
+		})
+	}
+}
+
+type CircuitBreakerManager struct {
+	BreakerMap map[string]*CircuitBreakerItem
+	Locker     sync.RWMutex
+}
+
+func NewCircuitBreakerManager() *CircuitBreakerManager {
+	return &CircuitBreakerManager{
+		BreakerMap: map[string]*CircuitBreakerItem{},
+	}
+}
+
+// 获取服务的熔断器，未配置熔断规则时返回nil，熔断参数变化时重新构建
+func (m *CircuitBreakerManager) GetItem(service *ServiceDetial) *CircuitBreakerItem {
+	if service.CircuitBreaker == nil {
+		return nil
+	}
+	conf := service.CircuitBreaker.GetBreakerConf()
+	serviceName := service.Info.ServiceName
+	m.Locker.RLock()
+	item, ok := m.BreakerMap[serviceName]
+	m.Locker.RUnlock()
+	if ok && item.conf == conf {
+		return item
+	}
+	m.Locker.Lock()
+	defer m.Locker.Unlock()
+	if item, ok := m.BreakerMap[serviceName]; ok && item.conf == conf {
+		return item
+	}
+	item = &CircuitBreakerItem{
+		ServiceName: serviceName,
+		conf:        conf,
+		nodes:       map[string]*load_balance.CircuitBreaker{},
+	}
+	item.service = load_balance.NewCircuitBreaker(conf, item.logChange(""))
+	m.BreakerMap[serviceName] = item
+	return item
+}
+
+// 服务删除或熔断规则变化时丢弃旧的熔断状态，规则不变时只丢弃已下线节点的熔断器
+func (m *CircuitBreakerManager) OnServiceChange(change *ServiceChange) {
+	m.Locker.Lock()
+	defer m.Locker.Unlock()
+	services := append(append([]*ServiceDetial{}, change.Updated...), change.Removed...)
+	for index, service := range services {
+		item, ok := m.BreakerMap[service.Info.ServiceName]
+		if !ok {
+			continue
+		}
+		isRemoved := index >= len(change.Updated)
+		if !isRemoved && service.CircuitBreaker != nil && item.conf == service.CircuitBreaker.GetBreakerConf() {
+			if service.LoadBalance != nil {
+				item.pruneNodes(service.LoadBalance.GetIPlistByModel())
+			}
+			continue
+		}
+		delete(m.BreakerMap, service.Info.ServiceName)
+	}
+}
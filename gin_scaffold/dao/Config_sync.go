@@ -0,0 +1,190 @@
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"gin_scaffold/public"
+	"sync/atomic"
+	"time"
+
+	"github.com/e421083458/golang_common/lib"
+	"github.com/garyburd/redigo/redis"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ConfigChangeTypeService = "service"
+	ConfigChangeTypeApp     = "app"
+	ConfigChangeTypeCert    = "cert"
+
+	ConfigChangeActionCreate = "create"
+	ConfigChangeActionUpdate = "update"
+	ConfigChangeActionDelete = "delete"
+)
+
+// dashboard发布、代理订阅的配置变更事件
+type ConfigChangeEvent struct {
+	Type    string `json:"type"`
+	Action  string `json:"action"`
+	ID      int64  `json:"id"`
+	Version int64  `json:"version"`
+}
+
+// 代理当前已应用的配置版本
+var configVersion int64
+
+func GetConfigVersion() int64 {
+	return atomic.LoadInt64(&configVersion)
+}
+
+func setConfigVersion(version int64) {
+	for {
+		current := atomic.LoadInt64(&configVersion)
+		if version <= current || atomic.CompareAndSwapInt64(&configVersion, current, version) {
+			return
+		}
+	}
+}
+
+// dashboard修改服务、租户或证书后调用，失败时依赖代理的定时全量同步兜底
+func PublishConfigChange(c *gin.Context, changeType string, action string, id int64) {
+	version, err := redis.Int64(public.RedisConfDo("INCR", public.ConfigVersionKey))
+	if err != nil {
+		public.ContextWarning(c, "_com_config_publish_failure", map[string]interface{}{
+			"type": changeType,
+			"id":   id,
+			"err":  err.Error(),
+		})
+		return
+	}
+	event := &ConfigChangeEvent{Type: changeType, Action: action, ID: id, Version: version}
+	if _, err := public.RedisConfDo("PUBLISH", public.ConfigChangeChannel, public.Obj2json(event)); err != nil {
+		public.ContextWarning(c, "_com_config_publish_failure", map[string]interface{}{
+			"type": changeType,
+			"id":   id,
+			"err":  err.Error(),
+		})
+	}
+}
+
+// 代理启动配置同步：订阅变更事件增量更新，并定时全量同步
+func StartConfigSync(resyncInterval time.Duration) {
+	go func() {
+		for {
+			if err := subscribeConfigChange(); err != nil {
+				public.ContextWarning(context.Background(), "_com_config_subscribe_failure", map[string]interface{}{
+					"err": err.Error(),
+				})
+			}
+			time.Sleep(time.Second)
+			//断线期间可能丢失事件，重连后全量同步一次
+			FullResync()
+		}
+	}()
+	if resyncInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(resyncInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			FullResync()
+		}
+	}()
+}
+
+// 全量重新加载服务、租户与证书
+func FullResync() {
+	version, err := redis.Int64(public.RedisConfDo("GET", public.ConfigVersionKey))
+	if err != nil && err != redis.ErrNil {
+		public.ContextWarning(context.Background(), "_com_config_resync_failure", map[string]interface{}{
+			"err": err.Error(),
+		})
+		return
+	}
+	if err := ServiceManagerHandler.Reload(); err != nil {
+		public.ContextWarning(context.Background(), "_com_config_resync_failure", map[string]interface{}{
+			"err": err.Error(),
+		})
+		return
+	}
+	if err := AppManagerHandler.Reload(); err != nil {
+		public.ContextWarning(context.Background(), "_com_config_resync_failure", map[string]interface{}{
+			"err": err.Error(),
+		})
+		return
+	}
+	if err := CertManagerHandler.Reload(); err != nil {
+		public.ContextWarning(context.Background(), "_com_config_resync_failure", map[string]interface{}{
+			"err": err.Error(),
+		})
+		return
+	}
+	setConfigVersion(version)
+}
+
+func subscribeConfigChange() error {
+	conn, err := lib.RedisConnFactory("default")
+	if err != nil {
+		return err
+	}
+	psc := redis.PubSubConn{Conn: conn}
+	defer psc.Close()
+	if err := psc.Subscribe(public.ConfigChangeChannel); err != nil {
+		return err
+	}
+	//定时ping，及时发现断开的连接
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				psc.Ping("")
+			}
+		}
+	}()
+	for {
+		switch msg := psc.ReceiveWithTimeout(30 * time.Second).(type) {
+		case error:
+			return msg
+		case redis.Message:
+			event := &ConfigChangeEvent{}
+			if err := json.Unmarshal(msg.Data, event); err != nil {
+				continue
+			}
+			applyConfigChange(event)
+		}
+	}
+}
+
+func applyConfigChange(event *ConfigChangeEvent) {
+	//版本不连续说明丢失了事件，直接全量同步
+	if event.Version > GetConfigVersion()+1 && GetConfigVersion() != 0 {
+		FullResync()
+		return
+	}
+	var err error
+	switch event.Type {
+	case ConfigChangeTypeService:
+		err = ServiceManagerHandler.ReloadService(event.ID)
+	case ConfigChangeTypeApp:
+		err = AppManagerHandler.ReloadApp(event.ID)
+	case ConfigChangeTypeCert:
+		//证书数量少，任一证书变更都整体重建索引
+		err = CertManagerHandler.Reload()
+	}
+	if err != nil {
+		public.ContextWarning(context.Background(), "_com_config_apply_failure", map[string]interface{}{
+			"type": event.Type,
+			"id":   event.ID,
+			"err":  err.Error(),
+		})
+		return
+	}
+	setConfigVersion(event.Version)
+}
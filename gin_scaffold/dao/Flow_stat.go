@@ -0,0 +1,105 @@
+package dao
+
+import (
+	"gin_scaffold/public"
+	"net/http/httptest"
+	"time"
+
+	"github.com/e421083458/golang_common/lib"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 按小时持久化的流量统计，name为计数器名称，如flow_total、flow_service_xxx、flow_app_xxx
+type FlowStat struct {
+	ID           int64     `json:"id" gorm:"primary_key"`
+	Name         string    `json:"name" gorm:"column:name;uniqueIndex:idx_name_date_hour" description:"计数器名称"`
+	StatDate     string    `json:"stat_date" gorm:"column:stat_date;uniqueIndex:idx_name_date_hour" description:"统计日期 格式2006-01-02"`
+	Hour         int       `json:"hour" gorm:"column:hour;uniqueIndex:idx_name_date_hour" description:"小时 0-23"`
+	RequestCount int64     `json:"request_count" gorm:"column:request_count" description:"请求量"`
+	ErrorCount   int64     `json:"error_count" gorm:"column:error_count" description:"错误量"`
+	P95          int64     `json:"p95" gorm:"column:p95" description:"p95耗时, 单位ms"`
+	UpdatedAt    time.Time `json:"update_at" gorm:"column:update_at" description:"更新时间"`
+}
+
+func (f *FlowStat) TableName() string {
+	return "gateway_flow_stat"
+}
+
+// 按name+日期+小时覆盖写入，数据来自redis中的集群汇总，多个代理重复写入结果一致
+func (f *FlowStat) Upsert(c *gin.Context, tx *gorm.DB) error {
+	return tx.WithContext(c).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}, {Name: "stat_date"}, {Name: "hour"}},
+		DoUpdates: clause.AssignmentColumns([]string{"request_count", "error_count", "p95", "update_at"}),
+	}).Create(f).Error
+}
+
+// 查询[startDate, endDate]之间的小时数据
+func (f *FlowStat) RangeList(c *gin.Context, tx *gorm.DB, name string, startDate string, endDate string) ([]FlowStat, error) {
+	list := []FlowStat{}
+	err := tx.WithContext(c).Where("name = ? and stat_date >= ? and stat_date <= ?", name, startDate, endDate).
+		Order("stat_date asc, hour asc").Find(&list).Error
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// 定时把本实例见过的计数器的当前小时和上一小时数据写入db
+// 未开启时db中没有数据，日期范围查询只能从redis读到当天的数据
+func StartFlowStatPersist(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			PersistFlowStat(time.Now())
+		}
+	}()
+}
+
+func PersistFlowStat(now time.Time) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	tx, err := lib.GetGormPool("default")
+	if err != nil {
+		public.ContextWarning(c, "_com_flow_stat_persist_failure", map[string]interface{}{
+			"err": err.Error(),
+		})
+		return
+	}
+	//上一小时的最后一批计数可能在整点之后才刷入redis
+	hours := []time.Time{now.Add(-time.Hour), now}
+	for _, counter := range public.FlowCounterHandler.GetCounterList() {
+		for _, hour := range hours {
+			stat, err := counter.GetHourStat(hour)
+			if err != nil {
+				public.ContextWarning(c, "_com_flow_stat_persist_failure", map[string]interface{}{
+					"name": counter.Name,
+					"err":  err.Error(),
+				})
+				continue
+			}
+			if stat.RequestCount == 0 && stat.ErrorCount == 0 {
+				continue
+			}
+			hour = hour.In(public.FlowCountLocation())
+			item := &FlowStat{
+				Name:         counter.Name,
+				StatDate:     hour.Format("2006-01-02"),
+				Hour:         hour.Hour(),
+				RequestCount: stat.RequestCount,
+				ErrorCount:   stat.ErrorCount,
+				P95:          stat.P95,
+			}
+			if err := item.Upsert(c, tx); err != nil {
+				public.ContextWarning(c, "_com_flow_stat_persist_failure", map[string]interface{}{
+					"name": counter.Name,
+					"err":  err.Error(),
+				})
+			}
+		}
+	}
+}
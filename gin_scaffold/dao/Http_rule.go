@@ -1,20 +1,65 @@
 package dao
 
 import (
+	"gin_scaffold/public"
+	"regexp"
+	"strings"
+
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type HttpRule struct {
-	ID             int64  `json:"id" gorm:"primary_key"`
-	ServiceID      int64  `json:"service_id" gorm:"column:service_id" description:"服务id"`
-	RuleType       int    `json:"rule_type" gorm:"column:rule_type" description:"匹配类型 domain=域名, url_prefix=url前缀"`
-	Rule           string `json:"rule" gorm:"column:rule" description:"type=domain表示域名，type=url_prefix时表示url前缀"`
-	NeedHttps      int    `json:"need_https" gorm:"column:need_https" description:"type=支持https 1=支持"`
-	NeedWebsocket  int    `json:"need_websocket" gorm:"column:need_websocket" description:"启用websocket 1=启用"`
-	NeedStripUri   int    `json:"need_strip_uri" gorm:"column:need_strip_uri" description:"启用strip_uri 1=启用"`
-	UrlRewrite     string `json:"url_rewrite" gorm:"column:url_rewrite" description:"url重写功能，每行一个	"`
-	HeaderTransfor string `json:"header_transfor" gorm:"column:header_transfor" description:"header转换支持增加(add)、删除(del)、修改(edit) 格式: add headname headvalue	"`
+	ID                    int64  `json:"id" gorm:"primary_key"`
+	ServiceID             int64  `json:"service_id" gorm:"column:service_id" description:"服务id"`
+	RuleType              int    `json:"rule_type" gorm:"column:rule_type" description:"匹配类型 domain=域名, url_prefix=url前缀"`
+	Rule                  string `json:"rule" gorm:"column:rule" description:"type=domain表示域名，type=url_prefix时表示url前缀"`
+	NeedHttps             int    `json:"need_https" gorm:"column:need_https" description:"type=支持https 1=支持"`
+	HstsMaxAge            int    `json:"hsts_max_age" gorm:"column:hsts_max_age" description:"https响应的HSTS max-age, 单位s, 0=不下发"`
+	HstsIncludeSubdomains int    `json:"hsts_include_subdomains" gorm:"column:hsts_include_subdomains" description:"HSTS是否包含子域名 1=包含"`
+	NeedWebsocket         int    `json:"need_websocket" gorm:"column:need_websocket" description:"启用websocket 1=启用"`
+	WebsocketMaxConn      int    `json:"websocket_max_conn" gorm:"column:websocket_max_conn" description:"websocket最大并发连接数, 0=不限制"`
+	WebsocketIdleTimeout  int    `json:"websocket_idle_timeout" gorm:"column:websocket_idle_timeout" description:"websocket空闲超时, 单位s, 0=使用默认配置"`
+	NeedStripUri          int    `json:"need_strip_uri" gorm:"column:need_strip_uri" description:"启用strip_uri 1=启用"`
+	UrlRewrite            string `json:"url_rewrite" gorm:"column:url_rewrite" description:"url重写功能，每行一个	"`
+	HeaderTransfor        string `json:"header_transfor" gorm:"column:header_transfor" description:"header转换支持增加(add)、删除(del)、修改(edit) 格式: add headname headvalue	"`
+
+	urlRewriteList []*UrlRewriteItem `gorm:"-"`
+}
+
+// 预编译的url重写规则
+type UrlRewriteItem struct {
+	Regexp  *regexp.Regexp
+	Replace string
+}
+
+// 加载服务时编译一次，格式不正确的行直接忽略
+func (http *HttpRule) compileUrlRewrite() {
+	http.urlRewriteList = ParseUrlRewrite(http.UrlRewrite)
+}
+
+func (http *HttpRule) GetUrlRewriteList() []*UrlRewriteItem {
+	if http.urlRewriteList == nil && http.UrlRewrite != "" {
+		return ParseUrlRewrite(http.UrlRewrite)
+	}
+	return http.urlRewriteList
+}
+
+// 每行一条规则，格式: 正则 替换内容
+func ParseUrlRewrite(rule string) []*UrlRewriteItem {
+	list := []*UrlRewriteItem{}
+	for _, line := range strings.Split(rule, "\n") {
+		items := strings.Fields(line)
+		if len(items) != 2 {
+			continue
+		}
+		regex, err := regexp.Compile(items[0])
+		if err != nil {
+			continue
+		}
+		list = append(list, &UrlRewriteItem{Regexp: regex, Replace: items[1]})
+	}
+	return list
 }
 
 func (http *HttpRule) TableName() string {
@@ -36,3 +81,23 @@ func (http *HttpRule) Save(c *gin.Context, tx *gorm.DB) error {
 	}
 	return nil
 }
+
+// 域名接入的服务，用于展示证书覆盖了哪些服务
+type DomainRuleItem struct {
+	ServiceID   int64  `json:"service_id" gorm:"column:service_id"`
+	ServiceName string `json:"service_name" gorm:"column:service_name"`
+	Rule        string `json:"rule" gorm:"column:rule"`
+}
+
+func (http *HttpRule) DomainRuleList(c *gin.Context, tx *gorm.DB) ([]DomainRuleItem, error) {
+	list := []DomainRuleItem{}
+	err := tx.WithContext(c).Table(http.TableName()+" as r").
+		Select("r.service_id, s.service_name, r.rule").
+		Joins("join gateway_service_info as s on s.id = r.service_id").
+		Where("s.is_delete = 0 and r.rule_type = ?", public.HTTPRuleTypeDomain).
+		Order("r.service_id asc").Find(&list).Error
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
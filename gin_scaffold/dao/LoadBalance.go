@@ -1,27 +1,59 @@
 package dao
 
 import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gin_scaffold/public"
+	"gin_scaffold/reverse_proxy/load_balance"
+	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/e421083458/golang_common/lib"
+	"github.com/garyburd/redigo/redis"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type LoadBalance struct {
-	ID            int64  `json:"id" gorm:"primary_key"`
-	ServiceID     int64  `json:"service_id" gorm:"column:service_id" description:"服务id	"`
-	CheckMethod   int    `json:"check_method" gorm:"column:check_method" description:"检查方法 tcpchk=检测端口是否握手成功	"`
-	CheckTimeout  int    `json:"check_timeout" gorm:"column:check_timeout" description:"check超时时间	"`
-	CheckInterval int    `json:"check_interval" gorm:"column:check_interval" description:"检查间隔, 单位s		"`
-	RoundType     int    `json:"round_type" gorm:"column:round_type" description:"轮询方式 round/weight_round/random/ip_hash"`
-	IpList        string `json:"ip_list" gorm:"column:ip_list" description:"ip列表"`
-	WeightList    string `json:"weight_list" gorm:"column:weight_list" description:"权重列表"`
-	ForbidList    string `json:"forbid_list" gorm:"column:forbid_list" description:"禁用ip列表"`
+	ID                int64  `json:"id" gorm:"primary_key"`
+	ServiceID         int64  `json:"service_id" gorm:"column:service_id" description:"服务id	"`
+	CheckMethod       int    `json:"check_method" gorm:"column:check_method" description:"检查方法 0=tcpchk检测端口是否握手成功 1=httpchk检测http状态码	"`
+	CheckTimeout      int    `json:"check_timeout" gorm:"column:check_timeout" description:"check超时时间	"`
+	CheckInterval     int    `json:"check_interval" gorm:"column:check_interval" description:"检查间隔, 单位s, 0=不检查		"`
+	CheckPath         string `json:"check_path" gorm:"column:check_path" description:"httpchk检查路径"`
+	CheckExpectStatus int    `json:"check_expect_status" gorm:"column:check_expect_status" description:"httpchk期望状态码, 默认200"`
+	RoundType         int    `json:"round_type" gorm:"column:round_type" description:"轮询方式 round/weight_round/random/ip_hash"`
+	HashKey           string `json:"hash_key" gorm:"column:hash_key" description:"一致性hash取值 为空=客户端ip header:名称 cookie:名称"`
+	IpList            string `json:"ip_list" gorm:"column:ip_list" description:"ip列表"`
+	WeightList        string `json:"weight_list" gorm:"column:weight_list" description:"权重列表"`
+	ForbidList        string `json:"forbid_list" gorm:"column:forbid_list" description:"禁用ip列表"`
 
 	UpstreamConnectTimeout int `json:"upstream_connect_timeout" gorm:"column:upstream_connect_timeout" description:"下游建立连接超时, 单位s"`
 	UpstreamHeaderTimeout  int `json:"upstream_header_timeout" gorm:"column:upstream_header_timeout" description:"下游获取header超时, 单位s	"`
 	UpstreamIdleTimeout    int `json:"upstream_idle_timeout" gorm:"column:upstream_idle_timeout" description:"下游链接最大空闲时间, 单位s	"`
 	UpstreamMaxIdle        int `json:"upstream_max_idle" gorm:"column:upstream_max_idle" description:"下游最大空闲链接数"`
+
+	UpstreamTls        int    `json:"upstream_tls" gorm:"column:upstream_tls" description:"下游是否使用tls 1=使用, 仅http和grpc服务"`
+	UpstreamCaCert     string `json:"upstream_ca_cert" gorm:"column:upstream_ca_cert;type:text" description:"校验下游证书的CA, pem内容或文件路径, 为空使用系统根证书"`
+	UpstreamClientCert string `json:"upstream_client_cert" gorm:"column:upstream_client_cert;type:text" description:"向下游出示的客户端证书, pem内容或文件路径, 为空不出示"`
+	UpstreamClientKey  string `json:"-" gorm:"column:upstream_client_key;type:text" description:"客户端证书私钥, pem内容或文件路径"`
+	UpstreamServerName string `json:"upstream_server_name" gorm:"column:upstream_server_name" description:"校验下游证书时使用的域名, 为空使用节点地址"`
+
+	RetryMaxAttempts   int    `json:"retry_max_attempts" gorm:"column:retry_max_attempts" description:"最多尝试次数, 含首次请求, 0或1=不重试, 仅http服务"`
+	RetryOn            string `json:"retry_on" gorm:"column:retry_on" description:"重试条件 connect_error,reset,502,503,504 逗号间隔, 为空=全部"`
+	RetryNonIdempotent int    `json:"retry_non_idempotent" gorm:"column:retry_non_idempotent" description:"1=POST/PATCH等非幂等请求也重试"`
+	RetryBackoff       int    `json:"retry_backoff" gorm:"column:retry_backoff" description:"重试退避基数, 每次翻倍并随机抖动, 单位ms, 0=25ms"`
+	RetryMaxBackoff    int    `json:"retry_max_backoff" gorm:"column:retry_max_backoff" description:"重试退避上限, 单位ms, 0=250ms"`
+	RetryBudget        int    `json:"retry_budget" gorm:"column:retry_budget" description:"集群重试预算, 重试请求占服务qps的百分比, 0=使用proxy.retry.budget_percent"`
+
+	upstreamTLSConfig *tls.Config `gorm:"-"`
 }
 
 func (l *LoadBalance) TableName() string {
@@ -43,6 +75,450 @@ func (l *LoadBalance) Save(c *gin.Context, tx *gorm.DB) error {
 	return nil
 }
 
+// 按证书内容缓存tls配置，配置未变化时重复加载得到同一个指针，下游连接池可以继续复用
+var upstreamTLSCache sync.Map
+
+// 服务变更后只保留仍被服务使用的tls配置，调用方需持有ServiceManager的reloadLocker
+func pruneUpstreamTLSCache(services []*ServiceDetial) {
+	inUse := map[*tls.Config]bool{}
+	for _, service := range services {
+		if service.LoadBalance != nil && service.LoadBalance.upstreamTLSConfig != nil {
+			inUse[service.LoadBalance.upstreamTLSConfig] = true
+		}
+	}
+	upstreamTLSCache.Range(func(key, value interface{}) bool {
+		if !inUse[value.(*tls.Config)] {
+			upstreamTLSCache.Delete(key)
+		}
+		return true
+	})
+}
+
+// 加载服务时读取证书，磁盘上的证书更换后随下次全量同步生效
+func (l *LoadBalance) compileUpstreamTLS() {
+	if l.UpstreamTls == 1 {
+		l.upstreamTLSConfig, _ = l.parseUpstreamTLS()
+	}
+}
+
+func (l *LoadBalance) parseUpstreamTLS() (*tls.Config, error) {
+	var caPem, certPem, keyPem []byte
+	var err error
+	if l.UpstreamCaCert != "" {
+		if caPem, err = public.LoadPemOrFile(l.UpstreamCaCert); err != nil {
+			return nil, err
+		}
+	}
+	if l.UpstreamClientCert != "" {
+		if certPem, err = public.LoadPemOrFile(l.UpstreamClientCert); err != nil {
+			return nil, err
+		}
+		if keyPem, err = public.LoadPemOrFile(l.UpstreamClientKey); err != nil {
+			return nil, err
+		}
+	}
+	hash := sha256.New()
+	for _, item := range [][]byte{caPem, certPem, keyPem, []byte(l.UpstreamServerName)} {
+		hash.Write(item)
+		hash.Write([]byte{0})
+	}
+	cacheKey := string(hash.Sum(nil))
+	if conf, ok := upstreamTLSCache.Load(cacheKey); ok {
+		return conf.(*tls.Config), nil
+	}
+	conf := &tls.Config{ServerName: l.UpstreamServerName}
+	if caPem != nil {
+		if conf.RootCAs, err = public.NewCertPool(caPem); err != nil {
+			return nil, err
+		}
+	}
+	if certPem != nil {
+		keyPair, err := tls.X509KeyPair(certPem, keyPem)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{keyPair}
+	}
+	actual, _ := upstreamTLSCache.LoadOrStore(cacheKey, conf)
+	return actual.(*tls.Config), nil
+}
+
+// 下游不使用tls时返回nil
+func (l *LoadBalance) GetUpstreamTLSConfig() (*tls.Config, error) {
+	if l.UpstreamTls != 1 {
+		return nil, nil
+	}
+	if l.upstreamTLSConfig == nil {
+		return l.parseUpstreamTLS()
+	}
+	return l.upstreamTLSConfig, nil
+}
+
+const (
+	RetryOnConnectError = "connect_error"
+	RetryOnReset        = "reset"
+)
+
+var retryOnStatus = map[string]int{
+	"502": http.StatusBadGateway,
+	"503": http.StatusServiceUnavailable,
+	"504": http.StatusGatewayTimeout,
+}
+
+// 解析重试条件，返回各条件是否开启，状态码条件以状态码字符串为key
+func ParseRetryOn(value string) (map[string]bool, error) {
+	retryOn := map[string]bool{}
+	if strings.TrimSpace(value) == "" {
+		value = "connect_error,reset,502,503,504"
+	}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if _, ok := retryOnStatus[item]; !ok && item != RetryOnConnectError && item != RetryOnReset {
+			return nil, fmt.Errorf("retry_on %s invalid", item)
+		}
+		retryOn[item] = true
+	}
+	return retryOn, nil
+}
+
 func (l *LoadBalance) GetIPlistByModel() []string {
 	return strings.Split(l.IpList, ",")
 }
+
+func (l *LoadBalance) GetWeightListByModel() []string {
+	return strings.Split(l.WeightList, ",")
+}
+
+func (l *LoadBalance) GetForbidListByModel() []string {
+	if l.ForbidList == "" {
+		return []string{}
+	}
+	return strings.Split(l.ForbidList, ",")
+}
+
+// 解析一致性hash的取值方式，返回 ip/header/cookie 以及对应的名称
+func (l *LoadBalance) GetHashKeyByModel() (string, string) {
+	items := strings.SplitN(strings.TrimSpace(l.HashKey), ":", 2)
+	if len(items) == 2 && items[1] != "" {
+		switch items[0] {
+		case "header", "cookie":
+			return items[0], items[1]
+		}
+	}
+	return "ip", ""
+}
+
+// 可参与负载的节点及权重，已剔除禁用节点
+func (l *LoadBalance) GetNodeList() (map[string]int, []string, error) {
+	ipList := l.GetIPlistByModel()
+	weightList := l.GetWeightListByModel()
+	forbidList := l.GetForbidListByModel()
+	weights := map[string]int{}
+	nodes := []string{}
+	for index, item := range ipList {
+		addr := strings.TrimSpace(item)
+		if addr == "" {
+			continue
+		}
+		isForbid := false
+		for _, forbid := range forbidList {
+			if strings.TrimSpace(forbid) == addr {
+				isForbid = true
+				break
+			}
+		}
+		if isForbid {
+			continue
+		}
+		weight := 1
+		if index < len(weightList) && strings.TrimSpace(weightList[index]) != "" {
+			w, err := strconv.Atoi(strings.TrimSpace(weightList[index]))
+			if err != nil {
+				return nil, nil, fmt.Errorf("weight of %s invalid: %v", addr, err)
+			}
+			weight = w
+		}
+		if _, ok := weights[addr]; !ok {
+			nodes = append(nodes, addr)
+		}
+		weights[addr] = weight
+	}
+	return weights, nodes, nil
+}
+
+var LoadBalancerHandler *LoadBalancer
+
+func init() {
+	LoadBalancerHandler = NewLoadBalancer()
+}
+
+type LoadBalancerItem struct {
+	LoadBalance load_balance.LoadBalance
+	ServiceName string
+	conf        LoadBalance //构建时的配置，配置变化后重新构建
+	checker     *load_balance.HealthChecker
+	detector    *load_balance.OutlierDetector
+	weights     map[string]int
+}
+
+type LoadBalancer struct {
+	LoadBalanceMap map[string]*LoadBalancerItem
+	Locker         sync.RWMutex
+}
+
+func NewLoadBalancer() *LoadBalancer {
+	return &LoadBalancer{
+		LoadBalanceMap: map[string]*LoadBalancerItem{},
+		Locker:         sync.RWMutex{},
+	}
+}
+
+// 获取服务对应的负载均衡器，LoadBalance配置变化时重新构建
+func (lbr *LoadBalancer) GetLoadBalancer(service *ServiceDetial) (load_balance.LoadBalance, error) {
+	if service.LoadBalance == nil {
+		return nil, errors.New("load balance not config")
+	}
+	serviceName := service.Info.ServiceName
+	lbr.Locker.RLock()
+	if item, ok := lbr.LoadBalanceMap[serviceName]; ok && item.conf == *service.LoadBalance {
+		lb := item.LoadBalance
+		lbr.Locker.RUnlock()
+		return lb, nil
+	}
+	lbr.Locker.RUnlock()
+
+	lbr.Locker.Lock()
+	defer lbr.Locker.Unlock()
+	//并发请求或服务变更监听可能已经完成构建
+	if item, ok := lbr.LoadBalanceMap[serviceName]; ok && item.conf == *service.LoadBalance {
+		return item.LoadBalance, nil
+	}
+	item, err := lbr.build(service)
+	if err != nil {
+		return nil, err
+	}
+	return item.LoadBalance, nil
+}
+
+// 构建负载均衡器并启动健康检查，替换同名服务的旧负载均衡器，调用方需持有Locker
+func (lbr *LoadBalancer) build(service *ServiceDetial) (*LoadBalancerItem, error) {
+	serviceName := service.Info.ServiceName
+	if item, ok := lbr.LoadBalanceMap[serviceName]; ok {
+		item.checker.Stop()
+		item.detector.Stop()
+		delete(lbr.LoadBalanceMap, serviceName)
+	}
+	weights, nodes, err := service.LoadBalance.GetNodeList()
+	if err != nil {
+		return nil, err
+	}
+	lb, err := newLoadBalance(service.LoadBalance.RoundType, nodes, weights)
+	if err != nil {
+		return nil, err
+	}
+	newItem := &LoadBalancerItem{
+		LoadBalance: lb,
+		ServiceName: serviceName,
+		conf:        *service.LoadBalance,
+		weights:     weights,
+	}
+	newItem.checker = load_balance.NewHealthChecker(nodes, service.LoadBalance.GetHealthCheckConf(), func(checker *load_balance.HealthChecker, changed bool) {
+		if changed {
+			lbr.rebuild(newItem)
+		}
+		newItem.reportHealth(checker)
+	})
+	newItem.detector = load_balance.NewOutlierDetector(nodes, getOutlierConf(), func() {
+		lbr.rebuild(newItem)
+	})
+	lbr.LoadBalanceMap[serviceName] = newItem
+	newItem.checker.Start()
+	return newItem, nil
+}
+
+// 服务删除或负载配置变化时释放旧的负载均衡器及健康检查，新增和变化的服务立即构建并开始健康检查
+func (lbr *LoadBalancer) OnServiceChange(change *ServiceChange) {
+	lbr.Locker.Lock()
+	defer lbr.Locker.Unlock()
+	services := append(append([]*ServiceDetial{}, change.Updated...), change.Removed...)
+	for index, service := range services {
+		item, ok := lbr.LoadBalanceMap[service.Info.ServiceName]
+		if !ok {
+			continue
+		}
+		isRemoved := index >= len(change.Updated)
+		if !isRemoved && service.LoadBalance != nil && item.conf == *service.LoadBalance {
+			continue
+		}
+		item.checker.Stop()
+		item.detector.Stop()
+		delete(lbr.LoadBalanceMap, service.Info.ServiceName)
+	}
+	//构建失败时留到首次请求再构建并返回错误
+	for _, service := range append(append([]*ServiceDetial{}, change.Added...), change.Updated...) {
+		if service.LoadBalance == nil {
+			continue
+		}
+		if _, ok := lbr.LoadBalanceMap[service.Info.ServiceName]; !ok {
+			lbr.build(service)
+		}
+	}
+}
+
+// 上报请求结果，用于被动摘除连续失败的节点
+func (lbr *LoadBalancer) ReportResult(service *ServiceDetial, addr string, failed bool) {
+	lbr.Locker.RLock()
+	item, ok := lbr.LoadBalanceMap[service.Info.ServiceName]
+	lbr.Locker.RUnlock()
+	if !ok {
+		return
+	}
+	item.detector.Report(addr, failed)
+}
+
+// 节点健康状态变化后，用主动检查健康且未被被动摘除的节点重建负载均衡器
+func (lbr *LoadBalancer) rebuild(item *LoadBalancerItem) {
+	healthyNodes := item.checker.HealthyNodes()
+	nodes := []string{}
+	for _, addr := range healthyNodes {
+		if !item.detector.IsEjected(addr) {
+			nodes = append(nodes, addr)
+		}
+	}
+	//被动摘除不能导致服务无节点可用
+	if len(nodes) == 0 {
+		nodes = healthyNodes
+	}
+	lb, err := newLoadBalance(item.conf.RoundType, nodes, item.weights)
+	if err != nil {
+		return
+	}
+	lbr.Locker.Lock()
+	if current, ok := lbr.LoadBalanceMap[item.ServiceName]; ok && current == item {
+		item.LoadBalance = lb
+	}
+	lbr.Locker.Unlock()
+}
+
+// 健康状态写入redis，供dashboard展示
+func (item *LoadBalancerItem) reportHealth(checker *load_balance.HealthChecker) {
+	key := public.NodeHealthPrefix + item.ServiceName
+	statusList := checker.NodeStatusList()
+	expire := item.conf.CheckInterval*3 + 60
+	public.RedisConfPipline(func(c redis.Conn) {
+		c.Send("DEL", key)
+		for _, status := range statusList {
+			c.Send("HSET", key, status.Addr, public.Obj2json(status))
+		}
+		c.Send("EXPIRE", key, expire)
+	})
+}
+
+func getOutlierConf() load_balance.OutlierConf {
+	return load_balance.OutlierConf{
+		ConsecutiveErrors:  lib.GetIntConf("proxy.outlier_detection.consecutive_errors"),
+		BaseEjectionTime:   time.Duration(lib.GetIntConf("proxy.outlier_detection.base_ejection_time")) * time.Second,
+		MaxEjectionTime:    time.Duration(lib.GetIntConf("proxy.outlier_detection.max_ejection_time")) * time.Second,
+		MaxEjectionPercent: lib.GetIntConf("proxy.outlier_detection.max_ejection_percent"),
+	}
+}
+
+func (l *LoadBalance) GetHealthCheckConf() load_balance.HealthCheckConf {
+	//证书加载失败时按默认配置握手，检查失败会把节点标记为不可用
+	tlsConfig, err := l.GetUpstreamTLSConfig()
+	if err != nil {
+		tlsConfig = &tls.Config{ServerName: l.UpstreamServerName}
+	}
+	return load_balance.HealthCheckConf{
+		TLSConfig:    tlsConfig,
+		Method:       l.CheckMethod,
+		Interval:     time.Duration(l.CheckInterval) * time.Second,
+		Timeout:      time.Duration(l.CheckTimeout) * time.Second,
+		Path:         l.CheckPath,
+		ExpectStatus: l.CheckExpectStatus,
+		Rise:         lib.GetIntConf("proxy.health_check.rise"),
+		Fall:         lib.GetIntConf("proxy.health_check.fall"),
+	}
+}
+
+// 从redis读取代理上报的节点健康状态
+func (l *LoadBalance) GetNodeHealth(serviceName string) ([]*load_balance.NodeStatus, error) {
+	values, err := redis.StringMap(public.RedisConfDo("HGETALL", public.NodeHealthPrefix+serviceName))
+	if err != nil {
+		return nil, err
+	}
+	return l.nodeHealth(values)
+}
+
+// ip_list中的节点均按健康展示，用于redis不可用时
+func (l *LoadBalance) DefaultNodeHealth() ([]*load_balance.NodeStatus, error) {
+	return l.nodeHealth(nil)
+}
+
+func (l *LoadBalance) nodeHealth(values map[string]string) ([]*load_balance.NodeStatus, error) {
+	_, nodes, err := l.GetNodeList()
+	if err != nil {
+		return nil, err
+	}
+	list := []*load_balance.NodeStatus{}
+	for _, addr := range nodes {
+		status := &load_balance.NodeStatus{Addr: addr, Healthy: true}
+		if value, ok := values[addr]; ok {
+			json.Unmarshal([]byte(value), status)
+		}
+		list = append(list, status)
+	}
+	return list, nil
+}
+
+// 代理实例上报的下游连接池统计
+type TransportStats struct {
+	Instance   string    `json:"instance" description:"代理实例 主机名:进程号"`
+	Active     int64     `json:"active" description:"正在处理请求的连接数"`
+	Idle       int64     `json:"idle" description:"空闲连接数"`
+	DialErrors int64     `json:"dial_errors" description:"累计建立连接失败次数"`
+	Requests   int64     `json:"requests" description:"累计请求数"`
+	Draining   int       `json:"draining" description:"配置变化后等待释放的旧连接池个数"`
+	ReportTime time.Time `json:"report_time" description:"上报时间"`
+}
+
+// 从redis读取各代理上报的连接池统计
+func (l *LoadBalance) GetTransportStats(serviceName string) ([]*TransportStats, error) {
+	values, err := redis.StringMap(public.RedisConfDo("HGETALL", public.TransportStatsPrefix+serviceName))
+	if err != nil {
+		return nil, err
+	}
+	//已停止的代理实例超过三个上报周期后不再展示
+	expire := time.Duration(lib.GetIntConf("proxy.transport.stat_interval")*3+60) * time.Second
+	list := []*TransportStats{}
+	for _, value := range values {
+		stats := &TransportStats{}
+		if err := json.Unmarshal([]byte(value), stats); err != nil || time.Since(stats.ReportTime) > expire {
+			continue
+		}
+		list = append(list, stats)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Instance < list[j].Instance
+	})
+	return list, nil
+}
+
+// 根据配置构建负载均衡器
+func NewLoadBalanceByModel(conf *LoadBalance) (load_balance.LoadBalance, error) {
+	weights, nodes, err := conf.GetNodeList()
+	if err != nil {
+		return nil, err
+	}
+	return newLoadBalance(conf.RoundType, nodes, weights)
+}
+
+func newLoadBalance(roundType int, nodes []string, weights map[string]int) (load_balance.LoadBalance, error) {
+	lb := load_balance.LoadBalanceFactory(load_balance.LbType(roundType))
+	for _, addr := range nodes {
+		if err := lb.Add(addr, strconv.Itoa(weights[addr])); err != nil {
+			return nil, err
+		}
+	}
+	return lb, nil
+}
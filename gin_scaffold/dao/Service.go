@@ -4,7 +4,10 @@ import (
 	"errors"
 	"gin_scaffold/dto"
 	"gin_scaffold/public"
+	"gin_scaffold/reverse_proxy/load_balance"
+	"net"
 	"net/http/httptest"
+	"sort"
 	"strings"
 	"sync"
 
@@ -13,12 +16,20 @@ import (
 )
 
 type ServiceDetial struct {
-	Info          *Serviceinfo     `json:"info" description:"基本信息"`
-	HTTPRule      *HttpRule        `json:"http_rule" description:"http_rule"`
-	TCPRule       *TcpRule         `json:"tcp_rule" description:"tcp_rule"`
-	GRPCRule      *GrpcRule        `json:"grpc_rule" description:"grpc_rule"`
-	LoadBalance   *LoadBalance     `json:"load_balance" description:"load_balance"`
-	AccessControl *AcccessControll `json:"access_control" description:"access_control"`
+	Info           *Serviceinfo        `json:"info" description:"基本信息"`
+	HTTPRule       *HttpRule           `json:"http_rule" description:"http_rule"`
+	TCPRule        *TcpRule            `json:"tcp_rule" description:"tcp_rule"`
+	GRPCRule       *GrpcRule           `json:"grpc_rule" description:"grpc_rule"`
+	LoadBalance    *LoadBalance        `json:"load_balance" description:"load_balance"`
+	AccessControl  *AcccessControll    `json:"access_control" description:"access_control"`
+	CircuitBreaker *CircuitBreakerRule `json:"circuit_breaker" description:"熔断规则, 未配置时为空"`
+}
+
+// 后台展示用，附带代理上报的节点健康状态
+type ServiceDetialOutput struct {
+	*ServiceDetial
+	NodeHealth     []*load_balance.NodeStatus `json:"node_health" description:"节点健康状态"`
+	TransportStats []*TransportStats          `json:"transport_stats" description:"各代理实例的下游连接池统计"`
 }
 
 var ServiceManagerHandler *ServiceManager
@@ -27,32 +38,70 @@ func init() {
 	ServiceManagerHandler = NewServiceManager()
 }
 
+// 服务变更，Removed为删除前的服务信息
+type ServiceChange struct {
+	Added   []*ServiceDetial
+	Updated []*ServiceDetial
+	Removed []*ServiceDetial
+}
+
+func (change *ServiceChange) IsEmpty() bool {
+	return len(change.Added) == 0 && len(change.Updated) == 0 && len(change.Removed) == 0
+}
+
+// ServiceMap、ServiceSlice只整体替换不原地修改，读取方拿到的快照不受reload影响
 type ServiceManager struct {
 	ServiceMap   map[string]*ServiceDetial
 	ServiceSlice []*ServiceDetial
-	Locker       sync.Mutex
+	Locker       sync.RWMutex
 	init         sync.Once
 	err          error
+	reloadLocker sync.Mutex
+	fingerprints map[string]string
+	listeners    []func(change *ServiceChange)
 }
 
 func NewServiceManager() *ServiceManager {
 	return &ServiceManager{
 		ServiceMap:   map[string]*ServiceDetial{},
 		ServiceSlice: []*ServiceDetial{},
-		Locker:       sync.Mutex{},
+		Locker:       sync.RWMutex{},
 		init:         sync.Once{},
+		fingerprints: map[string]string{},
 	}
 }
 
+// 注册服务变更监听，reload后回调
+func (s *ServiceManager) Register(listener func(change *ServiceChange)) {
+	s.reloadLocker.Lock()
+	defer s.reloadLocker.Unlock()
+	s.listeners = append(s.listeners, listener)
+}
+
+func (s *ServiceManager) GetServiceSlice() []*ServiceDetial {
+	s.Locker.RLock()
+	defer s.Locker.RUnlock()
+	return s.ServiceSlice
+}
+
+func (s *ServiceManager) GetService(serviceName string) (*ServiceDetial, bool) {
+	s.Locker.RLock()
+	defer s.Locker.RUnlock()
+	service, ok := s.ServiceMap[serviceName]
+	return service, ok
+}
+
 func (s *ServiceManager) HTTPAccessMode(c *gin.Context) (*ServiceDetial, error) {
 	//1、前缀匹配 /abc ==> serviceSlice.rule
 	//2、域名匹配 www.test.com ==> serviceSlice.rule
 	//host c.Request.Host
 	//path c.Request.URL.Path
 	host := c.Request.Host
-	host = host[0:strings.Index(host, ":")]
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
 	path := c.Request.URL.Path
-	for _, serviceItem := range s.ServiceSlice {
+	for _, serviceItem := range s.GetServiceSlice() {
 		if serviceItem.Info.LoadType != public.LoadTypeHTTP {
 			continue
 		}
@@ -72,40 +121,156 @@ func (s *ServiceManager) HTTPAccessMode(c *gin.Context) (*ServiceDetial, error)
 
 func (s *ServiceManager) LoadOnce() error {
 	s.init.Do(func() {
-		serviceInfo := &Serviceinfo{}
-		c, _ := gin.CreateTestContext(httptest.NewRecorder())
-		tx, err := lib.GetGormPool("default")
+		s.err = s.Reload()
+	})
+	return s.err
+}
+
+// 从db重新加载全部服务，未变化的服务沿用原对象，只有变化的服务需要重建负载均衡器等资源
+func (s *ServiceManager) Reload() error {
+	s.reloadLocker.Lock()
+	defer s.reloadLocker.Unlock()
+	serviceInfo := &Serviceinfo{}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	tx, err := lib.GetGormPool("default")
+	if err != nil {
+		return err
+	}
+	params := &dto.ServiceListInput{PageNumber: 1, PageSize: 99999}
+	list, _, err := serviceInfo.PageList(c, tx, params)
+	if err != nil {
+		return err
+	}
+	s.Locker.RLock()
+	oldMap := s.ServiceMap
+	s.Locker.RUnlock()
+
+	change := &ServiceChange{}
+	newMap := map[string]*ServiceDetial{}
+	newSlice := []*ServiceDetial{}
+	fingerprints := map[string]string{}
+	for _, listItem := range list {
+		tmpItem := listItem
+		serviceDetail, err := tmpItem.ServiceDetial(c, tx, &tmpItem)
 		if err != nil {
-			s.err = err
-			return
+			return err
+		}
+		name := listItem.ServiceName
+		fingerprint := serviceFingerprint(serviceDetail)
+		if old, ok := oldMap[name]; ok {
+			if s.fingerprints[name] == fingerprint {
+				serviceDetail = old
+			} else {
+				change.Updated = append(change.Updated, serviceDetail)
+			}
+		} else {
+			change.Added = append(change.Added, serviceDetail)
 		}
-		params := &dto.ServiceListInput{PageNumber: 1, PageSize: 99999}
-		list, _, err := serviceInfo.PageList(c, tx, params)
+		fingerprints[name] = fingerprint
+		newMap[name] = serviceDetail
+		newSlice = append(newSlice, serviceDetail)
+	}
+	for name, old := range oldMap {
+		if _, ok := newMap[name]; !ok {
+			change.Removed = append(change.Removed, old)
+		}
+	}
+	s.apply(newMap, newSlice, fingerprints, change)
+	return nil
+}
+
+// 只重新加载单个服务，服务已删除时从内存中移除
+func (s *ServiceManager) ReloadService(serviceID int64) error {
+	s.reloadLocker.Lock()
+	defer s.reloadLocker.Unlock()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	tx, err := lib.GetGormPool("default")
+	if err != nil {
+		return err
+	}
+	search := &Serviceinfo{ID: serviceID}
+	serviceInfo, err := search.FindService(c, tx, search)
+	if err != nil {
+		return err
+	}
+	var serviceDetail *ServiceDetial
+	if serviceInfo.ID != 0 && serviceInfo.IsDelete == 0 {
+		serviceDetail, err = serviceInfo.ServiceDetial(c, tx, serviceInfo)
 		if err != nil {
-			s.err = err
-			return
+			return err
 		}
-		s.Locker.Lock()
-		defer s.Locker.Unlock()
-		for _, listItem := range list {
-			tmpItem := listItem
-			serviceDetail, err := tmpItem.ServiceDetial(c, tx, &tmpItem)
-			//fmt.Println("serviceDetail")
-			//fmt.Println(public.Obj2Json(serviceDetail))
-			if err != nil {
-				s.err = err
-				return
+	}
+
+	s.Locker.RLock()
+	oldMap := s.ServiceMap
+	oldSlice := s.ServiceSlice
+	s.Locker.RUnlock()
+	change := &ServiceChange{}
+	newMap := map[string]*ServiceDetial{}
+	newSlice := []*ServiceDetial{}
+	fingerprints := map[string]string{}
+	for _, item := range oldSlice {
+		name := item.Info.ServiceName
+		if item.Info.ID == serviceID {
+			if serviceDetail == nil {
+				change.Removed = append(change.Removed, item)
 			}
-			s.ServiceMap[listItem.ServiceName] = serviceDetail
-			s.ServiceSlice = append(s.ServiceSlice, serviceDetail)
+			continue
 		}
-	})
-	return s.err
+		newMap[name] = item
+		newSlice = append(newSlice, item)
+		fingerprints[name] = s.fingerprints[name]
+	}
+	if serviceDetail != nil {
+		name := serviceDetail.Info.ServiceName
+		fingerprint := serviceFingerprint(serviceDetail)
+		if old, ok := oldMap[name]; !ok {
+			change.Added = append(change.Added, serviceDetail)
+		} else if s.fingerprints[name] != fingerprint {
+			change.Updated = append(change.Updated, serviceDetail)
+		} else {
+			serviceDetail = old
+		}
+		fingerprints[name] = fingerprint
+		newMap[name] = serviceDetail
+		newSlice = append(newSlice, serviceDetail)
+		//与PageList保持一致，按id倒序
+		sort.Slice(newSlice, func(i, j int) bool { return newSlice[i].Info.ID > newSlice[j].Info.ID })
+	}
+	s.apply(newMap, newSlice, fingerprints, change)
+	return nil
+}
+
+// 判断服务配置是否变化，json中不输出的下游客户端私钥单独计入
+func serviceFingerprint(detail *ServiceDetial) string {
+	clientKey := ""
+	if detail.LoadBalance != nil {
+		clientKey = public.MD5(detail.LoadBalance.UpstreamClientKey)
+	}
+	return public.MD5(public.Obj2json(detail) + clientKey)
+}
+
+// 整体替换服务列表并通知变更，调用方需持有reloadLocker
+func (s *ServiceManager) apply(newMap map[string]*ServiceDetial, newSlice []*ServiceDetial, fingerprints map[string]string, change *ServiceChange) {
+	s.Locker.Lock()
+	s.ServiceMap = newMap
+	s.ServiceSlice = newSlice
+	s.Locker.Unlock()
+	s.fingerprints = fingerprints
+	if change.IsEmpty() {
+		return
+	}
+	LoadBalancerHandler.OnServiceChange(change)
+	CircuitBreakerHandler.OnServiceChange(change)
+	for _, listener := range s.listeners {
+		listener(change)
+	}
+	pruneUpstreamTLSCache(newSlice)
 }
 
 func (s *ServiceManager) GetTcpServiceList() []*ServiceDetial {
 	list := []*ServiceDetial{}
-	for _, serverItem := range s.ServiceSlice {
+	for _, serverItem := range s.GetServiceSlice() {
 		tempItem := serverItem
 		if tempItem.Info.LoadType == public.LoadTypeTCP {
 			list = append(list, tempItem)
@@ -116,7 +281,7 @@ func (s *ServiceManager) GetTcpServiceList() []*ServiceDetial {
 
 func (s *ServiceManager) GetGrpcServiceList() []*ServiceDetial {
 	list := []*ServiceDetial{}
-	for _, serverItem := range s.ServiceSlice {
+	for _, serverItem := range s.GetServiceSlice() {
 		tempItem := serverItem
 		if tempItem.Info.LoadType == public.LoadTypeGrpc {
 			list = append(list, tempItem)
@@ -44,14 +44,31 @@ func (s *Serviceinfo) PageList(c *gin.Context, db *gorm.DB, param *dto.ServiceLi
 	offset := (param.PageNumber - 1) * param.PageSize
 	//where是模糊查询
 	query := db.WithContext(c)
-	query = query.Table(s.TableName()).Where("is_delete=0", "name LIKE %?% or describ like %?%", param.Info, param.Info)
+	query = query.Table(s.TableName()).Where("is_delete=0")
+	if param.Info != "" {
+		query = query.Where("(service_name like ? or service_desc like ?)", "%"+param.Info+"%", "%"+param.Info+"%")
+	}
+	//count与分页查询共用查询条件
+	query = query.Session(&gorm.Session{})
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
 	if err := query.Limit(param.PageSize).Offset(offset).Order("id desc").Find(&pagelist).Error; err != nil && err != gorm.ErrRecordNotFound {
 		return nil, 0, err
 	}
-	query.Limit(param.PageSize).Offset(offset).Count(&total)
 	return pagelist, total, nil
 }
 
+// 按负载类型统计服务数量
+func (s *Serviceinfo) GroupByLoadType(c *gin.Context, tx *gorm.DB) ([]dto.DashServiceStatItemOutput, error) {
+	list := []dto.DashServiceStatItemOutput{}
+	query := tx.WithContext(c)
+	if err := query.Table(s.TableName()).Where("is_delete=0").Select("load_type, count(*) as value").Group("load_type").Scan(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
 func (s *Serviceinfo) ServiceDetial(c *gin.Context, tx *gorm.DB, search *Serviceinfo) (*ServiceDetial, error) {
 	httprule := &HttpRule{ID: search.ID}
 	httprule, err := httprule.Find(c, tx, httprule)
@@ -78,13 +95,28 @@ func (s *Serviceinfo) ServiceDetial(c *gin.Context, tx *gorm.DB, search *Service
 	if err != nil && err != gorm.ErrRecordNotFound {
 		return nil, err
 	}
+	circuitbreaker := &CircuitBreakerRule{}
+	circuitbreaker, err = circuitbreaker.FindByServiceID(c, tx, search.ID)
+	if err != nil {
+		return nil, err
+	}
+	if httprule != nil {
+		httprule.compileUrlRewrite()
+	}
+	if accesscontrol != nil {
+		accesscontrol.compileAccessList()
+	}
+	if loadbalance != nil {
+		loadbalance.compileUpstreamTLS()
+	}
 	res := &ServiceDetial{
-		Info:          search,
-		HTTPRule:      httprule,
-		TCPRule:       tcprule,
-		GRPCRule:      grpcrule,
-		AccessControl: accesscontrol,
-		LoadBalance:   loadbalance,
+		Info:           search,
+		HTTPRule:       httprule,
+		TCPRule:        tcprule,
+		GRPCRule:       grpcrule,
+		AccessControl:  accesscontrol,
+		LoadBalance:    loadbalance,
+		CircuitBreaker: circuitbreaker,
 	}
 	return res, nil
 }
@@ -12,18 +12,19 @@ type AppListOutput struct {
 	List  []AppListItemOutput `json:"list" form:"list" comment:"列表"  validate:""`
 }
 type AppListItemOutput struct {
-	ID        int64     `json:"id" gorm:"primary_key"`
-	AppID     string    `json:"app_id" gorm:"column:app_id" description:"租户id	"`
-	Name      string    `json:"name" gorm:"column:name" description:"租户名称	"`
-	Secret    string    `json:"secret" gorm:"column:secret" description:"密钥"`
-	WhiteIPS  string    `json:"white_ips" gorm:"column:white_ips" description:"ip白名单，支持前缀匹配		"`
-	Qpd       int64     `json:"qpd" gorm:"column:qpd" description:"日请求量限制"`
-	Qps       int64     `json:"qps" gorm:"column:qps" description:"每秒请求量限制"`
-	RealQpd   int64     `json:"real_qpd" description:"日请求量限制"`
-	RealQps   int64     `json:"real_qps" description:"每秒请求量限制"`
-	UpdatedAt time.Time `json:"create_at" gorm:"column:create_at" description:"添加时间	"`
-	CreatedAt time.Time `json:"update_at" gorm:"column:update_at" description:"更新时间"`
-	IsDelete  int8      `json:"is_delete" gorm:"column:is_delete" description:"是否已删除；0：否；1：是"`
+	ID          int64     `json:"id" gorm:"primary_key"`
+	AppID       string    `json:"app_id" gorm:"column:app_id" description:"租户id	"`
+	Name        string    `json:"name" gorm:"column:name" description:"租户名称	"`
+	Secret      string    `json:"secret" gorm:"column:secret" description:"密钥"`
+	WhiteIPS    string    `json:"white_ips" gorm:"column:white_ips" description:"ip白名单，支持前缀匹配		"`
+	Qpd         int64     `json:"qpd" gorm:"column:qpd" description:"日请求量限制"`
+	Qps         int64     `json:"qps" gorm:"column:qps" description:"每秒请求量限制"`
+	CertSubject string    `json:"cert_subject" description:"客户端证书subject"`
+	RealQpd     int64     `json:"real_qpd" description:"日请求量限制"`
+	RealQps     int64     `json:"real_qps" description:"每秒请求量限制"`
+	UpdatedAt   time.Time `json:"create_at" gorm:"column:create_at" description:"添加时间	"`
+	CreatedAt   time.Time `json:"update_at" gorm:"column:update_at" description:"更新时间"`
+	IsDelete    int8      `json:"is_delete" gorm:"column:is_delete" description:"是否已删除；0：否；1：是"`
 }
 
 type AppListInput struct {
@@ -37,8 +38,12 @@ func (param *AppListInput) BindingValidParams(c *gin.Context) error {
 }
 
 type AppStatOutput struct {
-	Today     []int64 `json:"today" form:"today" comment:"今日流量" example:"" validate:""`         //列表
-	Yesterday []int64 `json:"yesterday" form:"yesterday" comment:"昨日流量" example:"" validate:""` //列表
+	Today          []int64 `json:"today" form:"today" comment:"今日流量" example:"" validate:""`                        //列表
+	Yesterday      []int64 `json:"yesterday" form:"yesterday" comment:"昨日流量" example:"" validate:""`                //列表
+	TodayError     []int64 `json:"today_error" form:"today_error" comment:"今日错误量" example:"" validate:""`           //列表
+	YesterdayError []int64 `json:"yesterday_error" form:"yesterday_error" comment:"昨日错误量" example:"" validate:""`   //列表
+	TodayP95       []int64 `json:"today_p95" form:"today_p95" comment:"今日p95耗时(ms)" example:"" validate:""`         //列表
+	YesterdayP95   []int64 `json:"yesterday_p95" form:"yesterday_p95" comment:"昨日p95耗时(ms)" example:"" validate:""` //列表
 }
 
 type AppDeleteInput struct {
@@ -58,12 +63,13 @@ func (param *AppUpdateInput) BindingValidParams(c *gin.Context) error {
 type AppUpdateOutput struct{}
 
 type APPAddHttpInput struct {
-	AppID    string `json:"app_id" form:"app_id" comment:"租户id" validate:"required"`
-	Name     string `json:"name" form:"name" comment:"租户名称" validate:"required"`
-	Secret   string `json:"secret" form:"secret" comment:"密钥" validate:""`
-	WhiteIPS string `json:"white_ips" form:"white_ips" comment:"ip白名单，支持前缀匹配"`
-	Qpd      int64  `json:"qpd" form:"qpd" comment:"日请求量限制" validate:""`
-	Qps      int64  `json:"qps" form:"qps" comment:"每秒请求量限制" validate:""`
+	AppID       string `json:"app_id" form:"app_id" comment:"租户id" validate:"required"`
+	Name        string `json:"name" form:"name" comment:"租户名称" validate:"required"`
+	Secret      string `json:"secret" form:"secret" comment:"密钥" validate:""`
+	WhiteIPS    string `json:"white_ips" form:"white_ips" comment:"ip白名单，支持前缀匹配" validate:"valid_ip_rules"`
+	Qpd         int64  `json:"qpd" form:"qpd" comment:"日请求量限制" validate:""`
+	Qps         int64  `json:"qps" form:"qps" comment:"每秒请求量限制" validate:""`
+	CertSubject string `json:"cert_subject" form:"cert_subject" comment:"客户端证书subject, 为空时按证书CN匹配app_id" validate:""`
 }
 
 func (param *APPAddHttpInput) BindingValidParams(c *gin.Context) error {
@@ -71,13 +77,14 @@ func (param *APPAddHttpInput) BindingValidParams(c *gin.Context) error {
 }
 
 type APPUpdateHttpInput struct {
-	ID       int64  `json:"id" form:"id" gorm:"column:id" comment:"主键ID" validate:"required"`
-	AppID    string `json:"app_id" form:"app_id" gorm:"column:app_id" comment:"租户id" validate:""`
-	Name     string `json:"name" form:"name" gorm:"column:name" comment:"租户名称" validate:"required"`
-	Secret   string `json:"secret" form:"secret" gorm:"column:secret" comment:"密钥" validate:"required"`
-	WhiteIPS string `json:"white_ips" form:"white_ips" gorm:"column:white_ips" comment:"ip白名单，支持前缀匹配		"`
-	Qpd      int64  `json:"qpd" form:"qpd" gorm:"column:qpd" comment:"日请求量限制"`
-	Qps      int64  `json:"qps" form:"qps" gorm:"column:qps" comment:"每秒请求量限制"`
+	ID          int64  `json:"id" form:"id" gorm:"column:id" comment:"主键ID" validate:"required"`
+	AppID       string `json:"app_id" form:"app_id" gorm:"column:app_id" comment:"租户id" validate:""`
+	Name        string `json:"name" form:"name" gorm:"column:name" comment:"租户名称" validate:"required"`
+	Secret      string `json:"secret" form:"secret" gorm:"column:secret" comment:"密钥" validate:"required"`
+	WhiteIPS    string `json:"white_ips" form:"white_ips" gorm:"column:white_ips" comment:"ip白名单，支持前缀匹配		" validate:"valid_ip_rules"`
+	Qpd         int64  `json:"qpd" form:"qpd" gorm:"column:qpd" comment:"日请求量限制"`
+	Qps         int64  `json:"qps" form:"qps" gorm:"column:qps" comment:"每秒请求量限制"`
+	CertSubject string `json:"cert_subject" form:"cert_subject" comment:"客户端证书subject, 为空时按证书CN匹配app_id" validate:""`
 }
 
 func (params *APPUpdateHttpInput) GetValidParams(c *gin.Context) error {
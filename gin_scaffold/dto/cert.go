@@ -0,0 +1,74 @@
+package dto
+
+import (
+	"gin_scaffold/public"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CertAddInput struct {
+	Name      string `json:"name" form:"name" comment:"证书名称" example:"www.test.com" validate:"required,max=255"`                       //证书名称
+	StoreType int    `json:"store_type" form:"store_type" comment:"存储方式 0=db 1=磁盘文件" example:"0" validate:"max=1,min=0"`               //存储方式
+	CertPem   string `json:"cert_pem" form:"cert_pem" comment:"证书链pem" example:"" validate:""`                                         //证书链pem
+	KeyPem    string `json:"key_pem" form:"key_pem" comment:"私钥pem" example:"" validate:""`                                            //私钥pem
+	CertFile  string `json:"cert_file" form:"cert_file" comment:"代理机器上的证书文件路径, 由代理加载时校验" example:"./cert_file/server.crt" validate:""` //证书文件路径
+	KeyFile   string `json:"key_file" form:"key_file" comment:"代理机器上的私钥文件路径" example:"./cert_file/server.key" validate:""`             //私钥文件路径
+}
+
+func (param *CertAddInput) BindingValidParams(c *gin.Context) error {
+	return public.DefaultGetValidParams(c, param)
+}
+
+type CertIssueInput struct {
+	Domain string `json:"domain" form:"domain" comment:"域名接入服务的域名" example:"www.test.com" validate:"required"` //域名
+}
+
+func (param *CertIssueInput) BindingValidParams(c *gin.Context) error {
+	return public.DefaultGetValidParams(c, param)
+}
+
+type CertDeleteInput struct {
+	ID int64 `json:"id" form:"id" comment:"证书ID" example:"1" validate:"required"` //证书ID
+}
+
+func (param *CertDeleteInput) BindingValidParams(c *gin.Context) error {
+	return public.DefaultGetValidParams(c, param)
+}
+
+type CertListOutput struct {
+	Total int64                `json:"total" form:"total" comment:"总数" example:"10" validate:""` //总数
+	List  []CertListItemOutput `json:"list" form:"list" comment:"列表" validate:""`
+}
+
+type CertListItemOutput struct {
+	ID        int64               `json:"id" form:"id"`
+	Name      string              `json:"name" form:"name"`
+	StoreType int                 `json:"store_type" form:"store_type"`
+	Source    int                 `json:"source" form:"source" comment:"来源 0=上传 1=内部CA自动签发"`
+	CertFile  string              `json:"cert_file" form:"cert_file"`
+	KeyFile   string              `json:"key_file" form:"key_file"`
+	Domains   []string            `json:"domains" form:"domains"`
+	NotBefore time.Time           `json:"not_before" form:"not_before"`
+	NotAfter  time.Time           `json:"not_after" form:"not_after"`
+	DaysLeft  int                 `json:"days_left" form:"days_left" comment:"剩余有效天数, 已过期为负数"`
+	Expired   bool                `json:"expired" form:"expired"`
+	Services  []CertServiceOutput `json:"services" form:"services" comment:"证书覆盖的域名接入服务"`
+	CreatedAt time.Time           `json:"create_at" form:"create_at"`
+}
+
+type CertServiceOutput struct {
+	ServiceID   int64  `json:"service_id" form:"service_id"`
+	ServiceName string `json:"service_name" form:"service_name"`
+	Rule        string `json:"rule" form:"rule"`
+}
+
+type CertDetailOutput struct {
+	CertListItemOutput
+	Subject      string `json:"subject" form:"subject"`
+	Issuer       string `json:"issuer" form:"issuer"`
+	SerialNumber string `json:"serial_number" form:"serial_number"`
+	CertPem      string `json:"cert_pem" form:"cert_pem" comment:"证书链pem, 不返回私钥"`
+	Loaded       bool   `json:"loaded" form:"loaded" comment:"证书与私钥是否能正常加载"`
+	LoadError    string `json:"load_error" form:"load_error"`
+}
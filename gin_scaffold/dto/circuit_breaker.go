@@ -0,0 +1,71 @@
+package dto
+
+import (
+	"gin_scaffold/public"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CircuitBreakerListInput struct {
+	ServiceID int64 `json:"service_id" form:"service_id" comment:"服务id, 为空查询全部" example:"" validate:""`
+	PageNo    int   `json:"page_no" form:"page_no" comment:"页码" example:"1" validate:"required,min=1,max=999"`
+	PageSize  int   `json:"page_size" form:"page_size" comment:"每页条数" example:"20" validate:"required,min=1,max=999"`
+}
+
+func (param *CircuitBreakerListInput) BindingValidParams(c *gin.Context) error {
+	return public.DefaultGetValidParams(c, param)
+}
+
+type CircuitBreakerDeleteInput struct {
+	ID int64 `json:"id" form:"id" comment:"熔断规则ID" example:"1" validate:"required"` //熔断规则ID
+}
+
+func (param *CircuitBreakerDeleteInput) BindingValidParams(c *gin.Context) error {
+	return public.DefaultGetValidParams(c, param)
+}
+
+// 新增和修改共用的熔断参数
+type CircuitBreakerParams struct {
+	Window              int    `json:"window" form:"window" comment:"统计滑动窗口, 单位s, 0=10s" example:"10" validate:"min=0"`                                        //统计滑动窗口
+	MinRequests         int    `json:"min_requests" form:"min_requests" comment:"窗口内最少请求数, 0=20" example:"20" validate:"min=0"`                                //窗口内最少请求数
+	ErrorRate           int    `json:"error_rate" form:"error_rate" comment:"错误率阈值, 百分比, 0=不按错误率熔断" example:"50" validate:"min=0,max=100"`                     //错误率阈值
+	SlowCallRate        int    `json:"slow_call_rate" form:"slow_call_rate" comment:"慢调用率阈值, 百分比, 0=不按慢调用率熔断" example:"0" validate:"min=0,max=100"`            //慢调用率阈值
+	SlowCallMs          int    `json:"slow_call_ms" form:"slow_call_ms" comment:"慢调用耗时, 单位ms" example:"1000" validate:"min=0"`                                 //慢调用耗时
+	OpenTime            int    `json:"open_time" form:"open_time" comment:"熔断持续时间, 单位s, 0=30s" example:"30" validate:"min=0"`                                  //熔断持续时间
+	HalfOpenRequests    int    `json:"half_open_requests" form:"half_open_requests" comment:"半开状态探测请求数, 0=5" example:"5" validate:"min=0"`                     //半开状态探测请求数
+	FallbackStatus      int    `json:"fallback_status" form:"fallback_status" comment:"熔断时返回的状态码, 0=503, 其他取值200-599" example:"503" validate:"min=0,max=599"`  //熔断时返回的状态码
+	FallbackContentType string `json:"fallback_content_type" form:"fallback_content_type" comment:"熔断时返回的Content-Type" example:"application/json" validate:""` //熔断时返回的Content-Type
+	FallbackBody        string `json:"fallback_body" form:"fallback_body" comment:"熔断时返回的内容, 为空返回网关错误信息" example:"" validate:""`                               //熔断时返回的内容
+}
+
+type CircuitBreakerAddInput struct {
+	ServiceID int64 `json:"service_id" form:"service_id" comment:"服务id" example:"1" validate:"required"` //服务id
+	CircuitBreakerParams
+}
+
+func (param *CircuitBreakerAddInput) BindingValidParams(c *gin.Context) error {
+	return public.DefaultGetValidParams(c, param)
+}
+
+type CircuitBreakerUpdateInput struct {
+	ID int64 `json:"id" form:"id" comment:"熔断规则ID" example:"1" validate:"required"` //熔断规则ID
+	CircuitBreakerParams
+}
+
+func (param *CircuitBreakerUpdateInput) BindingValidParams(c *gin.Context) error {
+	return public.DefaultGetValidParams(c, param)
+}
+
+type CircuitBreakerListOutput struct {
+	Total int64                          `json:"total" form:"total" comment:"总数" example:"" validate:""` //总数
+	List  []CircuitBreakerListItemOutput `json:"list" form:"list" comment:"列表" example:"" validate:""`   //列表
+}
+
+type CircuitBreakerListItemOutput struct {
+	ID          int64  `json:"id" form:"id"`
+	ServiceID   int64  `json:"service_id" form:"service_id"`
+	ServiceName string `json:"service_name" form:"service_name"`
+	CircuitBreakerParams
+	UpdatedAt time.Time `json:"update_at" form:"update_at"`
+}
@@ -1,8 +1,47 @@
 package dto
 
+import (
+	"gin_scaffold/public"
+
+	"github.com/gin-gonic/gin"
+)
+
 type PanelGroupDataOutput struct {
 	ServiceNum      int64 `json:"serviceNum"`
 	AppNum          int64 `json:"appNum"`
 	CurrentQPS      int64 `json:"currentQps"`
 	TodayRequestNum int64 `json:"todayRequestNum"`
 }
+
+type DashServiceStatItemOutput struct {
+	Name     string `json:"name"`
+	LoadType int    `json:"load_type"`
+	Value    int64  `json:"value"`
+}
+
+type DashServiceStatOutput struct {
+	Legend []string                    `json:"legend"`
+	Data   []DashServiceStatItemOutput `json:"data"`
+}
+
+type DashFlowStatRangeInput struct {
+	StatType  int    `json:"stat_type" form:"stat_type" comment:"统计对象 0=全站 1=服务 2=租户" example:"1" validate:"min=0,max=2"` //统计对象
+	ID        int64  `json:"id" form:"id" comment:"服务或租户id, stat_type为1或2时必填" example:"20" validate:""`                   //服务或租户id
+	StartDate string `json:"start_date" form:"start_date" comment:"开始日期" example:"2024-03-04" validate:"required"`        //开始日期
+	EndDate   string `json:"end_date" form:"end_date" comment:"结束日期" example:"2024-03-05" validate:"required"`            //结束日期
+}
+
+func (param *DashFlowStatRangeInput) BindValidParam(c *gin.Context) error {
+	return public.DefaultGetValidParams(c, param)
+}
+
+type DashFlowStatDayOutput struct {
+	Date    string  `json:"date" form:"date" comment:"日期" example:"2024-03-05" validate:""`
+	Request []int64 `json:"request" form:"request" comment:"每小时请求量" example:"" validate:""`
+	Error   []int64 `json:"error" form:"error" comment:"每小时错误量" example:"" validate:""`
+	P95     []int64 `json:"p95" form:"p95" comment:"每小时p95耗时(ms)" example:"" validate:""`
+}
+
+type DashFlowStatRangeOutput struct {
+	List []DashFlowStatDayOutput `json:"list" form:"list" comment:"按天的小时数据" example:"" validate:""`
+}
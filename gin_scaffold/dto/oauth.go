@@ -0,0 +1,25 @@
+package dto
+
+import (
+	"gin_scaffold/public"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TokensInput struct {
+	GrantType    string `json:"grant_type" form:"grant_type" comment:"授权类型" example:"client_credentials" validate:"required"` //授权类型
+	Scope        string `json:"scope" form:"scope" comment:"权限范围" example:"read_write" validate:""`                           //权限范围
+	ClientID     string `json:"client_id" form:"client_id" comment:"租户id, 未使用Basic认证时传入" example:"" validate:""`              //租户id
+	ClientSecret string `json:"client_secret" form:"client_secret" comment:"租户密钥, 未使用Basic认证时传入" example:"" validate:""`      //租户密钥
+}
+
+func (param *TokensInput) BindValidParam(c *gin.Context) error {
+	return public.DefaultGetValidParams(c, param)
+}
+
+type TokensOutput struct {
+	AccessToken string `json:"access_token" form:"access_token"`
+	ExpiresIn   int    `json:"expires_in" form:"expires_in"`
+	TokenType   string `json:"token_type" form:"token_type"`
+	Scope       string `json:"scope" form:"scope"`
+}
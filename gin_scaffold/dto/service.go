@@ -46,27 +46,52 @@ type CreateHTTPServiceInput struct {
 	ServiceName string `json:"service_name" form:"service_name" comment:"服务名" example:"" validate:"required,valid_service_name"` //服务名
 	ServiceDesc string `json:"service_desc" form:"service_desc" comment:"服务描述" example:"" validate:"required,max=255,min=1"`     //服务描述
 
-	RuleType       int    `json:"rule_type" form:"rule_type" comment:"接入类型" example:"" validate:"max=1,min=0"`                           //接入类型
-	Rule           string `json:"rule" form:"rule" comment:"接入路径：域名或者前缀" example:"" validate:"required,valid_rule"`                      //域名或者前缀
-	NeedHttps      int    `json:"need_https" form:"need_https" comment:"支持https" example:"" validate:"max=1,min=0"`                      //支持https
-	NeedStripUri   int    `json:"need_strip_uri" form:"need_strip_uri" comment:"启用strip_uri" example:"" validate:"max=1,min=0"`          //启用strip_uri
-	NeedWebsocket  int    `json:"need_websocket" form:"need_websocket" comment:"是否支持websocket" example:"" validate:"max=1,min=0"`        //是否支持websocket
-	UrlRewrite     string `json:"url_rewrite" form:"url_rewrite" comment:"url重写功能" example:"" validate:"valid_url_rewrite"`              //url重写功能
-	HeaderTransfor string `json:"header_transfor" form:"header_transfor" comment:"header转换" example:"" validate:"valid_header_transfor"` //header转换
-
-	OpenAuth          int    `json:"open_auth" form:"open_auth" comment:"是否开启权限" example:"" validate:"max=1,min=0"` //关键词
-	BlackList         string `json:"black_list" form:"black_list" comment:"黑名单ip" example:"" validate:""`           //黑名单ip
-	WhiteList         string `json:"white_list" form:"white_list" comment:"白名单ip" example:"" validate:""`           //白名单ip
+	RuleType              int    `json:"rule_type" form:"rule_type" comment:"接入类型" example:"" validate:"max=1,min=0"`                                     //接入类型
+	Rule                  string `json:"rule" form:"rule" comment:"接入路径：域名或者前缀" example:"" validate:"required,valid_rule"`                                //域名或者前缀
+	NeedHttps             int    `json:"need_https" form:"need_https" comment:"支持https" example:"" validate:"max=1,min=0"`                                //支持https
+	HstsMaxAge            int    `json:"hsts_max_age" form:"hsts_max_age" comment:"HSTS max-age, 单位s" example:"31536000" validate:"min=0"`                //HSTS max-age, 单位s, 0=不下发
+	HstsIncludeSubdomains int    `json:"hsts_include_subdomains" form:"hsts_include_subdomains" comment:"HSTS包含子域名" example:"0" validate:"max=1,min=0"`   //HSTS包含子域名
+	NeedStripUri          int    `json:"need_strip_uri" form:"need_strip_uri" comment:"启用strip_uri" example:"" validate:"max=1,min=0"`                    //启用strip_uri
+	NeedWebsocket         int    `json:"need_websocket" form:"need_websocket" comment:"是否支持websocket" example:"" validate:"max=1,min=0"`                  //是否支持websocket
+	WebsocketMaxConn      int    `json:"websocket_max_conn" form:"websocket_max_conn" comment:"websocket最大并发连接数" example:"0" validate:"min=0"`            //websocket最大并发连接数, 0=不限制
+	WebsocketIdleTimeout  int    `json:"websocket_idle_timeout" form:"websocket_idle_timeout" comment:"websocket空闲超时, 单位s" example:"60" validate:"min=0"` //websocket空闲超时, 单位s, 0=使用默认配置
+	UrlRewrite            string `json:"url_rewrite" form:"url_rewrite" comment:"url重写功能" example:"" validate:"valid_url_rewrite"`                        //url重写功能
+	HeaderTransfor        string `json:"header_transfor" form:"header_transfor" comment:"header转换" example:"" validate:"valid_header_transfor"`           //header转换
+
+	OpenAuth          int    `json:"open_auth" form:"open_auth" comment:"是否开启权限" example:"" validate:"max=1,min=0"`                                     //关键词
+	AuthType          int    `json:"auth_type" form:"auth_type" comment:"权限验证方式 0=JWT token 1=HMAC签名 2=客户端证书" example:"0" validate:"max=2,min=0"`       //权限验证方式
+	BlackList         string `json:"black_list" form:"black_list" comment:"黑名单ip" example:"" validate:"valid_ip_rules"`                                 //黑名单ip
+	WhiteList         string `json:"white_list" form:"white_list" comment:"白名单ip" example:"" validate:"valid_ip_rules"`                                 //白名单ip
+	WhiteHostName     string `json:"white_host_name" form:"white_host_name" comment:"白名单主机，以逗号间隔，支持*.example.com" example:"" validate:"valid_hostlist"` //白名单主机
 	ClientipFlowLimit int    `json:"clientipflowlimit" form:"clientip_flow_limit" comment:"客户端ip限流" example:"" validate:"min=0"`
 	ServiceFlowLimit  int    `json:"service_flow_limit" form:"service_flow_limit" comment:"服务端限流" example:"" validate:"min=0"` //服务端限流
+	FlowLimitType     int    `json:"flow_limit_type" form:"flow_limit_type" comment:"限流方式 0=单机令牌桶 1=redis滑动窗口 2=redis GCRA" example:"0" validate:"max=2,min=0"`
+	ClientCa          string `json:"client_ca" form:"client_ca" comment:"校验客户端证书的CA, pem内容或文件路径" example:"./cert_file/ca.crt" validate:""` //客户端CA, 为空不要求客户端证书
 
-	RoundType              int    `json:"round_type" form:"round_type" comment:"轮询方式" example:"" validate:"max=3,min=0"`         //轮询方式
-	IpList                 string `json:"ip_list" form:"ip_list" comment:"ip列表" example:"" validate:"required,valid_ipportlist"` //ip列表
+	RoundType              int    `json:"round_type" form:"round_type" comment:"轮询方式" example:"" validate:"max=3,min=0"`                        //轮询方式
+	CheckMethod            int    `json:"check_method" form:"check_method" comment:"检查方法" example:"0" validate:"max=1,min=0"`                   //检查方法 0=tcpchk 1=httpchk
+	CheckTimeout           int    `json:"check_timeout" form:"check_timeout" comment:"检查超时, 单位s" example:"2" validate:"min=0"`                  //检查超时, 单位s
+	CheckInterval          int    `json:"check_interval" form:"check_interval" comment:"检查间隔, 单位s" example:"5" validate:"min=0"`                //检查间隔, 单位s, 0=不检查
+	CheckPath              string `json:"check_path" form:"check_path" comment:"httpchk检查路径" example:"/health" validate:""`                     //httpchk检查路径
+	CheckExpectStatus      int    `json:"check_expect_status" form:"check_expect_status" comment:"httpchk期望状态码" example:"200" validate:"min=0"` //httpchk期望状态码
+	HashKey                string `json:"hash_key" form:"hash_key" comment:"一致性hash取值" example:"header:X-User-Id" validate:""`                  //一致性hash取值 为空=客户端ip header:名称 cookie:名称
+	IpList                 string `json:"ip_list" form:"ip_list" comment:"ip列表" example:"" validate:"required,valid_ipportlist"`                //ip列表
 	WeightList             string `json:"weightlist" form:"weight_list" comment:"权重列表" example:"" validate:"required,valid_weightlist"`
-	UpstreamConnectTimeout int    `json:"upstream_connect_timeout" form:"upstream_connect_timeout" comment:"建立连接超时, 单位s" example:"" validate:"min=0"`   //建立连接超时, 单位s
-	UpstreamHeaderTimeout  int    `json:"upstream_header_timeout" form:"upstream_header_timeout" comment:"获取header超时, 单位s" example:"" validate:"min=0"` //获取header超时, 单位s
-	UpstreamIdleTimeout    int    `json:"upstream_idle_timeout" form:"upstream_idle_timeout" comment:"链接最大空闲时间, 单位s" example:"" validate:"min=0"`       //链接最大空闲时间, 单位s
-	UpstreamMaxIdle        int    `json:"upstream_max_idle" form:"upstream_max_idle" comment:"最大空闲链接数" example:"" validate:"min=0"`                     //最大空闲链接数
+	UpstreamConnectTimeout int    `json:"upstream_connect_timeout" form:"upstream_connect_timeout" comment:"建立连接超时, 单位s" example:"" validate:"min=0"`          //建立连接超时, 单位s
+	UpstreamHeaderTimeout  int    `json:"upstream_header_timeout" form:"upstream_header_timeout" comment:"获取header超时, 单位s" example:"" validate:"min=0"`        //获取header超时, 单位s
+	UpstreamIdleTimeout    int    `json:"upstream_idle_timeout" form:"upstream_idle_timeout" comment:"链接最大空闲时间, 单位s" example:"" validate:"min=0"`              //链接最大空闲时间, 单位s
+	UpstreamMaxIdle        int    `json:"upstream_max_idle" form:"upstream_max_idle" comment:"最大空闲链接数" example:"" validate:"min=0"`                            //最大空闲链接数
+	UpstreamTls            int    `json:"upstream_tls" form:"upstream_tls" comment:"下游使用tls" example:"0" validate:"max=1,min=0"`                               //下游使用tls 1=使用
+	UpstreamCaCert         string `json:"upstream_ca_cert" form:"upstream_ca_cert" comment:"校验下游证书的CA" example:"./cert_file/ca.crt" validate:""`               //pem内容或文件路径, 为空使用系统根证书
+	UpstreamClientCert     string `json:"upstream_client_cert" form:"upstream_client_cert" comment:"向下游出示的客户端证书" example:"./cert_file/client.crt" validate:""` //pem内容或文件路径
+	UpstreamClientKey      string `json:"upstream_client_key" form:"upstream_client_key" comment:"客户端证书私钥" example:"./cert_file/client.key" validate:""`       //pem内容或文件路径
+	UpstreamServerName     string `json:"upstream_server_name" form:"upstream_server_name" comment:"校验下游证书时使用的域名" example:"" validate:""`                      //为空使用节点地址
+	RetryMaxAttempts       int    `json:"retry_max_attempts" form:"retry_max_attempts" comment:"最多尝试次数, 含首次请求" example:"3" validate:"min=0,max=10"`            //0或1=不重试
+	RetryOn                string `json:"retry_on" form:"retry_on" comment:"重试条件" example:"connect_error,reset,502,503,504" validate:""`                       //逗号间隔, 为空=全部
+	RetryNonIdempotent     int    `json:"retry_non_idempotent" form:"retry_non_idempotent" comment:"非幂等请求也重试" example:"0" validate:"max=1,min=0"`              //1=重试POST/PATCH等请求
+	RetryBackoff           int    `json:"retry_backoff" form:"retry_backoff" comment:"重试退避基数, 单位ms" example:"25" validate:"min=0"`                             //每次翻倍并随机抖动
+	RetryMaxBackoff        int    `json:"retry_max_backoff" form:"retry_max_backoff" comment:"重试退避上限, 单位ms" example:"250" validate:"min=0"`                    //重试退避上限
+	RetryBudget            int    `json:"retry_budget" form:"retry_budget" comment:"重试预算, 服务qps的百分比" example:"20" validate:"min=0,max=100"`                    //0=使用全局配置
 
 }
 
@@ -76,31 +101,46 @@ type CreateTcpServiceInput struct {
 	Port              int    `json:"port" form:"port" comment:"端口，需要设置8001-8999范围内" validate:"required,min=8001,max=8999"`
 	HeaderTransfor    string `json:"HeaderTransfor" form:"header_transfor" comment:"header头转换" validate:""`
 	OpenAuth          int    `json:"open_auth" form:"open_auth" comment:"是否开启权限验证" validate:""`
-	BlackList         string `json:"black_list" form:"black_list" comment:"黑名单IP，以逗号间隔，白名单优先级高于黑名单" validate:"valid_iplist"`
-	WhiteList         string `json:"white_list" form:"white_list" comment:"白名单IP，以逗号间隔，白名单优先级高于黑名单" validate:"valid_iplist"`
-	WhiteHostName     string `json:"white_host_name" form:"white_host_name" comment:"白名单主机，以逗号间隔" validate:"valid_iplist"`
+	BlackList         string `json:"black_list" form:"black_list" comment:"黑名单IP，以逗号间隔，白名单优先级高于黑名单" validate:"valid_ip_rules"`
+	WhiteList         string `json:"white_list" form:"white_list" comment:"白名单IP，以逗号间隔，白名单优先级高于黑名单" validate:"valid_ip_rules"`
+	WhiteHostName     string `json:"white_host_name" form:"white_host_name" comment:"白名单主机，以逗号间隔，支持*.example.com" validate:"valid_hostlist"`
 	ClientIPFlowLimit int    `json:"clientip_flow_limit" form:"clientip_flow_limit" comment:"客户端IP限流" validate:""`
 	ServiceFlowLimit  int    `json:"service_flow_limit" form:"service_flow_limit" comment:"服务端限流" validate:""`
+	FlowLimitType     int    `json:"flow_limit_type" form:"flow_limit_type" comment:"限流方式 0=单机令牌桶 1=redis滑动窗口 2=redis GCRA" example:"0" validate:"max=2,min=0"`
+	ServiceMaxConn    int    `json:"service_max_conn" form:"service_max_conn" comment:"服务最大并发连接数, 0=不限制" validate:"min=0"`
+	ClientIPMaxConn   int    `json:"clientip_max_conn" form:"clientip_max_conn" comment:"客户端IP最大并发连接数, 0=不限制" validate:"min=0"`
 	RoundType         int    `json:"round_type" form:"round_type" comment:"轮询策略" validate:""`
+	CheckMethod       int    `json:"check_method" form:"check_method" comment:"检查方法, 0=tcpchk" validate:"max=1,min=0"`
+	CheckTimeout      int    `json:"check_timeout" form:"check_timeout" comment:"检查超时, 单位s" validate:"min=0"`
+	CheckInterval     int    `json:"check_interval" form:"check_interval" comment:"检查间隔, 单位s, 0=不检查" validate:"min=0"`
 	IpList            string `json:"ip_list" form:"ip_list" comment:"IP列表" validate:"required,valid_ipportlist"`
 	WeightList        string `json:"weight_list" form:"weight_list" comment:"权重列表" validate:"required,valid_weightlist"`
 	ForbidList        string `json:"forbid_list" form:"forbid_list" comment:"禁用IP列表" validate:"valid_iplist"`
 }
 type CreateGrpcServiceInput struct {
-	ServiceName       string `json:"service_name" form:"service_name" comment:"服务名称" validate:"required,valid_service_name"`
-	ServiceDesc       string `json:"service_desc" form:"service_desc" comment:"服务描述" validate:"required"`
-	Port              int    `json:"port" form:"port" comment:"端口，需要设置8001-8999范围内" validate:"required,min=8001,max=8999"`
-	HeaderTransfor    string `json:"header_transfor" form:"header_transfor" comment:"metadata转换" validate:"valid_header_transfor"`
-	OpenAuth          int    `json:"open_auth" form:"open_auth" comment:"是否开启权限验证" validate:""`
-	BlackList         string `json:"black_list" form:"black_list" comment:"黑名单IP，以逗号间隔，白名单优先级高于黑名单" validate:"valid_iplist"`
-	WhiteList         string `json:"white_list" form:"white_list" comment:"白名单IP，以逗号间隔，白名单优先级高于黑名单" validate:"valid_iplist"`
-	WhiteHostName     string `json:"white_host_name" form:"white_host_name" comment:"白名单主机，以逗号间隔" validate:"valid_iplist"`
-	ClientIPFlowLimit int    `json:"clientip_flow_limit" form:"clientip_flow_limit" comment:"客户端IP限流" validate:""`
-	ServiceFlowLimit  int    `json:"service_flow_limit" form:"service_flow_limit" comment:"服务端限流" validate:""`
-	RoundType         int    `json:"round_type" form:"round_type" comment:"轮询策略" validate:""`
-	IpList            string `json:"ip_list" form:"ip_list" comment:"IP列表" validate:"required,valid_ipportlist"`
-	WeightList        string `json:"weight_list" form:"weight_list" comment:"权重列表" validate:"required,valid_weightlist"`
-	ForbidList        string `json:"forbid_list" form:"forbid_list" comment:"禁用IP列表" validate:"valid_iplist"`
+	ServiceName        string `json:"service_name" form:"service_name" comment:"服务名称" validate:"required,valid_service_name"`
+	ServiceDesc        string `json:"service_desc" form:"service_desc" comment:"服务描述" validate:"required"`
+	Port               int    `json:"port" form:"port" comment:"端口，需要设置8001-8999范围内" validate:"required,min=8001,max=8999"`
+	HeaderTransfor     string `json:"header_transfor" form:"header_transfor" comment:"metadata转换" validate:"valid_header_transfor"`
+	OpenAuth           int    `json:"open_auth" form:"open_auth" comment:"是否开启权限验证" validate:""`
+	BlackList          string `json:"black_list" form:"black_list" comment:"黑名单IP，以逗号间隔，白名单优先级高于黑名单" validate:"valid_ip_rules"`
+	WhiteList          string `json:"white_list" form:"white_list" comment:"白名单IP，以逗号间隔，白名单优先级高于黑名单" validate:"valid_ip_rules"`
+	WhiteHostName      string `json:"white_host_name" form:"white_host_name" comment:"白名单主机，以逗号间隔，支持*.example.com" validate:"valid_hostlist"`
+	ClientIPFlowLimit  int    `json:"clientip_flow_limit" form:"clientip_flow_limit" comment:"客户端IP限流" validate:""`
+	ServiceFlowLimit   int    `json:"service_flow_limit" form:"service_flow_limit" comment:"服务端限流" validate:""`
+	FlowLimitType      int    `json:"flow_limit_type" form:"flow_limit_type" comment:"限流方式 0=单机令牌桶 1=redis滑动窗口 2=redis GCRA" example:"0" validate:"max=2,min=0"`
+	RoundType          int    `json:"round_type" form:"round_type" comment:"轮询策略" validate:""`
+	CheckMethod        int    `json:"check_method" form:"check_method" comment:"检查方法, 0=tcpchk" validate:"max=1,min=0"`
+	CheckTimeout       int    `json:"check_timeout" form:"check_timeout" comment:"检查超时, 单位s" validate:"min=0"`
+	CheckInterval      int    `json:"check_interval" form:"check_interval" comment:"检查间隔, 单位s, 0=不检查" validate:"min=0"`
+	IpList             string `json:"ip_list" form:"ip_list" comment:"IP列表" validate:"required,valid_ipportlist"`
+	WeightList         string `json:"weight_list" form:"weight_list" comment:"权重列表" validate:"required,valid_weightlist"`
+	ForbidList         string `json:"forbid_list" form:"forbid_list" comment:"禁用IP列表" validate:"valid_iplist"`
+	UpstreamTls        int    `json:"upstream_tls" form:"upstream_tls" comment:"下游使用tls 1=使用" validate:"max=1,min=0"`
+	UpstreamCaCert     string `json:"upstream_ca_cert" form:"upstream_ca_cert" comment:"校验下游证书的CA, pem内容或文件路径, 为空使用系统根证书" validate:""`
+	UpstreamClientCert string `json:"upstream_client_cert" form:"upstream_client_cert" comment:"向下游出示的客户端证书, pem内容或文件路径" validate:""`
+	UpstreamClientKey  string `json:"upstream_client_key" form:"upstream_client_key" comment:"客户端证书私钥, pem内容或文件路径" validate:""`
+	UpstreamServerName string `json:"upstream_server_name" form:"upstream_server_name" comment:"校验下游证书时使用的域名, 为空使用节点地址" validate:""`
 }
 
 func (param *CreateHTTPServiceInput) BindValidParam(c *gin.Context) error {
@@ -122,27 +162,52 @@ type ServiceUpdateHTTPInput struct {
 	ServiceName string `json:"service_name" form:"service_name" comment:"服务名" example:"test_http_service_indb" validate:"required,valid_service_name"` //服务名
 	ServiceDesc string `json:"service_desc" form:"service_desc" comment:"服务描述" example:"test_http_service_indb" validate:"required,max=255,min=1"`     //服务描述
 
-	RuleType       int    `json:"rule_type" form:"rule_type" comment:"接入类型" example:"" validate:"max=1,min=0"`                             //接入类型
-	Rule           string `json:"rule" form:"rule" comment:"接入路径：域名或者前缀" example:"/test_http_service_indb" validate:"required,valid_rule"` //域名或者前缀
-	NeedHttps      int    `json:"need_https" form:"need_https" comment:"支持https" example:"" validate:"max=1,min=0"`                        //支持https
-	NeedStripUri   int    `json:"need_strip_uri" form:"need_strip_uri" comment:"启用strip_uri" example:"" validate:"max=1,min=0"`            //启用strip_uri
-	NeedWebsocket  int    `json:"need_websocket" form:"need_websocket" comment:"是否支持websocket" example:"" validate:"max=1,min=0"`          //是否支持websocket
-	UrlRewrite     string `json:"url_rewrite" form:"url_rewrite" comment:"url重写功能" example:"" validate:"valid_url_rewrite"`                //url重写功能
-	HeaderTransfor string `json:"header_transfor" form:"header_transfor" comment:"header转换" example:"" validate:"valid_header_transfor"`   //header转换
-
-	OpenAuth          int    `json:"open_auth" form:"open_auth" comment:"是否开启权限" example:"" validate:"max=1,min=0"`                 //关键词
-	BlackList         string `json:"black_list" form:"black_list" comment:"黑名单ip" example:"" validate:""`                           //黑名单ip
-	WhiteList         string `json:"white_list" form:"white_list" comment:"白名单ip" example:"" validate:""`                           //白名单ip
-	ClientipFlowLimit int    `json:"clientip_flow_limit" form:"clientip_flow_limit" comment:"客户端ip限流	" example:"" validate:"min=0"` //客户端ip限流
-	ServiceFlowLimit  int    `json:"service_flow_limit" form:"service_flow_limit" comment:"服务端限流" example:"" validate:"min=0"`      //服务端限流
-
-	RoundType              int    `json:"round_type" form:"round_type" comment:"轮询方式" example:"" validate:"max=3,min=0"`                                //轮询方式
-	IpList                 string `json:"ip_list" form:"ip_list" comment:"ip列表" example:"127.0.0.1:80" validate:"required,valid_ipportlist"`            //ip列表
-	WeightList             string `json:"weight_list" form:"weight_list" comment:"权重列表" example:"50" validate:"required,valid_weightlist"`              //权重列表
-	UpstreamConnectTimeout int    `json:"upstream_connect_timeout" form:"upstream_connect_timeout" comment:"建立连接超时, 单位s" example:"" validate:"min=0"`   //建立连接超时, 单位s
-	UpstreamHeaderTimeout  int    `json:"upstream_header_timeout" form:"upstream_header_timeout" comment:"获取header超时, 单位s" example:"" validate:"min=0"` //获取header超时, 单位s
-	UpstreamIdleTimeout    int    `json:"upstream_idle_timeout" form:"upstream_idle_timeout" comment:"链接最大空闲时间, 单位s" example:"" validate:"min=0"`       //链接最大空闲时间, 单位s
-	UpstreamMaxIdle        int    `json:"upstream_max_idle" form:"upstream_max_idle" comment:"最大空闲链接数" example:"" validate:"min=0"`                     //最大空闲链接数
+	RuleType              int    `json:"rule_type" form:"rule_type" comment:"接入类型" example:"" validate:"max=1,min=0"`                                     //接入类型
+	Rule                  string `json:"rule" form:"rule" comment:"接入路径：域名或者前缀" example:"/test_http_service_indb" validate:"required,valid_rule"`         //域名或者前缀
+	NeedHttps             int    `json:"need_https" form:"need_https" comment:"支持https" example:"" validate:"max=1,min=0"`                                //支持https
+	HstsMaxAge            int    `json:"hsts_max_age" form:"hsts_max_age" comment:"HSTS max-age, 单位s" example:"31536000" validate:"min=0"`                //HSTS max-age, 单位s, 0=不下发
+	HstsIncludeSubdomains int    `json:"hsts_include_subdomains" form:"hsts_include_subdomains" comment:"HSTS包含子域名" example:"0" validate:"max=1,min=0"`   //HSTS包含子域名
+	NeedStripUri          int    `json:"need_strip_uri" form:"need_strip_uri" comment:"启用strip_uri" example:"" validate:"max=1,min=0"`                    //启用strip_uri
+	NeedWebsocket         int    `json:"need_websocket" form:"need_websocket" comment:"是否支持websocket" example:"" validate:"max=1,min=0"`                  //是否支持websocket
+	WebsocketMaxConn      int    `json:"websocket_max_conn" form:"websocket_max_conn" comment:"websocket最大并发连接数" example:"0" validate:"min=0"`            //websocket最大并发连接数, 0=不限制
+	WebsocketIdleTimeout  int    `json:"websocket_idle_timeout" form:"websocket_idle_timeout" comment:"websocket空闲超时, 单位s" example:"60" validate:"min=0"` //websocket空闲超时, 单位s, 0=使用默认配置
+	UrlRewrite            string `json:"url_rewrite" form:"url_rewrite" comment:"url重写功能" example:"" validate:"valid_url_rewrite"`                        //url重写功能
+	HeaderTransfor        string `json:"header_transfor" form:"header_transfor" comment:"header转换" example:"" validate:"valid_header_transfor"`           //header转换
+
+	OpenAuth          int    `json:"open_auth" form:"open_auth" comment:"是否开启权限" example:"" validate:"max=1,min=0"`                                     //关键词
+	AuthType          int    `json:"auth_type" form:"auth_type" comment:"权限验证方式 0=JWT token 1=HMAC签名 2=客户端证书" example:"0" validate:"max=2,min=0"`       //权限验证方式
+	BlackList         string `json:"black_list" form:"black_list" comment:"黑名单ip" example:"" validate:"valid_ip_rules"`                                 //黑名单ip
+	WhiteList         string `json:"white_list" form:"white_list" comment:"白名单ip" example:"" validate:"valid_ip_rules"`                                 //白名单ip
+	WhiteHostName     string `json:"white_host_name" form:"white_host_name" comment:"白名单主机，以逗号间隔，支持*.example.com" example:"" validate:"valid_hostlist"` //白名单主机
+	ClientipFlowLimit int    `json:"clientip_flow_limit" form:"clientip_flow_limit" comment:"客户端ip限流	" example:"" validate:"min=0"`                     //客户端ip限流
+	ServiceFlowLimit  int    `json:"service_flow_limit" form:"service_flow_limit" comment:"服务端限流" example:"" validate:"min=0"`                          //服务端限流
+	FlowLimitType     int    `json:"flow_limit_type" form:"flow_limit_type" comment:"限流方式 0=单机令牌桶 1=redis滑动窗口 2=redis GCRA" example:"0" validate:"max=2,min=0"`
+	ClientCa          string `json:"client_ca" form:"client_ca" comment:"校验客户端证书的CA, pem内容或文件路径" example:"./cert_file/ca.crt" validate:""` //客户端CA, 为空不要求客户端证书
+
+	RoundType              int    `json:"round_type" form:"round_type" comment:"轮询方式" example:"" validate:"max=3,min=0"`                                       //轮询方式
+	CheckMethod            int    `json:"check_method" form:"check_method" comment:"检查方法" example:"0" validate:"max=1,min=0"`                                  //检查方法 0=tcpchk 1=httpchk
+	CheckTimeout           int    `json:"check_timeout" form:"check_timeout" comment:"检查超时, 单位s" example:"2" validate:"min=0"`                                 //检查超时, 单位s
+	CheckInterval          int    `json:"check_interval" form:"check_interval" comment:"检查间隔, 单位s" example:"5" validate:"min=0"`                               //检查间隔, 单位s, 0=不检查
+	CheckPath              string `json:"check_path" form:"check_path" comment:"httpchk检查路径" example:"/health" validate:""`                                    //httpchk检查路径
+	CheckExpectStatus      int    `json:"check_expect_status" form:"check_expect_status" comment:"httpchk期望状态码" example:"200" validate:"min=0"`                //httpchk期望状态码
+	HashKey                string `json:"hash_key" form:"hash_key" comment:"一致性hash取值" example:"header:X-User-Id" validate:""`                                 //一致性hash取值 为空=客户端ip header:名称 cookie:名称
+	IpList                 string `json:"ip_list" form:"ip_list" comment:"ip列表" example:"127.0.0.1:80" validate:"required,valid_ipportlist"`                   //ip列表
+	WeightList             string `json:"weight_list" form:"weight_list" comment:"权重列表" example:"50" validate:"required,valid_weightlist"`                     //权重列表
+	UpstreamConnectTimeout int    `json:"upstream_connect_timeout" form:"upstream_connect_timeout" comment:"建立连接超时, 单位s" example:"" validate:"min=0"`          //建立连接超时, 单位s
+	UpstreamHeaderTimeout  int    `json:"upstream_header_timeout" form:"upstream_header_timeout" comment:"获取header超时, 单位s" example:"" validate:"min=0"`        //获取header超时, 单位s
+	UpstreamIdleTimeout    int    `json:"upstream_idle_timeout" form:"upstream_idle_timeout" comment:"链接最大空闲时间, 单位s" example:"" validate:"min=0"`              //链接最大空闲时间, 单位s
+	UpstreamMaxIdle        int    `json:"upstream_max_idle" form:"upstream_max_idle" comment:"最大空闲链接数" example:"" validate:"min=0"`                            //最大空闲链接数
+	UpstreamTls            int    `json:"upstream_tls" form:"upstream_tls" comment:"下游使用tls" example:"0" validate:"max=1,min=0"`                               //下游使用tls 1=使用
+	UpstreamCaCert         string `json:"upstream_ca_cert" form:"upstream_ca_cert" comment:"校验下游证书的CA" example:"./cert_file/ca.crt" validate:""`               //pem内容或文件路径, 为空使用系统根证书
+	UpstreamClientCert     string `json:"upstream_client_cert" form:"upstream_client_cert" comment:"向下游出示的客户端证书" example:"./cert_file/client.crt" validate:""` //pem内容或文件路径
+	UpstreamClientKey      string `json:"upstream_client_key" form:"upstream_client_key" comment:"客户端证书私钥" example:"./cert_file/client.key" validate:""`       //pem内容或文件路径
+	UpstreamServerName     string `json:"upstream_server_name" form:"upstream_server_name" comment:"校验下游证书时使用的域名" example:"" validate:""`                      //为空使用节点地址
+	RetryMaxAttempts       int    `json:"retry_max_attempts" form:"retry_max_attempts" comment:"最多尝试次数, 含首次请求" example:"3" validate:"min=0,max=10"`            //0或1=不重试
+	RetryOn                string `json:"retry_on" form:"retry_on" comment:"重试条件" example:"connect_error,reset,502,503,504" validate:""`                       //逗号间隔, 为空=全部
+	RetryNonIdempotent     int    `json:"retry_non_idempotent" form:"retry_non_idempotent" comment:"非幂等请求也重试" example:"0" validate:"max=1,min=0"`              //1=重试POST/PATCH等请求
+	RetryBackoff           int    `json:"retry_backoff" form:"retry_backoff" comment:"重试退避基数, 单位ms" example:"25" validate:"min=0"`                             //每次翻倍并随机抖动
+	RetryMaxBackoff        int    `json:"retry_max_backoff" form:"retry_max_backoff" comment:"重试退避上限, 单位ms" example:"250" validate:"min=0"`                    //重试退避上限
+	RetryBudget            int    `json:"retry_budget" form:"retry_budget" comment:"重试预算, 服务qps的百分比" example:"20" validate:"min=0,max=100"`                    //0=使用全局配置
 }
 
 type UpdateTcpServiceInput struct {
@@ -151,12 +216,18 @@ type UpdateTcpServiceInput struct {
 	ServiceDesc       string `json:"service_desc" form:"service_desc" comment:"服务描述" validate:"required"`
 	Port              int    `json:"port" form:"port" comment:"端口，需要设置8001-8999范围内" validate:"required,min=8001,max=8999"`
 	OpenAuth          int    `json:"open_auth" form:"open_auth" comment:"是否开启权限验证" validate:""`
-	BlackList         string `json:"black_list" form:"black_list" comment:"黑名单IP，以逗号间隔，白名单优先级高于黑名单" validate:"valid_iplist"`
-	WhiteList         string `json:"white_list" form:"white_list" comment:"白名单IP，以逗号间隔，白名单优先级高于黑名单" validate:"valid_iplist"`
-	WhiteHostName     string `json:"white_host_name" form:"white_host_name" comment:"白名单主机，以逗号间隔" validate:"valid_iplist"`
+	BlackList         string `json:"black_list" form:"black_list" comment:"黑名单IP，以逗号间隔，白名单优先级高于黑名单" validate:"valid_ip_rules"`
+	WhiteList         string `json:"white_list" form:"white_list" comment:"白名单IP，以逗号间隔，白名单优先级高于黑名单" validate:"valid_ip_rules"`
+	WhiteHostName     string `json:"white_host_name" form:"white_host_name" comment:"白名单主机，以逗号间隔，支持*.example.com" validate:"valid_hostlist"`
 	ClientIPFlowLimit int    `json:"clientip_flow_limit" form:"clientip_flow_limit" comment:"客户端IP限流" validate:""`
 	ServiceFlowLimit  int    `json:"service_flow_limit" form:"service_flow_limit" comment:"服务端限流" validate:""`
+	FlowLimitType     int    `json:"flow_limit_type" form:"flow_limit_type" comment:"限流方式 0=单机令牌桶 1=redis滑动窗口 2=redis GCRA" example:"0" validate:"max=2,min=0"`
+	ServiceMaxConn    int    `json:"service_max_conn" form:"service_max_conn" comment:"服务最大并发连接数, 0=不限制" validate:"min=0"`
+	ClientIPMaxConn   int    `json:"clientip_max_conn" form:"clientip_max_conn" comment:"客户端IP最大并发连接数, 0=不限制" validate:"min=0"`
 	RoundType         int    `json:"round_type" form:"round_type" comment:"轮询策略" validate:""`
+	CheckMethod       int    `json:"check_method" form:"check_method" comment:"检查方法, 0=tcpchk" validate:"max=1,min=0"`
+	CheckTimeout      int    `json:"check_timeout" form:"check_timeout" comment:"检查超时, 单位s" validate:"min=0"`
+	CheckInterval     int    `json:"check_interval" form:"check_interval" comment:"检查间隔, 单位s, 0=不检查" validate:"min=0"`
 	IpList            string `json:"ip_list" form:"ip_list" comment:"IP列表" validate:"required,valid_ipportlist"`
 	WeightList        string `json:"weight_list" form:"weight_list" comment:"权重列表" validate:"required,valid_weightlist"`
 	ForbidList        string `json:"forbid_list" form:"forbid_list" comment:"禁用IP列表" validate:"valid_iplist"`
@@ -167,21 +238,30 @@ func (param *UpdateTcpServiceInput) BindValidParam(c *gin.Context) error {
 }
 
 type UpdateGrpcServiceInput struct {
-	ID                int64  `json:"id" form:"id" comment:"服务ID" example:"62" validate:"required,min=1"` //服务ID
-	ServiceName       string `json:"service_name" form:"service_name" comment:"服务名称" validate:"required,valid_service_name"`
-	ServiceDesc       string `json:"service_desc" form:"service_desc" comment:"服务描述" validate:"required"`
-	Port              int    `json:"port" form:"port" comment:"端口，需要设置8001-8999范围内" validate:"required,min=8001,max=8999"`
-	HeaderTransfor    string `json:"header_transfor" form:"header_transfor" comment:"metadata转换" validate:"valid_header_transfor"`
-	OpenAuth          int    `json:"open_auth" form:"open_auth" comment:"是否开启权限验证" validate:""`
-	BlackList         string `json:"black_list" form:"black_list" comment:"黑名单IP，以逗号间隔，白名单优先级高于黑名单" validate:"valid_iplist"`
-	WhiteList         string `json:"white_list" form:"white_list" comment:"白名单IP，以逗号间隔，白名单优先级高于黑名单" validate:"valid_iplist"`
-	WhiteHostName     string `json:"white_host_name" form:"white_host_name" comment:"白名单主机，以逗号间隔" validate:"valid_iplist"`
-	ClientIPFlowLimit int    `json:"clientip_flow_limit" form:"clientip_flow_limit" comment:"客户端IP限流" validate:""`
-	ServiceFlowLimit  int    `json:"service_flow_limit" form:"service_flow_limit" comment:"服务端限流" validate:""`
-	RoundType         int    `json:"round_type" form:"round_type" comment:"轮询策略" validate:""`
-	IpList            string `json:"ip_list" form:"ip_list" comment:"IP列表" validate:"required,valid_ipportlist"`
-	WeightList        string `json:"weight_list" form:"weight_list" comment:"权重列表" validate:"required,valid_weightlist"`
-	ForbidList        string `json:"forbid_list" form:"forbid_list" comment:"禁用IP列表" validate:"valid_iplist"`
+	ID                 int64  `json:"id" form:"id" comment:"服务ID" example:"62" validate:"required,min=1"` //服务ID
+	ServiceName        string `json:"service_name" form:"service_name" comment:"服务名称" validate:"required,valid_service_name"`
+	ServiceDesc        string `json:"service_desc" form:"service_desc" comment:"服务描述" validate:"required"`
+	Port               int    `json:"port" form:"port" comment:"端口，需要设置8001-8999范围内" validate:"required,min=8001,max=8999"`
+	HeaderTransfor     string `json:"header_transfor" form:"header_transfor" comment:"metadata转换" validate:"valid_header_transfor"`
+	OpenAuth           int    `json:"open_auth" form:"open_auth" comment:"是否开启权限验证" validate:""`
+	BlackList          string `json:"black_list" form:"black_list" comment:"黑名单IP，以逗号间隔，白名单优先级高于黑名单" validate:"valid_ip_rules"`
+	WhiteList          string `json:"white_list" form:"white_list" comment:"白名单IP，以逗号间隔，白名单优先级高于黑名单" validate:"valid_ip_rules"`
+	WhiteHostName      string `json:"white_host_name" form:"white_host_name" comment:"白名单主机，以逗号间隔，支持*.example.com" validate:"valid_hostlist"`
+	ClientIPFlowLimit  int    `json:"clientip_flow_limit" form:"clientip_flow_limit" comment:"客户端IP限流" validate:""`
+	ServiceFlowLimit   int    `json:"service_flow_limit" form:"service_flow_limit" comment:"服务端限流" validate:""`
+	FlowLimitType      int    `json:"flow_limit_type" form:"flow_limit_type" comment:"限流方式 0=单机令牌桶 1=redis滑动窗口 2=redis GCRA" example:"0" validate:"max=2,min=0"`
+	RoundType          int    `json:"round_type" form:"round_type" comment:"轮询策略" validate:""`
+	CheckMethod        int    `json:"check_method" form:"check_method" comment:"检查方法, 0=tcpchk" validate:"max=1,min=0"`
+	CheckTimeout       int    `json:"check_timeout" form:"check_timeout" comment:"检查超时, 单位s" validate:"min=0"`
+	CheckInterval      int    `json:"check_interval" form:"check_interval" comment:"检查间隔, 单位s, 0=不检查" validate:"min=0"`
+	IpList             string `json:"ip_list" form:"ip_list" comment:"IP列表" validate:"required,valid_ipportlist"`
+	WeightList         string `json:"weight_list" form:"weight_list" comment:"权重列表" validate:"required,valid_weightlist"`
+	ForbidList         string `json:"forbid_list" form:"forbid_list" comment:"禁用IP列表" validate:"valid_iplist"`
+	UpstreamTls        int    `json:"upstream_tls" form:"upstream_tls" comment:"下游使用tls 1=使用" validate:"max=1,min=0"`
+	UpstreamCaCert     string `json:"upstream_ca_cert" form:"upstream_ca_cert" comment:"校验下游证书的CA, pem内容或文件路径, 为空使用系统根证书" validate:""`
+	UpstreamClientCert string `json:"upstream_client_cert" form:"upstream_client_cert" comment:"向下游出示的客户端证书, pem内容或文件路径" validate:""`
+	UpstreamClientKey  string `json:"upstream_client_key" form:"upstream_client_key" comment:"客户端证书私钥, pem内容或文件路径" validate:""`
+	UpstreamServerName string `json:"upstream_server_name" form:"upstream_server_name" comment:"校验下游证书时使用的域名, 为空使用节点地址" validate:""`
 }
 
 func (param *UpdateGrpcServiceInput) BindValidParam(c *gin.Context) error {
@@ -189,8 +269,12 @@ func (param *UpdateGrpcServiceInput) BindValidParam(c *gin.Context) error {
 }
 
 type ServiceStatOutput struct {
-	Today     []int64 `json:"today" form:"today" comment:"今日流量" example:"" validate:""`         //列表
-	Yesterday []int64 `json:"yesterday" form:"yesterday" comment:"昨日流量" example:"" validate:""` //列表
+	Today          []int64 `json:"today" form:"today" comment:"今日流量" example:"" validate:""`                        //列表
+	Yesterday      []int64 `json:"yesterday" form:"yesterday" comment:"昨日流量" example:"" validate:""`                //列表
+	TodayError     []int64 `json:"today_error" form:"today_error" comment:"今日错误量" example:"" validate:""`           //列表
+	YesterdayError []int64 `json:"yesterday_error" form:"yesterday_error" comment:"昨日错误量" example:"" validate:""`   //列表
+	TodayP95       []int64 `json:"today_p95" form:"today_p95" comment:"今日p95耗时(ms)" example:"" validate:""`         //列表
+	YesterdayP95   []int64 `json:"yesterday_p95" form:"yesterday_p95" comment:"昨日p95耗时(ms)" example:"" validate:""` //列表
 }
 
 func (param *ServiceStatOutput) BindValidParam(c *gin.Context) error {
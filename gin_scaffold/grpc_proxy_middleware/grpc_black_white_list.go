@@ -0,0 +1,54 @@
+package grpc_proxy_middleware
+
+import (
+	"context"
+	"gin_scaffold/public"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// 服务的ip黑白名单和主机白名单，白名单优先级高于黑名单，主机取:authority
+func GrpcBlackWhiteListMiddleware() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		service, ok := GetService(ss.Context())
+		if !ok {
+			return status.Errorf(codes.Internal, "service not found")
+		}
+		access := service.AccessControl
+		if access == nil {
+			return handler(srv, ss)
+		}
+		clientIP := GetClientIP(ss.Context())
+		if !access.AllowClientIP(clientIP) {
+			return status.Errorf(codes.PermissionDenied, "%s not allowed", clientIP)
+		}
+		authority := ""
+		if md, ok := metadata.FromIncomingContext(ss.Context()); ok && len(md.Get(":authority")) > 0 {
+			authority = md.Get(":authority")[0]
+		}
+		if !access.AllowHost(authority) {
+			return status.Errorf(codes.PermissionDenied, "host %s not allowed", authority)
+		}
+		return handler(srv, ss)
+	}
+}
+
+// 客户端ip，来自可信代理时采信x-forwarded-for等metadata
+func GetClientIP(ctx context.Context) string {
+	remoteIP := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		remoteIP, _, _ = net.SplitHostPort(p.Addr.String())
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	return public.ResolveClientIP(remoteIP, func(name string) string {
+		if values := md.Get(name); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	})
+}
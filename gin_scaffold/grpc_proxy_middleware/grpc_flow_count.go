@@ -0,0 +1,46 @@
+package grpc_proxy_middleware
+
+import (
+	"gin_scaffold/public"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 按stream统计全站和服务的请求量，结束后记录耗时和服务端错误
+func GrpcFlowCountMiddleware() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		service, ok := GetService(ss.Context())
+		if !ok {
+			return status.Errorf(codes.Internal, "service not found")
+		}
+		counters := []*public.RedisFlowCountService{}
+		if totalCounter, err := public.FlowCounterHandler.GetCounter(public.FlowTotal); err == nil {
+			totalCounter.Increase()
+			counters = append(counters, totalCounter)
+		}
+		if serviceCounter, err := public.FlowCounterHandler.GetCounter(public.FlowServicePrefix + service.Info.ServiceName); err == nil {
+			serviceCounter.Increase()
+			counters = append(counters, serviceCounter)
+		}
+		start := time.Now()
+		err := handler(srv, ss)
+		latency := time.Since(start)
+		failed := isGrpcServerError(err)
+		for _, counter := range counters {
+			counter.Observe(latency, failed)
+		}
+		return err
+	}
+}
+
+// 与http的5xx对应的状态码记为错误
+func isGrpcServerError(err error) bool {
+	switch status.Code(err) {
+	case codes.Unknown, codes.DeadlineExceeded, codes.Unimplemented, codes.Internal, codes.Unavailable, codes.DataLoss:
+		return true
+	}
+	return false
+}
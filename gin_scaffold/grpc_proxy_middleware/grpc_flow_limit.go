@@ -0,0 +1,40 @@
+package grpc_proxy_middleware
+
+import (
+	"gin_scaffold/public"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// 按stream做令牌桶限流，超过限制返回ResourceExhausted并在trailer中带上retry-after
+func GrpcFlowLimitMiddleware() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		service, ok := GetService(ss.Context())
+		if !ok {
+			return status.Errorf(codes.Internal, "service not found")
+		}
+		access := service.AccessControl
+		if access == nil {
+			return handler(srv, ss)
+		}
+		serviceName := service.Info.ServiceName
+		if ok, delay := public.AllowFlow(public.FlowServicePrefix+serviceName, float64(access.ServiceFlowLimit), access.FlowLimitType); !ok {
+			return flowLimitError(ss, delay, "service flow limit %v", access.ServiceFlowLimit)
+		}
+		clientIP := GetClientIP(ss.Context())
+		if ok, delay := public.AllowFlow(public.FlowServicePrefix+serviceName+"_"+clientIP, float64(access.ClientIPFlowLimit), access.FlowLimitType); !ok {
+			return flowLimitError(ss, delay, "%s flow limit %v", clientIP, access.ClientIPFlowLimit)
+		}
+		return handler(srv, ss)
+	}
+}
+
+func flowLimitError(ss grpc.ServerStream, delay time.Duration, format string, args ...interface{}) error {
+	ss.SetTrailer(metadata.Pairs("retry-after", strconv.Itoa(public.RetryAfterSeconds(delay))))
+	return status.Errorf(codes.ResourceExhausted, format, args...)
+}
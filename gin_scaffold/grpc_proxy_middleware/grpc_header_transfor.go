@@ -0,0 +1,39 @@
+package grpc_proxy_middleware
+
+import (
+	"gin_scaffold/public"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// 按GrpcRule.HeaderTransfor修改metadata
+func GrpcHeaderTransforMiddleware() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		service, ok := GetService(ss.Context())
+		if !ok {
+			return status.Errorf(codes.Internal, "service not found")
+		}
+		if service.GRPCRule == nil || service.GRPCRule.HeaderTransfor == "" {
+			return handler(srv, ss)
+		}
+		md, ok := metadata.FromIncomingContext(ss.Context())
+		if !ok {
+			md = metadata.MD{}
+		}
+		md = md.Copy()
+		for _, item := range public.ParseHeaderTransfor(service.GRPCRule.HeaderTransfor) {
+			switch item.Op {
+			case public.HeaderTransforAdd:
+				md.Append(item.Name, item.Value)
+			case public.HeaderTransforEdit:
+				md.Set(item.Name, item.Value)
+			case public.HeaderTransforDel:
+				md.Delete(item.Name)
+			}
+		}
+		return handler(srv, WrapServerStream(ss, metadata.NewIncomingContext(ss.Context(), md)))
+	}
+}
@@ -0,0 +1,25 @@
+package grpc_proxy_middleware
+
+import (
+	"gin_scaffold/dao"
+	"gin_scaffold/reverse_proxy"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 匹配服务的负载均衡器并透传stream
+func GrpcReverseProxyHandler() grpc.StreamHandler {
+	return func(srv interface{}, ss grpc.ServerStream) error {
+		service, ok := GetService(ss.Context())
+		if !ok {
+			return status.Errorf(codes.Internal, "service not found")
+		}
+		lb, err := dao.LoadBalancerHandler.GetLoadBalancer(service)
+		if err != nil {
+			return status.Errorf(codes.Unavailable, "get load balancer: %v", err)
+		}
+		return reverse_proxy.NewGrpcLoadBalanceHandler(service, lb)(srv, ss)
+	}
+}
@@ -0,0 +1,42 @@
+package grpc_proxy_middleware
+
+import (
+	"context"
+	"gin_scaffold/dao"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type serviceKey struct{}
+
+// 替换stream的context，用于在拦截器之间传递数据
+type wrappedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (w *wrappedStream) Context() context.Context {
+	return w.ctx
+}
+
+func WrapServerStream(ss grpc.ServerStream, ctx context.Context) grpc.ServerStream {
+	return &wrappedStream{ServerStream: ss, ctx: ctx}
+}
+
+// 每个stream取最新的服务配置放入context，配置更新无需重启监听
+func GrpcServiceMiddleware(serviceName string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		service, ok := dao.ServiceManagerHandler.GetService(serviceName)
+		if !ok {
+			return status.Errorf(codes.Unavailable, "service %s not found", serviceName)
+		}
+		return handler(srv, WrapServerStream(ss, context.WithValue(ss.Context(), serviceKey{}, service)))
+	}
+}
+
+func GetService(ctx context.Context) (*dao.ServiceDetial, bool) {
+	service, ok := ctx.Value(serviceKey{}).(*dao.ServiceDetial)
+	return service, ok
+}
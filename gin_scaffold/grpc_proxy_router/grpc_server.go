@@ -0,0 +1,102 @@
+package grpc_proxy_router
+
+import (
+	"fmt"
+	"gin_scaffold/dao"
+	"gin_scaffold/grpc_proxy_middleware"
+	"gin_scaffold/reverse_proxy"
+	"log"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc"
+)
+
+type grpcServerItem struct {
+	port   int
+	server *grpc.Server
+}
+
+var (
+	grpcServerMap    = map[string]*grpcServerItem{}
+	grpcServerLocker sync.Mutex
+)
+
+// 为每个grpc服务启动监听，并随服务增删改同步开关监听
+func GrpcServerRun() {
+	dao.ServiceManagerHandler.Register(func(change *dao.ServiceChange) {
+		syncGrpcServers()
+	})
+	syncGrpcServers()
+}
+
+func GrpcServerStop() {
+	grpcServerLocker.Lock()
+	defer grpcServerLocker.Unlock()
+	for name, item := range grpcServerMap {
+		item.server.Stop()
+		delete(grpcServerMap, name)
+		log.Printf(" [INFO] GrpcProxyStop:%d stopped\n", item.port)
+	}
+}
+
+func syncGrpcServers() {
+	grpcServerLocker.Lock()
+	defer grpcServerLocker.Unlock()
+	serviceMap := map[string]*dao.ServiceDetial{}
+	for _, service := range dao.ServiceManagerHandler.GetGrpcServiceList() {
+		if service.GRPCRule == nil || service.GRPCRule.Port == 0 {
+			continue
+		}
+		serviceMap[service.Info.ServiceName] = service
+	}
+	//先关闭已删除或端口变化的服务，避免新监听端口冲突
+	for name, item := range grpcServerMap {
+		if service, ok := serviceMap[name]; ok && service.GRPCRule.Port == item.port {
+			continue
+		}
+		item.server.Stop()
+		delete(grpcServerMap, name)
+		log.Printf(" [INFO] GrpcProxyStop:%d stopped\n", item.port)
+	}
+	for name, service := range serviceMap {
+		if _, ok := grpcServerMap[name]; ok {
+			continue
+		}
+		grpcServerMap[name] = startGrpcServer(service)
+	}
+}
+
+func startGrpcServer(service *dao.ServiceDetial) *grpcServerItem {
+	serviceName := service.Info.ServiceName
+	item := &grpcServerItem{
+		port: service.GRPCRule.Port,
+		server: grpc.NewServer(
+			grpc.ForceServerCodec(reverse_proxy.GrpcCodec),
+			grpc.ChainStreamInterceptor(
+				grpc_proxy_middleware.GrpcServiceMiddleware(serviceName),
+				grpc_proxy_middleware.GrpcFlowCountMiddleware(),
+				grpc_proxy_middleware.GrpcBlackWhiteListMiddleware(),
+				grpc_proxy_middleware.GrpcFlowLimitMiddleware(),
+				grpc_proxy_middleware.GrpcHeaderTransforMiddleware()),
+			grpc.UnknownServiceHandler(grpc_proxy_middleware.GrpcReverseProxyHandler())),
+	}
+	go func() {
+		addr := fmt.Sprintf(":%d", item.port)
+		log.Printf(" [INFO] GrpcProxyRun:%s\n", addr)
+		lis, err := net.Listen("tcp", addr)
+		if err == nil {
+			err = item.server.Serve(lis)
+		}
+		if err != nil && err != grpc.ErrServerStopped {
+			log.Printf(" [ERROR] GrpcProxyRun:%s err:%v\n", addr, err)
+			//监听失败时移除，下次同步重试
+			grpcServerLocker.Lock()
+			if grpcServerMap[serviceName] == item {
+				delete(grpcServerMap, serviceName)
+			}
+			grpcServerLocker.Unlock()
+		}
+	}()
+	return item
+}
@@ -0,0 +1,57 @@
+package http_proxy_middleware
+
+import (
+	"errors"
+	"fmt"
+	"gin_scaffold/dao"
+	"gin_scaffold/middleware"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 服务的ip黑白名单和主机白名单，白名单优先级高于黑名单
+func HTTPBlackWhiteListMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serviceInterface, ok := c.Get("service")
+		if !ok {
+			middleware.ResponseError(c, 2001, errors.New("service not found"))
+			c.Abort()
+			return
+		}
+		serviceDetail := serviceInterface.(*dao.ServiceDetial)
+		access := serviceDetail.AccessControl
+		if access == nil {
+			c.Next()
+			return
+		}
+		clientIP := c.ClientIP()
+		if !access.AllowClientIP(clientIP) {
+			middleware.ResponseErrorWithStatus(c, http.StatusForbidden, 2020, fmt.Errorf("%s not allowed", clientIP))
+			return
+		}
+		if !access.AllowHost(c.Request.Host) {
+			middleware.ResponseErrorWithStatus(c, http.StatusForbidden, 2021, fmt.Errorf("host %s not allowed", c.Request.Host))
+			return
+		}
+		c.Next()
+	}
+}
+
+// 租户的ip白名单，在权限验证写入租户之后执行
+func HTTPAppWhiteIPSMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		appInterface, ok := c.Get("app")
+		if !ok {
+			c.Next()
+			return
+		}
+		app := appInterface.(*dao.App)
+		clientIP := c.ClientIP()
+		if !app.AllowClientIP(clientIP) {
+			middleware.ResponseErrorWithStatus(c, http.StatusForbidden, 2022, fmt.Errorf("%s not in app %s white_ips", clientIP, app.AppID))
+			return
+		}
+		c.Next()
+	}
+}
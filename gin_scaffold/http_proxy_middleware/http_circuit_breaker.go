@@ -0,0 +1,44 @@
+package http_proxy_middleware
+
+import (
+	"errors"
+	"gin_scaffold/dao"
+	"gin_scaffold/middleware"
+	"gin_scaffold/reverse_proxy"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 服务级熔断，熔断打开时直接返回降级内容，节点级熔断在选取节点时处理
+func HTTPCircuitBreakerMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serviceInterface, ok := c.Get("service")
+		if !ok {
+			middleware.ResponseError(c, 2001, errors.New("service not found"))
+			c.Abort()
+			return
+		}
+		serviceDetail := serviceInterface.(*dao.ServiceDetial)
+		item := dao.CircuitBreakerHandler.GetItem(serviceDetail)
+		if item == nil {
+			c.Next()
+			return
+		}
+		breaker := item.Service()
+		generation, ok := breaker.Allow()
+		if !ok {
+			reverse_proxy.CircuitBreakerFallback(c, serviceDetail.CircuitBreaker, 2025, errors.New("service circuit breaker open"))
+			return
+		}
+		start := time.Now()
+		c.Next()
+		//客户端主动断开时结果不计入统计
+		if c.Request.Context().Err() != nil {
+			breaker.Release(generation)
+			return
+		}
+		breaker.Report(generation, c.Writer.Status() >= http.StatusInternalServerError, time.Since(start))
+	}
+}
@@ -0,0 +1,61 @@
+package http_proxy_middleware
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"gin_scaffold/dao"
+	"gin_scaffold/middleware"
+	"gin_scaffold/public"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 配置了客户端CA的服务要求https访问并出示该CA签发的客户端证书
+// 权限验证方式为客户端证书时，按证书subject映射租户并写入上下文
+func HTTPClientCertMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serviceInterface, ok := c.Get("service")
+		if !ok {
+			middleware.ResponseError(c, 2001, errors.New("service not found"))
+			c.Abort()
+			return
+		}
+		serviceDetail := serviceInterface.(*dao.ServiceDetial)
+		access := serviceDetail.AccessControl
+		//没有其他中间件处理客户端证书验证，缺少CA时拒绝而不是放行
+		if access != nil && access.OpenAuth == 1 && access.AuthType == public.AuthTypeClientCert && access.ClientCa == "" {
+			middleware.ResponseErrorWithStatus(c, http.StatusForbidden, 2023, errors.New("client ca not configured"))
+			return
+		}
+		if access == nil || access.ClientCa == "" {
+			c.Next()
+			return
+		}
+		roots, err := access.GetClientCaPool()
+		if err != nil {
+			middleware.ResponseErrorWithStatus(c, http.StatusInternalServerError, 2023, fmt.Errorf("client ca invalid: %v", err))
+			return
+		}
+		if c.Request.TLS == nil || len(c.Request.TLS.PeerCertificates) == 0 {
+			middleware.ResponseErrorWithStatus(c, http.StatusUnauthorized, 2023, errors.New("client certificate required"))
+			return
+		}
+		chain := c.Request.TLS.PeerCertificates
+		if err := public.VerifyCertChain(chain, roots, x509.ExtKeyUsageClientAuth); err != nil {
+			middleware.ResponseErrorWithStatus(c, http.StatusUnauthorized, 2023, fmt.Errorf("client certificate invalid: %v", err))
+			return
+		}
+		c.Set("client_cert", chain[0])
+		if access.OpenAuth == 1 && access.AuthType == public.AuthTypeClientCert {
+			app, ok := dao.AppManagerHandler.GetAppByCert(chain[0])
+			if !ok {
+				middleware.ResponseErrorWithStatus(c, http.StatusForbidden, 2024, fmt.Errorf("no app for certificate %s", chain[0].Subject))
+				return
+			}
+			c.Set("app", app)
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,47 @@
+package http_proxy_middleware
+
+import (
+	"gin_scaffold/dao"
+	"gin_scaffold/public"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 统计全站、服务和租户的请求量，请求结束后记录耗时和5xx错误
+func HTTPFlowCountMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serviceInterface, ok := c.Get("service")
+		if !ok {
+			c.Next()
+			return
+		}
+		serviceDetail := serviceInterface.(*dao.ServiceDetial)
+		counters := []*public.RedisFlowCountService{}
+		if totalCounter, err := public.FlowCounterHandler.GetCounter(public.FlowTotal); err == nil {
+			totalCounter.Increase()
+			counters = append(counters, totalCounter)
+		}
+		if serviceCounter, err := public.FlowCounterHandler.GetCounter(public.FlowServicePrefix + serviceDetail.Info.ServiceName); err == nil {
+			serviceCounter.Increase()
+			counters = append(counters, serviceCounter)
+		}
+		start := time.Now()
+		c.Next()
+		//租户由后续的鉴权中间件写入
+		if appInterface, ok := c.Get("app"); ok {
+			if app, ok := appInterface.(*dao.App); ok {
+				if appCounter, err := public.FlowCounterHandler.GetCounter(public.FlowAppPrefix + app.AppID); err == nil {
+					appCounter.Increase()
+					counters = append(counters, appCounter)
+				}
+			}
+		}
+		latency := time.Since(start)
+		failed := c.Writer.Status() >= http.StatusInternalServerError
+		for _, counter := range counters {
+			counter.Observe(latency, failed)
+		}
+	}
+}
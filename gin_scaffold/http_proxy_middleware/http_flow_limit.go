@@ -0,0 +1,47 @@
+package http_proxy_middleware
+
+import (
+	"errors"
+	"fmt"
+	"gin_scaffold/dao"
+	"gin_scaffold/middleware"
+	"gin_scaffold/public"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 按AccessControl的服务限流和客户端ip限流做令牌桶限流
+// 先检查客户端ip，被拒绝的请求不占用服务整体的配额
+func HTTPFlowLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serviceInterface, ok := c.Get("service")
+		if !ok {
+			middleware.ResponseError(c, 2001, errors.New("service not found"))
+			c.Abort()
+			return
+		}
+		serviceDetail := serviceInterface.(*dao.ServiceDetial)
+		access := serviceDetail.AccessControl
+		if access == nil {
+			c.Next()
+			return
+		}
+		serviceName := serviceDetail.Info.ServiceName
+		clientIP := c.ClientIP()
+		if ok, delay := public.AllowFlow(public.FlowServicePrefix+serviceName+"_"+clientIP, float64(access.ClientIPFlowLimit), access.FlowLimitType); !ok {
+			c.Header("Retry-After", strconv.Itoa(public.RetryAfterSeconds(delay)))
+			middleware.ResponseErrorWithStatus(c, http.StatusTooManyRequests, 2009, fmt.Errorf("%s flow limit %v", clientIP, access.ClientIPFlowLimit))
+			c.Abort()
+			return
+		}
+		if ok, delay := public.AllowFlow(public.FlowServicePrefix+serviceName, float64(access.ServiceFlowLimit), access.FlowLimitType); !ok {
+			c.Header("Retry-After", strconv.Itoa(public.RetryAfterSeconds(delay)))
+			middleware.ResponseErrorWithStatus(c, http.StatusTooManyRequests, 2008, fmt.Errorf("service flow limit %v", access.ServiceFlowLimit))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
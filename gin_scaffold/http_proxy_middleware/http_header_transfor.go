@@ -0,0 +1,38 @@
+package http_proxy_middleware
+
+import (
+	"errors"
+	"gin_scaffold/dao"
+	"gin_scaffold/middleware"
+	"gin_scaffold/public"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 按HttpRule.HeaderTransfor修改请求头
+func HTTPHeaderTransforMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serviceInterface, ok := c.Get("service")
+		if !ok {
+			middleware.ResponseError(c, 2001, errors.New("service not found"))
+			c.Abort()
+			return
+		}
+		serviceDetail := serviceInterface.(*dao.ServiceDetial)
+		if serviceDetail.HTTPRule == nil {
+			c.Next()
+			return
+		}
+		for _, item := range public.ParseHeaderTransfor(serviceDetail.HTTPRule.HeaderTransfor) {
+			switch item.Op {
+			case public.HeaderTransforAdd:
+				c.Request.Header.Add(item.Name, item.Value)
+			case public.HeaderTransforEdit:
+				c.Request.Header.Set(item.Name, item.Value)
+			case public.HeaderTransforDel:
+				c.Request.Header.Del(item.Name)
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,51 @@
+package http_proxy_middleware
+
+import (
+	"errors"
+	"gin_scaffold/dao"
+	"gin_scaffold/middleware"
+	"gin_scaffold/public"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 开启权限验证且验证方式为JWT的服务校验Bearer token，并把租户写入上下文供后续中间件使用
+func HTTPJwtAuthTokenMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serviceInterface, ok := c.Get("service")
+		if !ok {
+			middleware.ResponseError(c, 2001, errors.New("service not found"))
+			c.Abort()
+			return
+		}
+		serviceDetail := serviceInterface.(*dao.ServiceDetial)
+		access := serviceDetail.AccessControl
+		if access == nil || access.OpenAuth != 1 || access.AuthType != public.AuthTypeJwt {
+			c.Next()
+			return
+		}
+		auth := c.GetHeader("Authorization")
+		if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+			c.Header("WWW-Authenticate", `Bearer realm="gateway"`)
+			middleware.ResponseErrorWithStatus(c, http.StatusUnauthorized, 2010, errors.New("bearer token required"))
+			return
+		}
+		claims, err := public.JwtDecode(strings.TrimSpace(auth[7:]))
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer realm="gateway", error="invalid_token"`)
+			middleware.ResponseErrorWithStatus(c, http.StatusUnauthorized, 2010, err)
+			return
+		}
+		//租户删除后已签发的token随即失效
+		app, ok := dao.AppManagerHandler.GetApp(claims.Subject)
+		if !ok {
+			c.Header("WWW-Authenticate", `Bearer realm="gateway", error="invalid_token"`)
+			middleware.ResponseErrorWithStatus(c, http.StatusUnauthorized, 2011, errors.New("app not found"))
+			return
+		}
+		c.Set("app", app)
+		c.Next()
+	}
+}
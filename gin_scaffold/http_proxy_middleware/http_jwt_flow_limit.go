@@ -0,0 +1,52 @@
+package http_proxy_middleware
+
+import (
+	"fmt"
+	"gin_scaffold/dao"
+	"gin_scaffold/middleware"
+	"gin_scaffold/public"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 按租户的Qps做集群限流，按Qpd限制当日请求总量，日期按base.time_location划分
+// 先检查Qps，确定转发后再从redis占用日配额，redis不可用时按本地计数器估算
+func HTTPJwtFlowLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		appInterface, ok := c.Get("app")
+		if !ok {
+			c.Next()
+			return
+		}
+		app := appInterface.(*dao.App)
+		if ok, delay := public.AllowFlow(public.FlowAppPrefix+app.AppID, float64(app.Qps), public.FlowLimitTypeRedisSlidingWindow); !ok {
+			c.Header("Retry-After", strconv.Itoa(public.RetryAfterSeconds(delay)))
+			middleware.ResponseErrorWithStatus(c, http.StatusTooManyRequests, 2012, fmt.Errorf("app %s flow limit %v", app.AppID, app.Qps))
+			c.Abort()
+			return
+		}
+		if app.Qpd > 0 {
+			now := time.Now()
+			allowed, err := public.ReserveDayQuota(public.FlowAppPrefix+app.AppID, app.Qpd, now)
+			if err != nil {
+				counter, err := public.FlowCounterHandler.GetCounter(public.FlowAppPrefix + app.AppID)
+				if err != nil {
+					middleware.ResponseError(c, 2014, err)
+					c.Abort()
+					return
+				}
+				allowed = counter.GetDayCount(now) < app.Qpd
+			}
+			if !allowed {
+				c.Header("Retry-After", strconv.Itoa(public.SecondsToNextDay(now)))
+				middleware.ResponseErrorWithStatus(c, http.StatusTooManyRequests, 2013, fmt.Errorf("app %s daily quota %v exhausted", app.AppID, app.Qpd))
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
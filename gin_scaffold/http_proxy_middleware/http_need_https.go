@@ -0,0 +1,73 @@
+package http_proxy_middleware
+
+import (
+	"errors"
+	"fmt"
+	"gin_scaffold/dao"
+	"gin_scaffold/middleware"
+	"net"
+	"net/http"
+
+	"github.com/e421083458/golang_common/lib"
+	"github.com/gin-gonic/gin"
+)
+
+// 按HttpRule.NeedHttps区分监听：http请求https服务时重定向，https响应下发HSTS
+func HTTPNeedHttpsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serviceInterface, ok := c.Get("service")
+		if !ok {
+			middleware.ResponseError(c, 2001, errors.New("service not found"))
+			c.Abort()
+			return
+		}
+		serviceDetail := serviceInterface.(*dao.ServiceDetial)
+		rule := serviceDetail.HTTPRule
+		if rule == nil {
+			c.Next()
+			return
+		}
+		//tls监听收到的请求
+		if c.Request.TLS != nil {
+			if rule.NeedHttps != 1 && lib.GetBoolConf("proxy.https.reject_http_only") {
+				middleware.ResponseErrorWithStatus(c, http.StatusMisdirectedRequest, 2007, errors.New("service only supports http"))
+				c.Abort()
+				return
+			}
+			if rule.HstsMaxAge > 0 {
+				hsts := fmt.Sprintf("max-age=%d", rule.HstsMaxAge)
+				if rule.HstsIncludeSubdomains == 1 {
+					hsts += "; includeSubDomains"
+				}
+				c.Header("Strict-Transport-Security", hsts)
+			}
+			c.Next()
+			return
+		}
+		if rule.NeedHttps == 1 {
+			c.Redirect(GetHttpsRedirectCode(), GetHttpsRedirectURL(c.Request))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// 默认301，配置为308时保留请求方法与body
+func GetHttpsRedirectCode() int {
+	if lib.GetIntConf("proxy.https.redirect_code") == http.StatusPermanentRedirect {
+		return http.StatusPermanentRedirect
+	}
+	return http.StatusMovedPermanently
+}
+
+func GetHttpsRedirectURL(req *http.Request) string {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(req.Host); err == nil {
+		host = h
+	}
+	if _, port, err := net.SplitHostPort(lib.GetStringConf("proxy.https.addr")); err == nil && port != "" && port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+	return "https://" + host + req.URL.RequestURI()
+}
@@ -0,0 +1,40 @@
+package http_proxy_middleware
+
+import (
+	"errors"
+	"fmt"
+	"gin_scaffold/dao"
+	"gin_scaffold/middleware"
+	"gin_scaffold/reverse_proxy"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 将匹配到的服务转发到下游节点
+func HTTPReverseProxyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serviceInterface, ok := c.Get("service")
+		if !ok {
+			middleware.ResponseError(c, 2001, errors.New("service not found"))
+			c.Abort()
+			return
+		}
+		serviceDetail := serviceInterface.(*dao.ServiceDetial)
+		lb, err := dao.LoadBalancerHandler.GetLoadBalancer(serviceDetail)
+		if err != nil {
+			middleware.ResponseError(c, 2002, err)
+			c.Abort()
+			return
+		}
+		trans, err := reverse_proxy.GetUpstreamTransport(serviceDetail)
+		if err != nil {
+			middleware.ResponseErrorWithStatus(c, http.StatusBadGateway, 2003, fmt.Errorf("upstream tls config: %v", err))
+			c.Abort()
+			return
+		}
+		proxy := reverse_proxy.NewLoadBalanceReverseProxy(c, serviceDetail, lb, trans)
+		proxy.ServeHTTP(c.Writer, c.Request)
+		c.Abort()
+	}
+}
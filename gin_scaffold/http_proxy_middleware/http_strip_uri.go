@@ -0,0 +1,36 @@
+package http_proxy_middleware
+
+import (
+	"errors"
+	"gin_scaffold/dao"
+	"gin_scaffold/middleware"
+	"gin_scaffold/public"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 前缀匹配的服务去掉匹配的前缀后再转发
+func HTTPStripUriMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serviceInterface, ok := c.Get("service")
+		if !ok {
+			middleware.ResponseError(c, 2001, errors.New("service not found"))
+			c.Abort()
+			return
+		}
+		serviceDetail := serviceInterface.(*dao.ServiceDetial)
+		rule := serviceDetail.HTTPRule
+		if rule == nil || rule.RuleType != public.HTTPRuleTypefixURL || rule.NeedStripUri != 1 {
+			c.Next()
+			return
+		}
+		path := strings.TrimPrefix(c.Request.URL.Path, rule.Rule)
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		c.Request.URL.Path = path
+		c.Request.URL.RawPath = ""
+		c.Next()
+	}
+}
@@ -0,0 +1,35 @@
+package http_proxy_middleware
+
+import (
+	"errors"
+	"gin_scaffold/dao"
+	"gin_scaffold/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 按HttpRule.UrlRewrite依次重写请求路径
+func HTTPUrlRewriteMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serviceInterface, ok := c.Get("service")
+		if !ok {
+			middleware.ResponseError(c, 2001, errors.New("service not found"))
+			c.Abort()
+			return
+		}
+		serviceDetail := serviceInterface.(*dao.ServiceDetial)
+		if serviceDetail.HTTPRule == nil {
+			c.Next()
+			return
+		}
+		path := c.Request.URL.Path
+		for _, item := range serviceDetail.HTTPRule.GetUrlRewriteList() {
+			path = item.Regexp.ReplaceAllString(path, item.Replace)
+		}
+		if path != c.Request.URL.Path {
+			c.Request.URL.Path = path
+			c.Request.URL.RawPath = ""
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,96 @@
+package http_proxy_middleware
+
+import (
+	"errors"
+	"gin_scaffold/dao"
+	"gin_scaffold/middleware"
+	"gin_scaffold/reverse_proxy"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/e421083458/golang_common/lib"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	websocketConnMap    = map[string]int{}
+	websocketConnLocker sync.Mutex
+)
+
+// 处理websocket升级请求，普通请求直接放行
+func HTTPWebsocketMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !IsWebsocketRequest(c.Request) {
+			c.Next()
+			return
+		}
+		serviceInterface, ok := c.Get("service")
+		if !ok {
+			middleware.ResponseError(c, 2001, errors.New("service not found"))
+			c.Abort()
+			return
+		}
+		serviceDetail := serviceInterface.(*dao.ServiceDetial)
+		rule := serviceDetail.HTTPRule
+		if rule == nil || rule.NeedWebsocket != 1 {
+			middleware.ResponseErrorWithStatus(c, http.StatusForbidden, 2004, errors.New("websocket not enabled for this service"))
+			c.Abort()
+			return
+		}
+		serviceName := serviceDetail.Info.ServiceName
+		if !acquireWebsocketConn(serviceName, rule.WebsocketMaxConn) {
+			middleware.ResponseErrorWithStatus(c, http.StatusServiceUnavailable, 2005, errors.New("too many websocket connections"))
+			c.Abort()
+			return
+		}
+		defer releaseWebsocketConn(serviceName)
+
+		lb, err := dao.LoadBalancerHandler.GetLoadBalancer(serviceDetail)
+		if err != nil {
+			middleware.ResponseError(c, 2002, err)
+			c.Abort()
+			return
+		}
+		idleTimeout := time.Duration(lib.GetIntConf("proxy.websocket.idle_timeout")) * time.Second
+		if rule.WebsocketIdleTimeout > 0 {
+			idleTimeout = time.Duration(rule.WebsocketIdleTimeout) * time.Second
+		}
+		proxy := reverse_proxy.NewWebsocketLoadBalanceReverseProxy(c, serviceDetail, lb, idleTimeout)
+		proxy.ServeHTTP(c.Writer, c.Request)
+		c.Abort()
+	}
+}
+
+func IsWebsocketRequest(req *http.Request) bool {
+	if !strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, value := range req.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func acquireWebsocketConn(serviceName string, maxConn int) bool {
+	websocketConnLocker.Lock()
+	defer websocketConnLocker.Unlock()
+	if maxConn > 0 && websocketConnMap[serviceName] >= maxConn {
+		return false
+	}
+	websocketConnMap[serviceName]++
+	return true
+}
+
+func releaseWebsocketConn(serviceName string) {
+	websocketConnLocker.Lock()
+	defer websocketConnLocker.Unlock()
+	if websocketConnMap[serviceName]--; websocketConnMap[serviceName] <= 0 {
+		delete(websocketConnMap, serviceName)
+	}
+}
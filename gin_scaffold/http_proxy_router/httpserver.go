@@ -2,6 +2,8 @@ package http_proxy_router
 
 import (
 	"context"
+	"crypto/tls"
+	"gin_scaffold/dao"
 	"gin_scaffold/middleware"
 	"log"
 	"net/http"
@@ -52,9 +54,14 @@ func HttpsServerRun() {
 		ReadTimeout:    time.Duration(lib.GetIntConf("proxy.https.read_timeout")) * time.Second,
 		WriteTimeout:   time.Duration(lib.GetIntConf("proxy.https.write_timeout")) * time.Second,
 		MaxHeaderBytes: 1 << uint(lib.GetIntConf("proxy.https.max_header_bytes")),
+		//按SNI从证书管理器取证书，证书变更后新握手立即生效
+		TLSConfig: &tls.Config{
+			GetCertificate: dao.CertManagerHandler.GetCertificate,
+			ClientAuth:     getClientAuthType(),
+		},
 	}
 	log.Printf(" [INFO] HttpServerRun:%s\n", lib.GetStringConf("proxy.https.addr"))
-	if err := HttpsSrvHandler.ListenAndServeTLS("./cert_file/server.crt", "./cert_file/server.key"); err != nil && err != http.ErrServerClosed {
+	if err := HttpsSrvHandler.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
 		log.Fatalf(" [ERROR] HttpServerRun:%s err:%v\n", lib.GetStringConf("proxy.https.addr"), err)
 	}
 }
@@ -62,8 +69,16 @@ func HttpsServerRun() {
 func HttpsServerStop() {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	if err := HttpSrvHandler.Shutdown(ctx); err != nil {
+	if err := HttpsSrvHandler.Shutdown(ctx); err != nil {
 		log.Fatalf(" [ERROR] HttpsServerStop err:%v\n", err)
 	}
 	log.Printf(" [INFO] HttpsServerStop stopped\n")
 }
+
+// 握手时还不知道访问的是哪个服务，只请求客户端证书，由服务配置的CA在中间件中校验
+func getClientAuthType() tls.ClientAuthType {
+	if lib.GetBoolConf("proxy.https.request_client_cert") {
+		return tls.RequestClientCert
+	}
+	return tls.NoClientCert
+}
@@ -1,19 +1,52 @@
 package http_proxy_router
 
 import (
+	"gin_scaffold/controller"
+	"gin_scaffold/dao"
 	"gin_scaffold/http_proxy_middleware"
+	"gin_scaffold/middleware"
+	"gin_scaffold/public"
+	"log"
 
 	"github.com/gin-gonic/gin"
 )
 
 func InitRouter(middlewares ...gin.HandlerFunc) *gin.Engine {
 	router := gin.Default()
+	//只采信可信代理转发的客户端ip
+	router.RemoteIPHeaders = public.GetRemoteIPHeaders()
+	if err := router.SetTrustedProxies(public.GetTrustedProxies()); err != nil {
+		log.Printf(" [WARNING] SetTrustedProxies err:%v\n", err)
+		router.SetTrustedProxies(nil)
+	}
 	router.Use(middlewares...)
 	router.GET("/ping", func(c *gin.Context) {
 		c.JSON(200, gin.H{
 			"message": "pong",
+			"version": dao.GetConfigVersion(),
 		})
 	})
-	router.Use(http_proxy_middleware.HTTPAccessModeMiddleware())
+	oauth := router.Group("/oauth")
+	oauth.Use(middleware.TranslationMiddleware())
+	{
+		controller.OAuthRegister(oauth)
+	}
+	router.Use(
+		http_proxy_middleware.HTTPAccessModeMiddleware(),
+		http_proxy_middleware.HTTPFlowCountMiddleware(),
+		http_proxy_middleware.HTTPNeedHttpsMiddleware(),
+		http_proxy_middleware.HTTPBlackWhiteListMiddleware(),
+		http_proxy_middleware.HTTPFlowLimitMiddleware(),
+		http_proxy_middleware.HTTPClientCertMiddleware(),
+		http_proxy_middleware.HTTPJwtAuthTokenMiddleware(),
+		http_proxy_middleware.HTTPHmacAuthMiddleware(),
+		http_proxy_middleware.HTTPAppWhiteIPSMiddleware(),
+		http_proxy_middleware.HTTPJwtFlowLimitMiddleware(),
+		http_proxy_middleware.HTTPHeaderTransforMiddleware(),
+		http_proxy_middleware.HTTPStripUriMiddleware(),
+		http_proxy_middleware.HTTPUrlRewriteMiddleware(),
+		http_proxy_middleware.HTTPWebsocketMiddleware(),
+		http_proxy_middleware.HTTPCircuitBreakerMiddleware(),
+		http_proxy_middleware.HTTPReverseProxyMiddleware())
 	return router
 }
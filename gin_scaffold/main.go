@@ -4,11 +4,16 @@ import (
 	"flag"
 	"fmt"
 	"gin_scaffold/dao"
+	"gin_scaffold/grpc_proxy_router"
 	"gin_scaffold/http_proxy_router"
+	"gin_scaffold/public"
+	"gin_scaffold/reverse_proxy"
 	"gin_scaffold/router"
+	"gin_scaffold/tcp_proxy_router"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/e421083458/golang_common/lib"
 )
@@ -33,7 +38,7 @@ func main() {
 		lib.InitModule(*config, []string{"base", "mysql", "redis"})
 		defer lib.Destroy()
 		router.HttpServerRun()
-		quit := make(chan os.Signal)
+		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGKILL, syscall.SIGQUIT, syscall.SIGINT, syscall.SIGTERM)
 		<-quit
 		router.HttpServerStop()
@@ -41,15 +46,34 @@ func main() {
 		lib.InitModule(*config, []string{"base", "mysql", "redis"})
 		defer lib.Destroy()
 		dao.ServiceManagerHandler.LoadOnce()
+		dao.AppManagerHandler.LoadOnce()
+		dao.CertManagerHandler.LoadOnce()
+		public.StartFlowLimiterClean()
+		dao.StartAutoCertRenew(time.Duration(lib.GetIntConf("proxy.auto_cert.check_interval")) * time.Second)
+		dao.StartConfigSync(time.Duration(lib.GetIntConf("proxy.reload.interval")) * time.Second)
+		dao.StartFlowStatPersist(time.Duration(lib.GetIntConf("proxy.flow_stat.persist_interval")) * time.Second)
+		reverse_proxy.StartTransportStatReport(time.Duration(lib.GetIntConf("proxy.transport.stat_interval")) * time.Second)
 		go func() {
 			http_proxy_router.HttpServerRun()
 		}()
 		go func() {
 			http_proxy_router.HttpsServerRun()
 		}()
+		tcp_proxy_router.TcpServerRun()
+		grpc_proxy_router.GrpcServerRun()
 		fmt.Println("START SERVER")
-		quit := make(chan os.Signal)
+		//kill -HUP 立即重新加载服务配置
+		reload := make(chan os.Signal, 1)
+		signal.Notify(reload, syscall.SIGHUP)
+		go func() {
+			for range reload {
+				dao.FullResync()
+			}
+		}()
+		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGKILL, syscall.SIGQUIT, syscall.SIGINT, syscall.SIGTERM)
 		<-quit
+		tcp_proxy_router.TcpServerStop()
+		grpc_proxy_router.GrpcServerStop()
 	}
 }
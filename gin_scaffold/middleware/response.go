@@ -32,6 +32,11 @@ type Response struct {
 }
 
 func ResponseError(c *gin.Context, code ResponseCode, err error) {
+	ResponseErrorWithStatus(c, 200, code, err)
+}
+
+// 指定http状态码输出错误信息，代理层需要把下游异常透传给客户端
+func ResponseErrorWithStatus(c *gin.Context, status int, code ResponseCode, err error) {
 	trace, _ := c.Get("trace")
 	traceContext, _ := trace.(*lib.TraceContext)
 	traceId := ""
@@ -45,10 +50,10 @@ func ResponseError(c *gin.Context, code ResponseCode, err error) {
 	}
 
 	resp := &Response{ErrorCode: code, ErrorMsg: err.Error(), Data: "", TraceId: traceId, Stack: stack}
-	c.JSON(200, resp)
+	c.JSON(status, resp)
 	response, _ := json.Marshal(resp)
 	c.Set("response", string(response))
-	c.AbortWithError(200, err)
+	c.AbortWithError(status, err)
 }
 
 func ResponseSuccess(c *gin.Context, data interface{}) {
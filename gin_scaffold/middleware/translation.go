@@ -66,10 +66,14 @@ func TranslationMiddleware() gin.HandlerFunc {
 				if fl.Field().String() == "" {
 					return true
 				}
-				//需要重写
+				//每行: 正则 替换内容
 				input := strings.Split(fl.Field().String(), "\n")
 				for _, ms := range input {
-					if len(ms) != 2 {
+					items := strings.Fields(ms)
+					if len(items) != 2 {
+						return false
+					}
+					if _, err := regexp.Compile(items[0]); err != nil {
 						return false
 					}
 				}
@@ -79,10 +83,23 @@ func TranslationMiddleware() gin.HandlerFunc {
 				if fl.Field().String() == "" {
 					return true
 				}
-				//需要重写
+				//每行: add/edit name value 或 del name
 				input := strings.Split(fl.Field().String(), "\n")
 				for _, ms := range input {
-					if len(ms) != 3 {
+					items := strings.Fields(ms)
+					if len(items) == 0 {
+						return false
+					}
+					switch items[0] {
+					case public.HeaderTransforAdd, public.HeaderTransforEdit:
+						if len(items) != 3 {
+							return false
+						}
+					case public.HeaderTransforDel:
+						if len(items) != 2 {
+							return false
+						}
+					default:
 						return false
 					}
 				}
@@ -100,6 +117,17 @@ func TranslationMiddleware() gin.HandlerFunc {
 				}
 				return true
 			})
+			val.RegisterValidation("valid_ip_rules", func(fl validator.FieldLevel) bool {
+				return len(public.NewIPMatcher(fl.Field().String()).Invalid()) == 0
+			})
+			val.RegisterValidation("valid_hostlist", func(fl validator.FieldLevel) bool {
+				for _, item := range public.SplitAccessList(fl.Field().String()) {
+					if !public.ValidHostRule(item) {
+						return false
+					}
+				}
+				return true
+			})
 			val.RegisterValidation("valid_weightlist", func(fl validator.FieldLevel) bool {
 				fmt.Println(fl.Field().String())
 				compiledPattern := regexp.MustCompile(`^\d+$`)
@@ -164,6 +192,18 @@ func TranslationMiddleware() gin.HandlerFunc {
 				t, _ := ut.T("valid_iplist", fe.Field())
 				return t
 			})
+			val.RegisterTranslation("valid_ip_rules", trans, func(ut ut.Translator) error {
+				return ut.Add("valid_ip_rules", "{0} 包含无法解析的ip、CIDR或前缀", true)
+			}, func(ut ut.Translator, fe validator.FieldError) string {
+				t, _ := ut.T("valid_ip_rules", fe.Field())
+				return t
+			})
+			val.RegisterTranslation("valid_hostlist", trans, func(ut ut.Translator) error {
+				return ut.Add("valid_hostlist", "{0} 包含不合法的主机名", true)
+			}, func(ut ut.Translator, fe validator.FieldError) string {
+				t, _ := ut.T("valid_hostlist", fe.Field())
+				return t
+			})
 			val.RegisterTranslation("valid_weightlist", trans, func(ut ut.Translator) error {
 				return ut.Add("valid_weightlist", "{0} 不符合输入格式", true)
 			}, func(ut ut.Translator, fe validator.FieldError) string {
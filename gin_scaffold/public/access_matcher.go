@@ -0,0 +1,179 @@
+package public
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// 按逗号、换行或空白拆分名单
+func SplitAccessList(rule string) []string {
+	return strings.FieldsFunc(rule, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r' || r == ' ' || r == '\t'
+	})
+}
+
+// ip名单，支持单个ip、CIDR(10.0.0.0/8)和前缀(192.168.1、192.168.1.*)
+// 前缀按段匹配，192.168.1不会匹配192.168.10.1
+type IPMatcher struct {
+	ips        map[string]struct{}
+	nets       []*net.IPNet
+	prefixes   []string
+	configured bool
+	invalid    []string
+}
+
+// 无法解析的条目记录在Invalid中并忽略
+func NewIPMatcher(rule string) *IPMatcher {
+	items := SplitAccessList(rule)
+	matcher := &IPMatcher{ips: map[string]struct{}{}, configured: len(items) > 0}
+	for _, item := range items {
+		if strings.Contains(item, "/") {
+			if _, ipNet, err := net.ParseCIDR(item); err == nil {
+				matcher.nets = append(matcher.nets, ipNet)
+			} else {
+				matcher.invalid = append(matcher.invalid, item)
+			}
+			continue
+		}
+		if ip := net.ParseIP(item); ip != nil {
+			matcher.ips[ip.String()] = struct{}{}
+			continue
+		}
+		prefix := strings.TrimSuffix(item, "*")
+		if !validIPPrefix(prefix) {
+			matcher.invalid = append(matcher.invalid, item)
+			continue
+		}
+		if !strings.HasSuffix(prefix, ".") && !strings.HasSuffix(prefix, ":") {
+			if strings.Contains(prefix, ":") {
+				prefix += ":"
+			} else {
+				prefix += "."
+			}
+		}
+		matcher.prefixes = append(matcher.prefixes, prefix)
+	}
+	return matcher
+}
+
+// ipv4前缀为1到3段0-255的数字，ipv6前缀为十六进制段
+func validIPPrefix(prefix string) bool {
+	if strings.Contains(prefix, ":") {
+		segments := strings.Split(strings.TrimSuffix(prefix, ":"), ":")
+		for _, segment := range segments {
+			if len(segment) > 4 {
+				return false
+			}
+			if _, err := strconv.ParseUint("0"+segment, 16, 16); err != nil {
+				return false
+			}
+		}
+		return len(segments) < 8
+	}
+	segments := strings.Split(strings.TrimSuffix(prefix, "."), ".")
+	if len(segments) > 3 {
+		return false
+	}
+	for _, segment := range segments {
+		if n, err := strconv.Atoi(segment); err != nil || n < 0 || n > 255 {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *IPMatcher) Len() int {
+	return len(m.ips) + len(m.nets) + len(m.prefixes)
+}
+
+// 名单配置了条目，即使全部无法解析也返回true，白名单据此拒绝所有请求
+func (m *IPMatcher) Configured() bool {
+	return m.configured
+}
+
+func (m *IPMatcher) Invalid() []string {
+	return m.invalid
+}
+
+func (m *IPMatcher) Match(clientIP string) bool {
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false
+	}
+	if _, ok := m.ips[ip.String()]; ok {
+		return true
+	}
+	for _, ipNet := range m.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	for _, prefix := range m.prefixes {
+		if strings.HasPrefix(ip.String(), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// 完整域名或*.example.com，ip也可作为主机
+func ValidHostRule(item string) bool {
+	host := strings.TrimPrefix(item, "*.")
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// 主机名单，支持完整域名和*.example.com形式的子域名通配，不区分大小写
+type HostMatcher struct {
+	hosts    map[string]struct{}
+	suffixes []string
+}
+
+func NewHostMatcher(rule string) *HostMatcher {
+	matcher := &HostMatcher{hosts: map[string]struct{}{}}
+	for _, item := range SplitAccessList(strings.ToLower(rule)) {
+		if strings.HasPrefix(item, "*.") {
+			matcher.suffixes = append(matcher.suffixes, item[1:])
+			continue
+		}
+		matcher.hosts[item] = struct{}{}
+	}
+	return matcher
+}
+
+func (m *HostMatcher) Len() int {
+	return len(m.hosts) + len(m.suffixes)
+}
+
+// host可以带端口
+func (m *HostMatcher) Match(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if _, ok := m.hosts[host]; ok {
+		return true
+	}
+	for _, suffix := range m.suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
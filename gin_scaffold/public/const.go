@@ -9,4 +9,33 @@ const (
 	LoadTypeGrpc        = 2
 	HTTPRuleTypeDomain  = 1
 	HTTPRuleTypefixURL  = 0
+
+	NodeHealthPrefix        = "gateway_node_health_"
+	TransportStatsPrefix    = "gateway_transport_stats_"
+	FlowTotal               = "flow_total"
+	FlowServicePrefix       = "flow_service_"
+	FlowAppPrefix           = "flow_app_"
+	RetryBudgetPrefix       = "retry_budget_"
+	RedisFlowDayKey         = "gateway_flow_day_count"
+	RedisFlowHourKey        = "gateway_flow_hour_count"
+	RedisFlowMinuteKey      = "gateway_flow_minute_count"
+	RedisFlowHourErrorKey   = "gateway_flow_hour_error"
+	RedisFlowHourLatencyKey = "gateway_flow_hour_latency"
+	RedisFlowLimitPrefix    = "gateway_flow_limit_"
+	RedisFlowQuotaPrefix    = "gateway_flow_quota_"
+	ConfigChangeChannel     = "gateway_config_change"
+	ConfigVersionKey        = "gateway_config_version"
+	JwtIssuer               = "gin_scaffold_gateway"
+	RedisHmacNoncePrefix    = "gateway_hmac_nonce_"
+	RedisAutoCertPrefix     = "gateway_auto_cert_"
+	RedisAutoCertLockPrefix = "gateway_auto_cert_lock_"
+	AuthTypeJwt             = 0
+	AuthTypeHmac            = 1
+	AuthTypeClientCert      = 2
 )
+
+var LoadTypeMap = map[int]string{
+	LoadTypeHTTP: "HTTP",
+	LoadTypeTCP:  "TCP",
+	LoadTypeGrpc: "GRPC",
+}
@@ -1,7 +1,261 @@
 package public
 
-type FlowCounterHandler struct{}
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
-func (f *FlowCounterHandler) GetCounter(x string) {
+	"github.com/e421083458/golang_common/lib"
+	"github.com/garyburd/redigo/redis"
+)
 
+// 计数器刷入redis的间隔
+const flowCountInterval = time.Second
+
+// 小时/天计数key的过期时间，保证昨日数据可查
+const flowCountExpire = 86400 * 2
+
+// 分钟计数key只用于读取上一分钟的qps
+const flowMinuteExpire = 180
+
+var FlowCounterHandler *FlowCounter
+
+type FlowCounter struct {
+	RedisFlowCountMap map[string]*RedisFlowCountService
+	Locker            sync.RWMutex
+}
+
+func init() {
+	FlowCounterHandler = NewFlowCounter()
+}
+
+func NewFlowCounter() *FlowCounter {
+	return &FlowCounter{
+		RedisFlowCountMap: map[string]*RedisFlowCountService{},
+	}
+}
+
+// 按名称获取计数器，不存在时创建并启动定时刷新，只在代理中使用，dashboard使用QueryFlowCount
+func (counter *FlowCounter) GetCounter(name string) (*RedisFlowCountService, error) {
+	counter.Locker.RLock()
+	item, ok := counter.RedisFlowCountMap[name]
+	counter.Locker.RUnlock()
+	if ok {
+		return item, nil
+	}
+	counter.Locker.Lock()
+	defer counter.Locker.Unlock()
+	if item, ok := counter.RedisFlowCountMap[name]; ok {
+		return item, nil
+	}
+	item = NewRedisFlowCountService(name, flowCountInterval)
+	counter.RedisFlowCountMap[name] = item
+	return item, nil
+}
+
+// 当前实例上有过请求的全部计数器
+func (counter *FlowCounter) GetCounterList() []*RedisFlowCountService {
+	counter.Locker.RLock()
+	defer counter.Locker.RUnlock()
+	list := []*RedisFlowCountService{}
+	for _, item := range counter.RedisFlowCountMap {
+		list = append(list, item)
+	}
+	return list
+}
+
+// 请求先累加到本地，定时批量写入redis的小时和天维度key
+// QPS和TotalCount取自redis中的当日总量，是整个集群的数据
+type RedisFlowCountService struct {
+	Name        string
+	Interval    time.Duration
+	QPS         int64
+	Unix        int64
+	TickerCount int64
+	TotalCount  int64
+	ErrorCount  int64
+
+	latencyBuckets [flowLatencyBucketNum]int64
+}
+
+func NewRedisFlowCountService(name string, interval time.Duration) *RedisFlowCountService {
+	service := &RedisFlowCountService{
+		Name:     name,
+		Interval: interval,
+	}
+	go service.flushLoop()
+	return service
+}
+
+func (o *RedisFlowCountService) flushLoop() {
+	//启动时先读一次当日总量
+	o.flush(time.Now())
+	ticker := time.NewTicker(o.Interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		o.flush(time.Now())
+	}
+}
+
+func (o *RedisFlowCountService) flush(now time.Time) {
+	tickerCount := atomic.SwapInt64(&o.TickerCount, 0)
+	errorCount := atomic.SwapInt64(&o.ErrorCount, 0)
+	latencyBuckets := [flowLatencyBucketNum]int64{}
+	latencyTotal := int64(0)
+	for i := range o.latencyBuckets {
+		latencyBuckets[i] = atomic.SwapInt64(&o.latencyBuckets[i], 0)
+		latencyTotal += latencyBuckets[i]
+	}
+	dayKey := o.GetDayKey(now)
+	hourKey := o.GetHourKey(now)
+	if tickerCount > 0 || errorCount > 0 || latencyTotal > 0 {
+		minuteKey := flowMinuteKey(o.Name, now)
+		errorKey := o.GetHourErrorKey(now)
+		latencyKey := o.GetHourLatencyKey(now)
+		if err := RedisConfPipline(func(c redis.Conn) {
+			c.Send("INCRBY", dayKey, tickerCount)
+			c.Send("EXPIRE", dayKey, flowCountExpire)
+			c.Send("INCRBY", hourKey, tickerCount)
+			c.Send("EXPIRE", hourKey, flowCountExpire)
+			c.Send("INCRBY", minuteKey, tickerCount)
+			c.Send("EXPIRE", minuteKey, flowMinuteExpire)
+			if errorCount > 0 {
+				c.Send("INCRBY", errorKey, errorCount)
+				c.Send("EXPIRE", errorKey, flowCountExpire)
+			}
+			if latencyTotal > 0 {
+				for i, count := range latencyBuckets {
+					if count > 0 {
+						c.Send("HINCRBY", latencyKey, i, count)
+					}
+				}
+				c.Send("EXPIRE", latencyKey, flowCountExpire)
+			}
+		}); err != nil {
+			//redis不可用时计数留到下个周期再写
+			atomic.AddInt64(&o.TickerCount, tickerCount)
+			atomic.AddInt64(&o.ErrorCount, errorCount)
+			for i, count := range latencyBuckets {
+				atomic.AddInt64(&o.latencyBuckets[i], count)
+			}
+			ContextWarning(context.Background(), "_com_flow_count_failure", map[string]interface{}{
+				"name": o.Name,
+				"err":  err.Error(),
+			})
+			return
+		}
+	}
+	totalCount, err := o.GetDayData(now)
+	if err != nil {
+		return
+	}
+	nowUnix := now.Unix()
+	lastUnix := atomic.LoadInt64(&o.Unix)
+	lastTotal := atomic.LoadInt64(&o.TotalCount)
+	if lastUnix > 0 && nowUnix > lastUnix {
+		delta := totalCount - lastTotal
+		//跨天后当日总量从0开始
+		if delta < 0 {
+			delta = totalCount
+		}
+		atomic.StoreInt64(&o.QPS, delta/(nowUnix-lastUnix))
+	}
+	atomic.StoreInt64(&o.TotalCount, totalCount)
+	atomic.StoreInt64(&o.Unix, nowUnix)
+}
+
+// 每个请求调用一次
+func (o *RedisFlowCountService) Increase() {
+	atomic.AddInt64(&o.TickerCount, 1)
+}
+
+// 请求结束后记录耗时，failed表示下游出错
+func (o *RedisFlowCountService) Observe(latency time.Duration, failed bool) {
+	if failed {
+		atomic.AddInt64(&o.ErrorCount, 1)
+	}
+	atomic.AddInt64(&o.latencyBuckets[flowLatencyBucketIndex(latency)], 1)
+}
+
+// 当日累计请求量：redis中的集群总量加上本地尚未写入的部分，跨天后从0开始
+func (o *RedisFlowCountService) GetDayCount(now time.Time) int64 {
+	count := atomic.LoadInt64(&o.TickerCount)
+	lastUnix := atomic.LoadInt64(&o.Unix)
+	if lastUnix > 0 && o.GetDayKey(time.Unix(lastUnix, 0)) == o.GetDayKey(now) {
+		count += atomic.LoadInt64(&o.TotalCount)
+	}
+	return count
+}
+
+func (o *RedisFlowCountService) GetDayKey(t time.Time) string {
+	return flowDayKey(o.Name, t)
+}
+
+func (o *RedisFlowCountService) GetHourKey(t time.Time) string {
+	return flowHourKey(o.Name, t)
+}
+
+func (o *RedisFlowCountService) GetDayData(t time.Time) (int64, error) {
+	return getFlowCount(o.GetDayKey(t))
+}
+
+func (o *RedisFlowCountService) GetHourData(t time.Time) (int64, error) {
+	return getFlowCount(o.GetHourKey(t))
+}
+
+func flowDayKey(name string, t time.Time) string {
+	return fmt.Sprintf("%s_%s_%s", RedisFlowDayKey, t.In(FlowCountLocation()).Format("20060102"), name)
+}
+
+func flowHourKey(name string, t time.Time) string {
+	return fmt.Sprintf("%s_%s_%s", RedisFlowHourKey, t.In(FlowCountLocation()).Format("2006010215"), name)
+}
+
+func flowMinuteKey(name string, t time.Time) string {
+	return fmt.Sprintf("%s_%s_%s", RedisFlowMinuteKey, t.In(FlowCountLocation()).Format("200601021504"), name)
+}
+
+// 集群的当日请求量和实时qps
+type FlowCountSnapshot struct {
+	TotalCount int64
+	QPS        int64
+}
+
+// 只读查询，供dashboard使用，不创建计数器和刷新协程；qps取上一整分钟的平均值
+func QueryFlowCount(name string, now time.Time) (*FlowCountSnapshot, error) {
+	totalCount, err := getFlowCount(flowDayKey(name, now))
+	if err != nil {
+		return nil, err
+	}
+	lastMinute, err := getFlowCount(flowMinuteKey(name, now.Add(-time.Minute)))
+	if err != nil {
+		return nil, err
+	}
+	return &FlowCountSnapshot{TotalCount: totalCount, QPS: lastMinute / 60}, nil
+}
+
+// key不存在说明该时段没有请求
+func getFlowCount(key string) (int64, error) {
+	count, err := redis.Int64(RedisConfDo("GET", key))
+	if err == redis.ErrNil {
+		return 0, nil
+	}
+	return count, err
+}
+
+// 统计按base.time_location划分小时和天，未初始化时使用本地时区
+func FlowCountLocation() *time.Location {
+	if lib.TimeLocation != nil {
+		return lib.TimeLocation
+	}
+	return time.Local
+}
+
+// 距离统计时区下一个零点的秒数，日配额耗尽时用作Retry-After
+func SecondsToNextDay(now time.Time) int {
+	t := now.In(FlowCountLocation())
+	next := time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+	return RetryAfterSeconds(next.Sub(now))
 }
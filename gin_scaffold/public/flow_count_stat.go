@@ -0,0 +1,96 @@
+package public
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// 耗时分桶上限, 单位ms，超过最后一个分桶的请求计入溢出桶
+var flowLatencyBuckets = [...]int64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+const flowLatencyBucketNum = len(flowLatencyBuckets) + 1
+
+func flowLatencyBucketIndex(latency time.Duration) int {
+	ms := latency.Milliseconds()
+	for i, bound := range flowLatencyBuckets {
+		if ms <= bound {
+			return i
+		}
+	}
+	return len(flowLatencyBuckets)
+}
+
+// 某一小时的集群汇总数据，P95为所在分桶的上限, 单位ms
+type FlowHourStat struct {
+	RequestCount int64
+	ErrorCount   int64
+	P95          int64
+}
+
+func (o *RedisFlowCountService) GetHourErrorKey(t time.Time) string {
+	return fmt.Sprintf("%s_%s_%s", RedisFlowHourErrorKey, t.In(FlowCountLocation()).Format("2006010215"), o.Name)
+}
+
+func (o *RedisFlowCountService) GetHourLatencyKey(t time.Time) string {
+	return fmt.Sprintf("%s_%s_%s", RedisFlowHourLatencyKey, t.In(FlowCountLocation()).Format("2006010215"), o.Name)
+}
+
+// 只读查询某一小时的集群汇总，不创建计数器和刷新协程
+func QueryFlowHourStat(name string, t time.Time) (*FlowHourStat, error) {
+	return (&RedisFlowCountService{Name: name}).GetHourStat(t)
+}
+
+func (o *RedisFlowCountService) GetHourStat(t time.Time) (*FlowHourStat, error) {
+	requestCount, err := o.GetHourData(t)
+	if err != nil {
+		return nil, err
+	}
+	errorCount, err := getFlowCount(o.GetHourErrorKey(t))
+	if err != nil {
+		return nil, err
+	}
+	buckets, err := redis.Int64Map(RedisConfDo("HGETALL", o.GetHourLatencyKey(t)))
+	if err != nil {
+		return nil, err
+	}
+	counts := [flowLatencyBucketNum]int64{}
+	for field, count := range buckets {
+		index, err := strconv.Atoi(field)
+		if err != nil || index < 0 || index >= flowLatencyBucketNum {
+			continue
+		}
+		counts[index] = count
+	}
+	return &FlowHourStat{
+		RequestCount: requestCount,
+		ErrorCount:   errorCount,
+		P95:          FlowLatencyPercentile(counts[:], 0.95),
+	}, nil
+}
+
+// 按分桶计数估算分位值，溢出桶按最后一个分桶上限计
+func FlowLatencyPercentile(counts []int64, percent float64) int64 {
+	total := int64(0)
+	for _, count := range counts {
+		total += count
+	}
+	if total == 0 {
+		return 0
+	}
+	target := int64(math.Ceil(float64(total) * percent))
+	current := int64(0)
+	for i, count := range counts {
+		current += count
+		if current >= target {
+			if i < len(flowLatencyBuckets) {
+				return flowLatencyBuckets[i]
+			}
+			break
+		}
+	}
+	return flowLatencyBuckets[len(flowLatencyBuckets)-1]
+}
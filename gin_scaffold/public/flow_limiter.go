@@ -0,0 +1,105 @@
+package public
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// 长时间未使用的限流器会被清理，避免客户端ip维度无限增长
+const flowLimiterIdleTimeout = 10 * time.Minute
+
+var FlowLimiterHandler *FlowLimiter
+
+type FlowLimiter struct {
+	FlowLimiterMap map[string]*FlowLimiterItem
+	Locker         sync.Mutex
+}
+
+type FlowLimiterItem struct {
+	Name     string
+	Qps      float64
+	Limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+func init() {
+	FlowLimiterHandler = NewFlowLimiter()
+}
+
+// 代理启动时调用，定时清理单机和集群限流器中长时间未使用的条目
+func StartFlowLimiterClean() {
+	go FlowLimiterHandler.cleanLoop()
+	go RedisFlowLimiterHandler.cleanLoop()
+}
+
+func NewFlowLimiter() *FlowLimiter {
+	return &FlowLimiter{
+		FlowLimiterMap: map[string]*FlowLimiterItem{},
+	}
+}
+
+// 获取令牌桶，qps变化(配置reload)时原地调整速率，保留已有令牌
+func (l *FlowLimiter) GetLimiter(name string, qps float64) *rate.Limiter {
+	l.Locker.Lock()
+	defer l.Locker.Unlock()
+	item, ok := l.FlowLimiterMap[name]
+	if !ok {
+		item = &FlowLimiterItem{
+			Name:    name,
+			Qps:     qps,
+			Limiter: rate.NewLimiter(rate.Limit(qps), flowLimiterBurst(qps)),
+		}
+		l.FlowLimiterMap[name] = item
+	} else if item.Qps != qps {
+		item.Qps = qps
+		item.Limiter.SetLimit(rate.Limit(qps))
+		item.Limiter.SetBurst(flowLimiterBurst(qps))
+	}
+	item.lastUsed = time.Now()
+	return item.Limiter
+}
+
+// 尝试取一个令牌，失败时返回需要等待的时长
+func (l *FlowLimiter) Allow(name string, qps float64) (bool, time.Duration) {
+	if qps <= 0 {
+		return true, 0
+	}
+	r := l.GetLimiter(name, qps).Reserve()
+	if !r.OK() {
+		return false, time.Second
+	}
+	if delay := r.Delay(); delay > 0 {
+		r.Cancel()
+		return false, delay
+	}
+	return true, 0
+}
+
+func (l *FlowLimiter) cleanLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		l.Locker.Lock()
+		for name, item := range l.FlowLimiterMap {
+			if time.Since(item.lastUsed) > flowLimiterIdleTimeout {
+				delete(l.FlowLimiterMap, name)
+			}
+		}
+		l.Locker.Unlock()
+	}
+}
+
+func flowLimiterBurst(qps float64) int {
+	if qps < 1 {
+		return 1
+	}
+	return int(qps)
+}
+
+// Retry-After按秒向上取整
+func RetryAfterSeconds(delay time.Duration) int {
+	return int(math.Max(1, math.Ceil(delay.Seconds())))
+}
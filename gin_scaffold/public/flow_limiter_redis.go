@@ -0,0 +1,269 @@
+package public
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/e421083458/golang_common/lib"
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	FlowLimitTypeLocal              = 0
+	FlowLimitTypeRedisSlidingWindow = 1
+	FlowLimitTypeRedisGCRA          = 2
+)
+
+// 滑动窗口：用当前窗口与上一窗口按时间加权估算最近1s的请求数，一次最多申请cost个名额
+// KEYS[1]=当前窗口key KEYS[2]=上一窗口key ARGV: limit window_ms now_ms cost  返回 {申请到的数量, 需等待的ms}
+var slidingWindowScript = redis.NewScript(2, `
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+local elapsed = now % window
+local cur = tonumber(redis.call("GET", KEYS[1]) or "0")
+local prev = tonumber(redis.call("GET", KEYS[2]) or "0")
+local used = prev * (window - elapsed) / window + cur
+local avail = math.floor(limit - used)
+if avail <= 0 then
+	return {0, window - elapsed}
+end
+local granted = math.min(avail, cost)
+redis.call("INCRBY", KEYS[1], granted)
+redis.call("PEXPIRE", KEYS[1], window * 2)
+return {granted, 0}
+`)
+
+// GCRA：记录理论到达时间tat，允许的突发量为burst，一次最多申请cost个名额
+// KEYS[1]=key ARGV: qps burst now_ms cost  返回 {申请到的数量, 需等待的ms}
+var gcraScript = redis.NewScript(1, `
+local emission = 1000 / tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+local tolerance = emission * burst
+local tat = tonumber(redis.call("GET", KEYS[1]) or "0")
+if tat < now then
+	tat = now
+end
+local avail = math.floor((now + tolerance - tat) / emission)
+if avail <= 0 then
+	return {0, math.ceil(tat + emission - tolerance - now)}
+end
+local granted = math.min(avail, cost)
+tat = tat + granted * emission
+redis.call("SET", KEYS[1], string.format("%.3f", tat), "PX", math.ceil(tat - now) + 1000)
+return {granted, 0}
+`)
+
+// 日配额：先占用名额再放行，超出配额时归还，保证并发请求不会超发
+// KEYS[1]=key ARGV: quota expire_s  返回 1=占用成功 0=配额已用完
+var dayQuotaScript = redis.NewScript(1, `
+local used = redis.call("INCR", KEYS[1])
+if used == 1 then
+	redis.call("EXPIRE", KEYS[1], tonumber(ARGV[2]))
+end
+if used > tonumber(ARGV[1]) then
+	redis.call("DECR", KEYS[1])
+	return 0
+end
+return 1
+`)
+
+var RedisFlowLimiterHandler *RedisFlowLimiter
+
+// 集群限流，本地缓存一小批从redis申请到的名额，redis不可用时退化为单机令牌桶
+type RedisFlowLimiter struct {
+	itemMap     map[string]*redisFlowLimiterItem
+	locker      sync.Mutex
+	downLocker  sync.RWMutex
+	redisDownTo time.Time
+}
+
+type redisFlowLimiterItem struct {
+	sync.Mutex
+	tokens      int
+	expireAt    time.Time
+	deniedUntil time.Time
+	lastUsed    time.Time
+}
+
+func init() {
+	RedisFlowLimiterHandler = NewRedisFlowLimiter()
+}
+
+func NewRedisFlowLimiter() *RedisFlowLimiter {
+	return &RedisFlowLimiter{
+		itemMap: map[string]*redisFlowLimiterItem{},
+	}
+}
+
+// 按服务配置的限流方式取令牌
+func AllowFlow(name string, qps float64, limitType int) (bool, time.Duration) {
+	if qps <= 0 {
+		return true, 0
+	}
+	if limitType == FlowLimitTypeRedisSlidingWindow || limitType == FlowLimitTypeRedisGCRA {
+		return RedisFlowLimiterHandler.Allow(name, qps, limitType)
+	}
+	return FlowLimiterHandler.Allow(name, qps)
+}
+
+func (l *RedisFlowLimiter) Allow(name string, qps float64, limitType int) (bool, time.Duration) {
+	if l.isRedisDown() {
+		return FlowLimiterHandler.Allow(name, qps)
+	}
+	item := l.getItem(name)
+	item.Lock()
+	now := time.Now()
+	item.lastUsed = now
+	if now.Before(item.deniedUntil) {
+		wait := item.deniedUntil.Sub(now)
+		item.Unlock()
+		return false, wait
+	}
+	if item.tokens > 0 && now.Before(item.expireAt) {
+		item.tokens--
+		item.Unlock()
+		return true, 0
+	}
+	//访问redis时不持有锁，同一限流器的其他请求不必排队等待
+	item.Unlock()
+	cacheTime := time.Duration(lib.GetIntConf("proxy.flow_limit.local_cache_ms")) * time.Millisecond
+	//每次批量申请cacheTime内的配额，缓存越长redis访问越少，但节点间越不均匀
+	batch := int(qps * cacheTime.Seconds())
+	if batch < 1 {
+		batch = 1
+	}
+	granted, wait, err := l.acquire(name, qps, limitType, batch, now)
+	if err != nil {
+		l.markRedisDown(err)
+		return FlowLimiterHandler.Allow(name, qps)
+	}
+	item.Lock()
+	defer item.Unlock()
+	if granted <= 0 {
+		if wait <= 0 {
+			wait = time.Millisecond
+		}
+		item.deniedUntil = now.Add(wait)
+		return false, wait
+	}
+	//并发申请到的名额合并，未过期的名额继续使用
+	if time.Now().Before(item.expireAt) {
+		item.tokens += granted - 1
+	} else {
+		item.tokens = granted - 1
+	}
+	item.expireAt = now.Add(cacheTime)
+	return true, 0
+}
+
+func (l *RedisFlowLimiter) acquire(name string, qps float64, limitType int, batch int, now time.Time) (int, time.Duration, error) {
+	conn, err := lib.RedisConnFactory("default")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+	key := RedisFlowLimitPrefix + name
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+	var reply []int64
+	if limitType == FlowLimitTypeRedisGCRA {
+		reply, err = redis.Int64s(gcraScript.Do(conn, key, qps, flowLimiterBurst(qps), nowMs, batch))
+	} else {
+		//按秒计数，不足1的qps按1计算；窗口key在这里拼好传入，保证脚本只访问KEYS中声明的key
+		window := int64(1000)
+		curStart := nowMs - nowMs%window
+		curKey := fmt.Sprintf("%s:%d", key, curStart)
+		prevKey := fmt.Sprintf("%s:%d", key, curStart-window)
+		reply, err = redis.Int64s(slidingWindowScript.Do(conn, curKey, prevKey, math.Max(1, qps), window, nowMs, batch))
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(reply) != 2 {
+		return 0, 0, errors.New("unexpected flow limit script reply")
+	}
+	return int(reply[0]), time.Duration(reply[1]) * time.Millisecond, nil
+}
+
+func (l *RedisFlowLimiter) getItem(name string) *redisFlowLimiterItem {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	item, ok := l.itemMap[name]
+	if !ok {
+		item = &redisFlowLimiterItem{}
+		l.itemMap[name] = item
+	}
+	return item
+}
+
+func (l *RedisFlowLimiter) isRedisDown() bool {
+	l.downLocker.RLock()
+	defer l.downLocker.RUnlock()
+	return time.Now().Before(l.redisDownTo)
+}
+
+// redis异常后一段时间内直接使用单机限流，避免每个请求都等待连接超时
+func (l *RedisFlowLimiter) markRedisDown(err error) {
+	retry := time.Duration(lib.GetIntConf("proxy.flow_limit.redis_retry_interval")) * time.Second
+	if retry <= 0 {
+		retry = time.Second
+	}
+	l.downLocker.Lock()
+	l.redisDownTo = time.Now().Add(retry)
+	l.downLocker.Unlock()
+	ContextWarning(context.Background(), "_com_flow_limit_redis_failure", map[string]interface{}{
+		"err": err.Error(),
+	})
+}
+
+func (l *RedisFlowLimiter) cleanLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		l.locker.Lock()
+		for name, item := range l.itemMap {
+			item.Lock()
+			idle := time.Since(item.lastUsed) > flowLimiterIdleTimeout
+			item.Unlock()
+			if idle {
+				delete(l.itemMap, name)
+			}
+		}
+		l.locker.Unlock()
+	}
+}
+
+// 在集群内占用name当日的一个配额，日期按base.time_location划分；redis不可用时返回错误，由调用方降级
+func ReserveDayQuota(name string, quota int64, now time.Time) (bool, error) {
+	if RedisFlowLimiterHandler.isRedisDown() {
+		return false, errors.New("redis is down")
+	}
+	conn, err := lib.RedisConnFactory("default")
+	if err != nil {
+		RedisFlowLimiterHandler.markRedisDown(err)
+		return false, err
+	}
+	defer conn.Close()
+	ok, err := redis.Int(dayQuotaScript.Do(conn, dayQuotaKey(name, now), quota, flowCountExpire))
+	if err != nil {
+		RedisFlowLimiterHandler.markRedisDown(err)
+		return false, err
+	}
+	return ok == 1, nil
+}
+
+// name当日已占用的配额，即实际执行限制的用量
+func QueryDayQuota(name string, now time.Time) (int64, error) {
+	return getFlowCount(dayQuotaKey(name, now))
+}
+
+func dayQuotaKey(name string, now time.Time) string {
+	return fmt.Sprintf("%s%s_%s", RedisFlowQuotaPrefix, now.In(FlowCountLocation()).Format("20060102"), name)
+}
@@ -0,0 +1,39 @@
+package public
+
+import "strings"
+
+const (
+	HeaderTransforAdd  = "add"
+	HeaderTransforDel  = "del"
+	HeaderTransforEdit = "edit"
+)
+
+type HeaderTransforItem struct {
+	Op    string
+	Name  string
+	Value string
+}
+
+// 解析header转换规则，每行一条，格式: add/edit name value 或 del name
+func ParseHeaderTransfor(rule string) []*HeaderTransforItem {
+	list := []*HeaderTransforItem{}
+	for _, line := range strings.Split(rule, "\n") {
+		items := strings.Fields(line)
+		if len(items) < 2 {
+			continue
+		}
+		item := &HeaderTransforItem{Op: items[0], Name: items[1]}
+		switch item.Op {
+		case HeaderTransforAdd, HeaderTransforEdit:
+			if len(items) != 3 {
+				continue
+			}
+			item.Value = items[2]
+		case HeaderTransforDel:
+		default:
+			continue
+		}
+		list = append(list, item)
+	}
+	return list
+}
@@ -0,0 +1,62 @@
+package public
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// 签名方式请求头
+const (
+	HmacHeaderAppID     = "X-Gateway-AppId"
+	HmacHeaderTimestamp = "X-Gateway-Timestamp"
+	HmacHeaderNonce     = "X-Gateway-Nonce"
+	HmacHeaderSignature = "X-Gateway-Signature"
+)
+
+// 待签名串，各部分以换行连接:
+// METHOD\nPATH\n排序后的query\nhex(sha256(body))\ntimestamp\nnonce
+func HmacSignString(method string, path string, query url.Values, body []byte, timestamp string, nonce string) string {
+	bodyHash := sha256.Sum256(body)
+	return strings.Join([]string{
+		strings.ToUpper(method),
+		path,
+		HmacSortedQuery(query),
+		hex.EncodeToString(bodyHash[:]),
+		timestamp,
+		nonce,
+	}, "\n")
+}
+
+// query按key排序，同一key的多个值也排序，按url编码拼接
+func HmacSortedQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := []string{}
+	for _, key := range keys {
+		values := append([]string{}, query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, url.QueryEscape(key)+"="+url.QueryEscape(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// 使用租户secret计算hmac-sha256，结果为小写hex
+func HmacSign(secret string, signString string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signString))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func HmacVerify(secret string, signString string, signature string) bool {
+	expected := HmacSign(secret, signString)
+	return hmac.Equal([]byte(expected), []byte(strings.ToLower(signature)))
+}
@@ -0,0 +1,62 @@
+package public
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/e421083458/golang_common/lib"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// 未配置proxy.jwt.expires_in时token的有效期
+const defaultJwtExpiresIn = 3600 * time.Second
+
+func getJwtSignKey() ([]byte, error) {
+	key := lib.GetStringConf("proxy.jwt.sign_key")
+	if key == "" {
+		return nil, errors.New("proxy.jwt.sign_key not configured")
+	}
+	return []byte(key), nil
+}
+
+func GetJwtExpiresIn() time.Duration {
+	if expiresIn := lib.GetIntConf("proxy.jwt.expires_in"); expiresIn > 0 {
+		return time.Duration(expiresIn) * time.Second
+	}
+	return defaultJwtExpiresIn
+}
+
+// 签发token，subject为租户app_id
+func JwtEncode(claims jwt.RegisteredClaims) (string, error) {
+	key, err := getJwtSignKey()
+	if err != nil {
+		return "", err
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+}
+
+// 校验签名、算法、签发方和有效期
+func JwtDecode(token string) (*jwt.RegisteredClaims, error) {
+	key, err := getJwtSignKey()
+	if err != nil {
+		return nil, err
+	}
+	claims := &jwt.RegisteredClaims{}
+	_, err = jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !claims.VerifyIssuer(JwtIssuer, true) {
+		return nil, errors.New("invalid token issuer")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("token subject is empty")
+	}
+	return claims, nil
+}
@@ -0,0 +1,52 @@
+package public
+
+import (
+	"github.com/e421083458/golang_common/lib"
+	"github.com/garyburd/redigo/redis"
+)
+
+// 批量执行redis命令，返回写入或第一条命令执行的错误
+func RedisConfPipline(pip ...func(c redis.Conn)) error {
+	conn, err := lib.RedisConnFactory("default")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	c := &pipelineConn{Conn: conn}
+	for _, f := range pip {
+		f(c)
+	}
+	if err := c.Flush(); err != nil {
+		return err
+	}
+	var firstErr error
+	for i := 0; i < c.pending; i++ {
+		if _, err := c.Receive(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// 记录已发送的命令数，用于读取全部返回
+type pipelineConn struct {
+	redis.Conn
+	pending int
+}
+
+func (c *pipelineConn) Send(commandName string, args ...interface{}) error {
+	if err := c.Conn.Send(commandName, args...); err != nil {
+		return err
+	}
+	c.pending++
+	return nil
+}
+
+func RedisConfDo(commandName string, args ...interface{}) (interface{}, error) {
+	c, err := lib.RedisConnFactory("default")
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.Do(commandName, args...)
+}
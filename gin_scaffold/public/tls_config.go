@@ -0,0 +1,42 @@
+package public
+
+import (
+	"crypto/x509"
+	"errors"
+	"os"
+	"strings"
+)
+
+// 证书类配置既可以直接填pem内容，也可以填文件路径
+func LoadPemOrFile(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "-----BEGIN") {
+		return []byte(value), nil
+	}
+	return os.ReadFile(value)
+}
+
+func NewCertPool(pemData []byte) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemData) {
+		return nil, errors.New("no valid certificate in ca")
+	}
+	return pool, nil
+}
+
+// 用指定CA校验对端出示的证书链，chain[0]为叶子证书
+func VerifyCertChain(chain []*x509.Certificate, roots *x509.CertPool, usage x509.ExtKeyUsage) error {
+	if len(chain) == 0 {
+		return errors.New("no certificate presented")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	})
+	return err
+}
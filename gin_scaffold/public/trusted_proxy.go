@@ -0,0 +1,73 @@
+package public
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/e421083458/golang_common/lib"
+)
+
+var (
+	clientIPResolverOnce sync.Once
+	clientIPResolver     *ClientIPResolver
+)
+
+// 可信代理列表，只有来自这些地址的请求才会采信X-Forwarded-For等请求头，为空时直接使用连接地址
+func GetTrustedProxies() []string {
+	return lib.GetStringSliceConf("proxy.trusted_proxy.list")
+}
+
+// 按顺序查找客户端ip的请求头
+func GetRemoteIPHeaders() []string {
+	if headers := lib.GetStringSliceConf("proxy.trusted_proxy.remote_ip_headers"); len(headers) > 0 {
+		return headers
+	}
+	return []string{"X-Forwarded-For", "X-Real-IP"}
+}
+
+// 非gin场景(grpc)解析客户端ip，规则与gin的ClientIP一致
+type ClientIPResolver struct {
+	trusted *IPMatcher
+	headers []string
+}
+
+func NewClientIPResolver(trustedProxies []string, headers []string) *ClientIPResolver {
+	return &ClientIPResolver{
+		trusted: NewIPMatcher(strings.Join(trustedProxies, ",")),
+		headers: headers,
+	}
+}
+
+// 连接来自可信代理时，从右向左跳过可信代理，第一个不可信的地址即客户端
+// getHeader用于按名称读取请求头或grpc metadata
+func (r *ClientIPResolver) Resolve(remoteIP string, getHeader func(name string) string) string {
+	if r.trusted.Len() == 0 || !r.trusted.Match(remoteIP) {
+		return remoteIP
+	}
+	for _, header := range r.headers {
+		value := getHeader(header)
+		if value == "" {
+			continue
+		}
+		items := strings.Split(value, ",")
+		for i := len(items) - 1; i >= 0; i-- {
+			ip := strings.TrimSpace(items[i])
+			if net.ParseIP(ip) == nil {
+				break
+			}
+			if i == 0 || !r.trusted.Match(ip) {
+				return ip
+			}
+		}
+	}
+	return remoteIP
+}
+
+// 使用proxy.trusted_proxy配置解析
+func ResolveClientIP(remoteIP string, getHeader func(name string) string) string {
+	clientIPResolverOnce.Do(func() {
+		clientIPResolver = NewClientIPResolver(GetTrustedProxies(), GetRemoteIPHeaders())
+	})
+	return clientIPResolver.Resolve(remoteIP, getHeader)
+}
@@ -0,0 +1,66 @@
+package reverse_proxy
+
+import (
+	"errors"
+	"gin_scaffold/dao"
+	"gin_scaffold/middleware"
+	"gin_scaffold/reverse_proxy/load_balance"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ErrNodeCircuitOpen = errors.New("circuit breaker of all nodes open")
+
+var errNoUntriedNode = errors.New("no untried node")
+
+// 从负载均衡器选取节点，跳过熔断中和本次请求已尝试过的节点；
+// 一致性hash总是选中同一节点，该节点熔断时直接降级，只有重试时才在key后追加序号重新hash换节点
+func pickNode(key string, service *dao.ServiceDetial, lb load_balance.LoadBalance, breakers *dao.CircuitBreakerItem, tried map[string]bool) (string, *load_balance.CircuitBreaker, uint64, error) {
+	tries := len(service.LoadBalance.GetIPlistByModel()) * 2
+	keepAffinity := len(tried) == 0 && load_balance.LbType(service.LoadBalance.RoundType) == load_balance.LbConsistentHash
+	circuitOpen := false
+	for i := 0; i < tries; i++ {
+		hashKey := key
+		if i > 0 || len(tried) > 0 {
+			hashKey = key + "#" + strconv.Itoa(len(tried)+i)
+		}
+		addr, err := lb.Get(hashKey)
+		if err != nil {
+			return "", nil, 0, err
+		}
+		if tried[addr] {
+			continue
+		}
+		if breakers == nil {
+			return addr, nil, 0, nil
+		}
+		breaker := breakers.Node(addr)
+		if generation, ok := breaker.Allow(); ok {
+			return addr, breaker, generation, nil
+		}
+		circuitOpen = true
+		if keepAffinity {
+			break
+		}
+	}
+	if circuitOpen {
+		return "", nil, 0, ErrNodeCircuitOpen
+	}
+	return "", nil, 0, errNoUntriedNode
+}
+
+// 熔断时按规则返回降级内容，未配置内容时返回网关错误信息
+func CircuitBreakerFallback(c *gin.Context, rule *dao.CircuitBreakerRule, code middleware.ResponseCode, err error) {
+	if rule.FallbackBody == "" {
+		middleware.ResponseErrorWithStatus(c, rule.GetFallbackStatus(), code, err)
+		return
+	}
+	contentType := rule.FallbackContentType
+	if contentType == "" {
+		contentType = "application/json; charset=utf-8"
+	}
+	c.Set("response", rule.FallbackBody)
+	c.Data(rule.GetFallbackStatus(), contentType, []byte(rule.FallbackBody))
+	c.Abort()
+}
@@ -0,0 +1,235 @@
+package reverse_proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"gin_scaffold/dao"
+	"gin_scaffold/reverse_proxy/load_balance"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/e421083458/golang_common/lib"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// 透传用的原始帧，不做protobuf编解码
+type grpcFrame struct {
+	payload []byte
+}
+
+type grpcRawCodec struct{}
+
+func (grpcRawCodec) Marshal(v interface{}) ([]byte, error) {
+	return v.(*grpcFrame).payload, nil
+}
+
+func (grpcRawCodec) Unmarshal(data []byte, v interface{}) error {
+	v.(*grpcFrame).payload = data
+	return nil
+}
+
+func (grpcRawCodec) Name() string {
+	return "proxy"
+}
+
+// 代理服务端与下游连接共用的编解码器
+var GrpcCodec = grpcRawCodec{}
+
+var grpcStreamDesc = &grpc.StreamDesc{
+	ServerStreams: true,
+	ClientStreams: true,
+}
+
+// 下游节点连接复用，grpc连接基于http2可承载多个stream，按节点和tls配置区分
+type grpcConnKey struct {
+	addr      string
+	tlsConfig *tls.Config
+}
+
+var (
+	grpcConnMap    = map[grpcConnKey]*grpc.ClientConn{}
+	grpcConnLocker sync.Mutex
+)
+
+func init() {
+	dao.ServiceManagerHandler.Register(OnGrpcServiceChange)
+}
+
+// 服务变更后释放不再被任何grpc服务使用的下游连接，等待drain_timeout后关闭，进行中的调用可以正常结束
+func OnGrpcServiceChange(change *dao.ServiceChange) {
+	inUse := map[grpcConnKey]bool{}
+	for _, service := range dao.ServiceManagerHandler.GetGrpcServiceList() {
+		if service.LoadBalance == nil {
+			continue
+		}
+		tlsConfig, err := service.LoadBalance.GetUpstreamTLSConfig()
+		if err != nil {
+			continue
+		}
+		for _, addr := range service.LoadBalance.GetIPlistByModel() {
+			inUse[grpcConnKey{addr: addr, tlsConfig: tlsConfig}] = true
+		}
+	}
+	timeout := time.Duration(lib.GetIntConf("proxy.transport.drain_timeout")) * time.Second
+	if timeout <= 0 {
+		timeout = defaultDrainTimeout
+	}
+	grpcConnLocker.Lock()
+	defer grpcConnLocker.Unlock()
+	for key, conn := range grpcConnMap {
+		if inUse[key] {
+			continue
+		}
+		delete(grpcConnMap, key)
+		time.AfterFunc(timeout, func() {
+			conn.Close()
+		})
+	}
+}
+
+// 当前缓存的下游连接数
+func GrpcConnCount() int {
+	grpcConnLocker.Lock()
+	defer grpcConnLocker.Unlock()
+	return len(grpcConnMap)
+}
+
+func getGrpcConn(addr string, tlsConfig *tls.Config) (*grpc.ClientConn, error) {
+	grpcConnLocker.Lock()
+	defer grpcConnLocker.Unlock()
+	key := grpcConnKey{addr: addr, tlsConfig: tlsConfig}
+	if conn, ok := grpcConnMap[key]; ok {
+		return conn, nil
+	}
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	conn, err := grpc.Dial(addr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(GrpcCodec)))
+	if err != nil {
+		return nil, err
+	}
+	grpcConnMap[key] = conn
+	return conn, nil
+}
+
+// 根据匹配到的服务构建grpc透明代理，支持一元与各类流式调用
+func NewGrpcLoadBalanceHandler(service *dao.ServiceDetial, lb load_balance.LoadBalance) grpc.StreamHandler {
+	return func(srv interface{}, serverStream grpc.ServerStream) error {
+		fullMethodName, ok := grpc.MethodFromServerStream(serverStream)
+		if !ok {
+			return status.Errorf(codes.Internal, "lowLevelServerStream not exists in context")
+		}
+		ctx := serverStream.Context()
+		clientIP := ""
+		if p, ok := peer.FromContext(ctx); ok {
+			clientIP, _, _ = net.SplitHostPort(p.Addr.String())
+		}
+		nextAddr, err := lb.Get(clientIP)
+		if err != nil {
+			return status.Errorf(codes.Unavailable, "no available upstream: %v", err)
+		}
+		tlsConfig, err := service.LoadBalance.GetUpstreamTLSConfig()
+		if err != nil {
+			return status.Errorf(codes.Unavailable, "upstream tls config: %v", err)
+		}
+		conn, err := getGrpcConn(nextAddr, tlsConfig)
+		if err != nil {
+			return status.Errorf(codes.Unavailable, "dial upstream %s: %v", nextAddr, err)
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		outCtx, cancel := context.WithCancel(metadata.NewOutgoingContext(ctx, md.Copy()))
+		defer cancel()
+		clientStream, err := conn.NewStream(outCtx, grpcStreamDesc, fullMethodName)
+		dao.LoadBalancerHandler.ReportResult(service, nextAddr, status.Code(err) == codes.Unavailable)
+		if err != nil {
+			return err
+		}
+
+		s2cErrChan := forwardServerToClient(serverStream, clientStream)
+		c2sErrChan := forwardClientToServer(clientStream, serverStream)
+		for i := 0; i < 2; i++ {
+			select {
+			case s2cErr := <-s2cErrChan:
+				if s2cErr != io.EOF {
+					//客户端异常断开，取消下游调用
+					cancel()
+					return status.Errorf(codes.Internal, "failed proxying s2c: %v", s2cErr)
+				}
+				//客户端发送完毕，下游可能还有响应
+				clientStream.CloseSend()
+			case c2sErr := <-c2sErrChan:
+				serverStream.SetTrailer(clientStream.Trailer())
+				if c2sErr != io.EOF {
+					return c2sErr
+				}
+				return nil
+			}
+		}
+		return status.Errorf(codes.Internal, "gRPC proxying should never reach this stage.")
+	}
+}
+
+func forwardClientToServer(src grpc.ClientStream, dst grpc.ServerStream) chan error {
+	ret := make(chan error, 1)
+	go func() {
+		f := &grpcFrame{}
+		for i := 0; ; i++ {
+			if err := src.RecvMsg(f); err != nil {
+				//下游没有返回任何消息时也要透传header
+				if i == 0 {
+					if md, mdErr := src.Header(); mdErr == nil && len(md) > 0 {
+						dst.SendHeader(md)
+					}
+				}
+				ret <- err
+				break
+			}
+			//收到第一帧后才能拿到下游的header
+			if i == 0 {
+				md, err := src.Header()
+				if err != nil {
+					ret <- err
+					break
+				}
+				if err := dst.SendHeader(md); err != nil {
+					ret <- err
+					break
+				}
+			}
+			if err := dst.SendMsg(f); err != nil {
+				ret <- err
+				break
+			}
+		}
+	}()
+	return ret
+}
+
+func forwardServerToClient(src grpc.ServerStream, dst grpc.ClientStream) chan error {
+	ret := make(chan error, 1)
+	go func() {
+		f := &grpcFrame{}
+		for {
+			if err := src.RecvMsg(f); err != nil {
+				ret <- err
+				break
+			}
+			if err := dst.SendMsg(f); err != nil {
+				ret <- err
+				break
+			}
+		}
+	}()
+	return ret
+}
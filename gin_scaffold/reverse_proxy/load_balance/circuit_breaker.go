@@ -0,0 +1,203 @@
+package load_balance
+
+import (
+	"sync"
+	"time"
+)
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+var breakerStateNames = map[BreakerState]string{
+	BreakerClosed:   "closed",
+	BreakerOpen:     "open",
+	BreakerHalfOpen: "half_open",
+}
+
+func (s BreakerState) String() string {
+	return breakerStateNames[s]
+}
+
+const (
+	DefaultBreakerWindow      = 10 * time.Second
+	DefaultBreakerMinRequests = 20
+	DefaultBreakerOpenTime    = 30 * time.Second
+	DefaultBreakerHalfOpenMax = 5
+	breakerBuckets            = 10
+)
+
+type CircuitBreakerConf struct {
+	Window           time.Duration //统计错误率和慢调用率的滑动窗口
+	MinRequests      int           //窗口内请求数达到该值才判断是否熔断
+	ErrorRate        int           //错误率阈值, 百分比, 0=不按错误率熔断
+	SlowCallRate     int           //慢调用率阈值, 百分比, 0=不按慢调用率熔断
+	SlowCallDuration time.Duration //耗时超过该值计为慢调用
+	OpenTime         time.Duration //熔断持续时间, 之后进入半开状态
+	HalfOpenMax      int           //半开状态放行的探测请求数, 全部成功后恢复
+}
+
+type breakerBucket struct {
+	start    time.Time
+	total    int
+	failures int
+	slow     int
+}
+
+// 熔断器，关闭状态统计滑动窗口内的请求结果，超过阈值后打开；
+// 打开状态拒绝请求，OpenTime后进入半开状态放行少量探测请求，探测全部成功则关闭，任一失败重新打开
+type CircuitBreaker struct {
+	conf       CircuitBreakerConf
+	locker     sync.Mutex
+	state      BreakerState
+	generation uint64
+	buckets    [breakerBuckets]breakerBucket
+	openUntil  time.Time
+	inflight   int
+	successes  int
+	onChange   func(from, to BreakerState)
+}
+
+func NewCircuitBreaker(conf CircuitBreakerConf, onChange func(from, to BreakerState)) *CircuitBreaker {
+	if conf.Window <= 0 {
+		conf.Window = DefaultBreakerWindow
+	}
+	if conf.MinRequests <= 0 {
+		conf.MinRequests = DefaultBreakerMinRequests
+	}
+	if conf.OpenTime <= 0 {
+		conf.OpenTime = DefaultBreakerOpenTime
+	}
+	if conf.HalfOpenMax <= 0 {
+		conf.HalfOpenMax = DefaultBreakerHalfOpenMax
+	}
+	return &CircuitBreaker{conf: conf, onChange: onChange}
+}
+
+func (b *CircuitBreaker) State() BreakerState {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	if b.state == BreakerOpen && !time.Now().Before(b.openUntil) {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// 判断是否放行，放行后必须调用Report或Release，generation用于忽略状态切换前发出的请求结果
+func (b *CircuitBreaker) Allow() (uint64, bool) {
+	b.locker.Lock()
+	from := b.state
+	if b.state == BreakerOpen && !time.Now().Before(b.openUntil) {
+		b.setState(BreakerHalfOpen)
+	}
+	allowed := true
+	switch b.state {
+	case BreakerOpen:
+		allowed = false
+	case BreakerHalfOpen:
+		if b.inflight >= b.conf.HalfOpenMax {
+			allowed = false
+		} else {
+			b.inflight++
+		}
+	}
+	generation, to := b.generation, b.state
+	b.locker.Unlock()
+	b.notify(from, to)
+	return generation, allowed
+}
+
+// 上报放行请求的结果，失败指连接错误或5xx
+func (b *CircuitBreaker) Report(generation uint64, failed bool, latency time.Duration) {
+	slow := b.conf.SlowCallDuration > 0 && latency >= b.conf.SlowCallDuration
+	b.locker.Lock()
+	from := b.state
+	if generation == b.generation {
+		switch b.state {
+		case BreakerClosed:
+			b.record(failed, slow)
+			if b.shouldTrip() {
+				b.trip()
+			}
+		case BreakerHalfOpen:
+			b.inflight--
+			if failed || slow {
+				b.trip()
+			} else if b.successes++; b.successes >= b.conf.HalfOpenMax {
+				b.setState(BreakerClosed)
+			}
+		}
+	}
+	to := b.state
+	b.locker.Unlock()
+	b.notify(from, to)
+}
+
+// 放行的请求没有结果(如客户端主动断开)时释放半开状态的探测名额
+func (b *CircuitBreaker) Release(generation uint64) {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	if generation == b.generation && b.state == BreakerHalfOpen {
+		b.inflight--
+	}
+}
+
+func (b *CircuitBreaker) record(failed bool, slow bool) {
+	now := time.Now()
+	bucketSize := b.conf.Window / breakerBuckets
+	start := now.Truncate(bucketSize)
+	bucket := &b.buckets[(start.UnixNano()/int64(bucketSize))%breakerBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = breakerBucket{start: start}
+	}
+	bucket.total++
+	if failed {
+		bucket.failures++
+	}
+	if slow {
+		bucket.slow++
+	}
+}
+
+func (b *CircuitBreaker) shouldTrip() bool {
+	total, failures, slow := 0, 0, 0
+	windowStart := time.Now().Add(-b.conf.Window)
+	for _, bucket := range b.buckets {
+		if bucket.start.After(windowStart) {
+			total += bucket.total
+			failures += bucket.failures
+			slow += bucket.slow
+		}
+	}
+	if total < b.conf.MinRequests {
+		return false
+	}
+	if b.conf.ErrorRate > 0 && failures*100 >= total*b.conf.ErrorRate {
+		return true
+	}
+	return b.conf.SlowCallRate > 0 && slow*100 >= total*b.conf.SlowCallRate
+}
+
+func (b *CircuitBreaker) trip() {
+	b.setState(BreakerOpen)
+	b.openUntil = time.Now().Add(b.conf.OpenTime)
+}
+
+// 每次切换状态重置统计，调用方需持有locker
+func (b *CircuitBreaker) setState(state BreakerState) {
+	b.state = state
+	b.generation++
+	b.buckets = [breakerBuckets]breakerBucket{}
+	b.inflight = 0
+	b.successes = 0
+}
+
+func (b *CircuitBreaker) notify(from, to BreakerState) {
+	if from != to && b.onChange != nil {
+		b.onChange(from, to)
+	}
+}
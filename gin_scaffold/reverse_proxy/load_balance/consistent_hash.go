@@ -0,0 +1,76 @@
+package load_balance
+
+import (
+	"errors"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+type Hash func(data []byte) uint32
+
+type UInt32Slice []uint32
+
+func (s UInt32Slice) Len() int           { return len(s) }
+func (s UInt32Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s UInt32Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// 一致性hash，key可以是客户端ip、header或cookie的值
+type ConsistentHashBalance struct {
+	locker   sync.RWMutex
+	hash     Hash
+	replicas int               //每个节点的虚拟节点数
+	keys     UInt32Slice       //已排序的虚拟节点hash
+	hashMap  map[uint32]string //虚拟节点hash到真实节点的映射
+}
+
+func NewConsistentHashBalance(replicas int, fn Hash) *ConsistentHashBalance {
+	m := &ConsistentHashBalance{
+		replicas: replicas,
+		hash:     fn,
+		hashMap:  make(map[uint32]string),
+	}
+	if m.hash == nil {
+		//最多32位，保证是一个2^32-1环
+		m.hash = crc32.ChecksumIEEE
+	}
+	return m
+}
+
+func (c *ConsistentHashBalance) IsEmpty() bool {
+	c.locker.RLock()
+	defer c.locker.RUnlock()
+	return len(c.keys) == 0
+}
+
+func (c *ConsistentHashBalance) Add(params ...string) error {
+	if len(params) == 0 {
+		return errors.New("param len 1 at least")
+	}
+	addr := params[0]
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	for i := 0; i < c.replicas; i++ {
+		hash := c.hash([]byte(strconv.Itoa(i) + addr))
+		c.keys = append(c.keys, hash)
+		c.hashMap[hash] = addr
+	}
+	sort.Sort(c.keys)
+	return nil
+}
+
+// 顺时针找到第一个大于等于key hash的虚拟节点
+func (c *ConsistentHashBalance) Get(key string) (string, error) {
+	if c.IsEmpty() {
+		return "", ErrNoAvailableNode
+	}
+	hash := c.hash([]byte(key))
+	c.locker.RLock()
+	defer c.locker.RUnlock()
+	idx := sort.Search(len(c.keys), func(i int) bool { return c.keys[i] >= hash })
+	if idx == len(c.keys) {
+		idx = 0
+	}
+	return c.hashMap[c.keys[idx]], nil
+}
@@ -0,0 +1,198 @@
+package load_balance
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 与dao.LoadBalance.CheckMethod取值一致
+const (
+	CheckMethodTcp  = 0
+	CheckMethodHttp = 1
+)
+
+const (
+	DefaultCheckTimeout  = 2 * time.Second
+	DefaultRise          = 2
+	DefaultFall          = 3
+	DefaultExpectStatus  = http.StatusOK
+	DefaultCheckHttpPath = "/"
+)
+
+type HealthCheckConf struct {
+	Method       int
+	Interval     time.Duration
+	Timeout      time.Duration
+	Path         string      //http检查的路径
+	TLSConfig    *tls.Config //不为空时http检查使用https
+	ExpectStatus int         //http检查期望的状态码
+	Rise         int         //连续成功多少次标记为up
+	Fall         int         //连续失败多少次标记为down
+}
+
+type NodeStatus struct {
+	Addr          string    `json:"addr"`
+	Healthy       bool      `json:"healthy"`
+	LastError     string    `json:"last_error"`
+	LastCheckTime time.Time `json:"last_check_time"`
+	successCount  int
+	failCount     int
+}
+
+// 主动健康检查，定时探测每个节点，每轮检查后回调onCheck，changed表示有节点up/down
+type HealthChecker struct {
+	conf    HealthCheckConf
+	nodes   []*NodeStatus
+	locker  sync.RWMutex
+	onCheck func(h *HealthChecker, changed bool)
+	closeCh chan struct{}
+	once    sync.Once
+}
+
+func NewHealthChecker(addrs []string, conf HealthCheckConf, onCheck func(h *HealthChecker, changed bool)) *HealthChecker {
+	if conf.Timeout <= 0 {
+		conf.Timeout = DefaultCheckTimeout
+	}
+	if conf.Rise <= 0 {
+		conf.Rise = DefaultRise
+	}
+	if conf.Fall <= 0 {
+		conf.Fall = DefaultFall
+	}
+	if conf.Path == "" {
+		conf.Path = DefaultCheckHttpPath
+	}
+	if conf.ExpectStatus == 0 {
+		conf.ExpectStatus = DefaultExpectStatus
+	}
+	h := &HealthChecker{
+		conf:    conf,
+		onCheck: onCheck,
+		closeCh: make(chan struct{}),
+	}
+	//启动前默认节点可用，避免第一轮检查前无节点可选
+	for _, addr := range addrs {
+		h.nodes = append(h.nodes, &NodeStatus{Addr: addr, Healthy: true})
+	}
+	return h
+}
+
+func (h *HealthChecker) Start() {
+	if h.conf.Interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(h.conf.Interval)
+		defer ticker.Stop()
+		h.checkAll()
+		for {
+			select {
+			case <-h.closeCh:
+				return
+			case <-ticker.C:
+				h.checkAll()
+			}
+		}
+	}()
+}
+
+func (h *HealthChecker) Stop() {
+	h.once.Do(func() {
+		close(h.closeCh)
+	})
+}
+
+func (h *HealthChecker) HealthyNodes() []string {
+	h.locker.RLock()
+	defer h.locker.RUnlock()
+	list := []string{}
+	for _, node := range h.nodes {
+		if node.Healthy {
+			list = append(list, node.Addr)
+		}
+	}
+	return list
+}
+
+func (h *HealthChecker) NodeStatusList() []NodeStatus {
+	h.locker.RLock()
+	defer h.locker.RUnlock()
+	list := []NodeStatus{}
+	for _, node := range h.nodes {
+		list = append(list, *node)
+	}
+	return list
+}
+
+func (h *HealthChecker) checkAll() {
+	errs := make([]error, len(h.nodes))
+	wg := sync.WaitGroup{}
+	for index, node := range h.nodes {
+		wg.Add(1)
+		go func(index int, addr string) {
+			defer wg.Done()
+			errs[index] = h.check(addr)
+		}(index, node.Addr)
+	}
+	wg.Wait()
+
+	changed := false
+	now := time.Now()
+	h.locker.Lock()
+	for index, node := range h.nodes {
+		node.LastCheckTime = now
+		if errs[index] != nil {
+			node.LastError = errs[index].Error()
+			node.successCount = 0
+			node.failCount++
+			if node.Healthy && node.failCount >= h.conf.Fall {
+				node.Healthy = false
+				changed = true
+			}
+			continue
+		}
+		node.LastError = ""
+		node.failCount = 0
+		node.successCount++
+		if !node.Healthy && node.successCount >= h.conf.Rise {
+			node.Healthy = true
+			changed = true
+		}
+	}
+	h.locker.Unlock()
+	if h.onCheck != nil {
+		h.onCheck(h, changed)
+	}
+}
+
+func (h *HealthChecker) check(addr string) error {
+	switch h.conf.Method {
+	case CheckMethodHttp:
+		client := &http.Client{Timeout: h.conf.Timeout}
+		scheme := "http"
+		if h.conf.TLSConfig != nil {
+			scheme = "https"
+			client.Transport = &http.Transport{TLSClientConfig: h.conf.TLSConfig, DisableKeepAlives: true}
+		}
+		resp, err := client.Get(fmt.Sprintf("%s://%s%s", scheme, addr, h.conf.Path))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != h.conf.ExpectStatus {
+			return fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		return nil
+	default:
+		conn, err := net.DialTimeout("tcp", addr, h.conf.Timeout)
+		if err != nil {
+			return err
+		}
+		conn.Close()
+		return nil
+	}
+}
@@ -0,0 +1,38 @@
+package load_balance
+
+import (
+	"errors"
+)
+
+type LbType int
+
+// 与dao.LoadBalance.RoundType取值一致
+const (
+	LbRoundRobin LbType = iota
+	LbWeightRoundRobin
+	LbRandom
+	LbConsistentHash
+)
+
+var ErrNoAvailableNode = errors.New("no available node")
+
+// 负载均衡器，Add的参数为 节点地址[,权重]，Get的参数为一致性hash使用的key
+type LoadBalance interface {
+	Add(...string) error
+	Get(string) (string, error)
+}
+
+func LoadBalanceFactory(lbType LbType) LoadBalance {
+	switch lbType {
+	case LbRoundRobin:
+		return &RoundRobinBalance{}
+	case LbWeightRoundRobin:
+		return &WeightRoundRobinBalance{}
+	case LbRandom:
+		return &RandomBalance{}
+	case LbConsistentHash:
+		return NewConsistentHashBalance(10, nil)
+	default:
+		return &RoundRobinBalance{}
+	}
+}
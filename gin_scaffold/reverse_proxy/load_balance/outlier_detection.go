@@ -0,0 +1,155 @@
+package load_balance
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	DefaultConsecutiveErrors  = 5
+	DefaultBaseEjectionTime   = 30 * time.Second
+	DefaultMaxEjectionTime    = 300 * time.Second
+	DefaultMaxEjectionPercent = 50
+)
+
+type OutlierConf struct {
+	ConsecutiveErrors  int           //连续失败多少次后摘除
+	BaseEjectionTime   time.Duration //首次摘除时长，之后每次翻倍
+	MaxEjectionTime    time.Duration //摘除时长上限
+	MaxEjectionPercent int           //同时摘除节点的最大百分比
+}
+
+type outlierNode struct {
+	consecutiveErrors int
+	ejectCount        int
+	ejected           bool
+	lastEjectEnd      time.Time
+	timer             *time.Timer
+}
+
+// 被动健康检查，根据真实请求的结果摘除连续失败的节点
+type OutlierDetector struct {
+	conf     OutlierConf
+	nodes    map[string]*outlierNode
+	locker   sync.Mutex
+	onChange func()
+	stopped  bool
+}
+
+func NewOutlierDetector(addrs []string, conf OutlierConf, onChange func()) *OutlierDetector {
+	if conf.ConsecutiveErrors <= 0 {
+		conf.ConsecutiveErrors = DefaultConsecutiveErrors
+	}
+	if conf.BaseEjectionTime <= 0 {
+		conf.BaseEjectionTime = DefaultBaseEjectionTime
+	}
+	if conf.MaxEjectionTime <= 0 {
+		conf.MaxEjectionTime = DefaultMaxEjectionTime
+	}
+	if conf.MaxEjectionPercent <= 0 {
+		conf.MaxEjectionPercent = DefaultMaxEjectionPercent
+	}
+	o := &OutlierDetector{
+		conf:     conf,
+		nodes:    map[string]*outlierNode{},
+		onChange: onChange,
+	}
+	for _, addr := range addrs {
+		o.nodes[addr] = &outlierNode{}
+	}
+	return o
+}
+
+// 上报一次请求结果，failed表示连接失败或下游返回5xx
+func (o *OutlierDetector) Report(addr string, failed bool) {
+	o.locker.Lock()
+	node, ok := o.nodes[addr]
+	if !ok || o.stopped {
+		o.locker.Unlock()
+		return
+	}
+	if !failed {
+		node.consecutiveErrors = 0
+		o.locker.Unlock()
+		return
+	}
+	node.consecutiveErrors++
+	if node.ejected || node.consecutiveErrors < o.conf.ConsecutiveErrors || !o.canEject() {
+		o.locker.Unlock()
+		return
+	}
+	//长时间未被摘除的节点重新计算摘除时长
+	if !node.lastEjectEnd.IsZero() && time.Since(node.lastEjectEnd) > o.conf.MaxEjectionTime {
+		node.ejectCount = 0
+	}
+	node.ejectCount++
+	node.ejected = true
+	node.consecutiveErrors = 0
+	ejectionTime := o.conf.BaseEjectionTime
+	for i := 1; i < node.ejectCount && ejectionTime < o.conf.MaxEjectionTime; i++ {
+		ejectionTime *= 2
+	}
+	if ejectionTime > o.conf.MaxEjectionTime {
+		ejectionTime = o.conf.MaxEjectionTime
+	}
+	node.timer = time.AfterFunc(ejectionTime, func() {
+		o.release(addr)
+	})
+	o.locker.Unlock()
+	o.notify()
+}
+
+func (o *OutlierDetector) IsEjected(addr string) bool {
+	o.locker.Lock()
+	defer o.locker.Unlock()
+	if node, ok := o.nodes[addr]; ok {
+		return node.ejected
+	}
+	return false
+}
+
+func (o *OutlierDetector) Stop() {
+	o.locker.Lock()
+	defer o.locker.Unlock()
+	o.stopped = true
+	for _, node := range o.nodes {
+		if node.timer != nil {
+			node.timer.Stop()
+		}
+	}
+}
+
+// 已摘除数量达到上限时不再摘除，保证服务不会被全部摘除
+func (o *OutlierDetector) canEject() bool {
+	ejected := 0
+	for _, node := range o.nodes {
+		if node.ejected {
+			ejected++
+		}
+	}
+	maxEjected := len(o.nodes) * o.conf.MaxEjectionPercent / 100
+	if maxEjected >= len(o.nodes) {
+		maxEjected = len(o.nodes) - 1
+	}
+	return ejected < maxEjected
+}
+
+func (o *OutlierDetector) release(addr string) {
+	o.locker.Lock()
+	node, ok := o.nodes[addr]
+	if !ok || o.stopped || !node.ejected {
+		o.locker.Unlock()
+		return
+	}
+	node.ejected = false
+	node.lastEjectEnd = time.Now()
+	node.timer = nil
+	o.locker.Unlock()
+	o.notify()
+}
+
+func (o *OutlierDetector) notify() {
+	if o.onChange != nil {
+		o.onChange()
+	}
+}
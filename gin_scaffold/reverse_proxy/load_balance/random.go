@@ -0,0 +1,40 @@
+package load_balance
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+)
+
+// 随机
+type RandomBalance struct {
+	rss    []string
+	locker sync.RWMutex
+}
+
+func (r *RandomBalance) Add(params ...string) error {
+	if len(params) == 0 {
+		return errors.New("param len 1 at least")
+	}
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.rss = append(r.rss, params[0])
+	return nil
+}
+
+func (r *RandomBalance) Next() string {
+	r.locker.RLock()
+	defer r.locker.RUnlock()
+	if len(r.rss) == 0 {
+		return ""
+	}
+	return r.rss[rand.Intn(len(r.rss))]
+}
+
+func (r *RandomBalance) Get(key string) (string, error) {
+	addr := r.Next()
+	if addr == "" {
+		return "", ErrNoAvailableNode
+	}
+	return addr, nil
+}
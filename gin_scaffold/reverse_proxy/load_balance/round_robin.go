@@ -0,0 +1,45 @@
+package load_balance
+
+import (
+	"errors"
+	"sync"
+)
+
+// 轮询
+type RoundRobinBalance struct {
+	curIndex int
+	rss      []string
+	locker   sync.Mutex
+}
+
+func (r *RoundRobinBalance) Add(params ...string) error {
+	if len(params) == 0 {
+		return errors.New("param len 1 at least")
+	}
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.rss = append(r.rss, params[0])
+	return nil
+}
+
+func (r *RoundRobinBalance) Next() string {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if len(r.rss) == 0 {
+		return ""
+	}
+	if r.curIndex >= len(r.rss) {
+		r.curIndex = 0
+	}
+	curAddr := r.rss[r.curIndex]
+	r.curIndex = (r.curIndex + 1) % len(r.rss)
+	return curAddr
+}
+
+func (r *RoundRobinBalance) Get(key string) (string, error) {
+	addr := r.Next()
+	if addr == "" {
+		return "", ErrNoAvailableNode
+	}
+	return addr, nil
+}
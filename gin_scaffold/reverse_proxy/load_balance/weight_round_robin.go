@@ -0,0 +1,69 @@
+package load_balance
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+)
+
+// 平滑加权轮询，与nginx的实现一致
+type WeightRoundRobinBalance struct {
+	rss    []*WeightNode
+	locker sync.Mutex
+}
+
+type WeightNode struct {
+	addr            string
+	weight          int //配置的权重
+	currentWeight   int //节点当前权重
+	effectiveWeight int //有效权重
+}
+
+func (r *WeightRoundRobinBalance) Add(params ...string) error {
+	if len(params) != 2 {
+		return errors.New("param len need 2")
+	}
+	weight, err := strconv.ParseInt(params[1], 10, 64)
+	if err != nil {
+		return err
+	}
+	if weight < 0 {
+		return errors.New("weight must not be negative")
+	}
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	node := &WeightNode{addr: params[0], weight: int(weight), effectiveWeight: int(weight)}
+	r.rss = append(r.rss, node)
+	return nil
+}
+
+func (r *WeightRoundRobinBalance) Next() string {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	total := 0
+	var best *WeightNode
+	for _, w := range r.rss {
+		//1、统计所有有效权重之和
+		total += w.effectiveWeight
+		//2、节点临时权重加上有效权重
+		w.currentWeight += w.effectiveWeight
+		//3、选择临时权重最大的节点
+		if best == nil || w.currentWeight > best.currentWeight {
+			best = w
+		}
+	}
+	if best == nil || total == 0 {
+		return ""
+	}
+	//4、选中节点的临时权重减去有效权重之和
+	best.currentWeight -= total
+	return best.addr
+}
+
+func (r *WeightRoundRobinBalance) Get(key string) (string, error) {
+	addr := r.Next()
+	if addr == "" {
+		return "", ErrNoAvailableNode
+	}
+	return addr, nil
+}
@@ -0,0 +1,246 @@
+package reverse_proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"gin_scaffold/dao"
+	"gin_scaffold/public"
+	"gin_scaffold/reverse_proxy/load_balance"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/e421083458/golang_common/lib"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultRetryBackoff    = 25 * time.Millisecond
+	defaultRetryMaxBackoff = 250 * time.Millisecond
+)
+
+// 选取节点失败，没有发出请求
+type pickNodeError struct {
+	err error
+}
+
+func (e *pickNodeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *pickNodeError) Unwrap() error {
+	return e.err
+}
+
+// 服务的重试策略，每次请求按当前配置生成
+type RetryPolicy struct {
+	MaxAttempts    int
+	RetryOn        map[string]bool
+	NonIdempotent  bool
+	Backoff        time.Duration
+	MaxBackoff     time.Duration
+	BudgetPercent  int
+	MaxBodyBytes   int64
+	MinRetryPerSec int
+}
+
+func NewRetryPolicy(lb *dao.LoadBalance) *RetryPolicy {
+	//保存时已校验，解析失败时按默认条件重试
+	retryOn, err := dao.ParseRetryOn(lb.RetryOn)
+	if err != nil {
+		retryOn, _ = dao.ParseRetryOn("")
+	}
+	policy := &RetryPolicy{
+		MaxAttempts:    lb.RetryMaxAttempts,
+		RetryOn:        retryOn,
+		NonIdempotent:  lb.RetryNonIdempotent == 1,
+		Backoff:        time.Duration(lb.RetryBackoff) * time.Millisecond,
+		MaxBackoff:     time.Duration(lb.RetryMaxBackoff) * time.Millisecond,
+		BudgetPercent:  lb.RetryBudget,
+		MaxBodyBytes:   int64(lib.GetIntConf("proxy.retry.max_body_bytes")),
+		MinRetryPerSec: lib.GetIntConf("proxy.retry.min_retries_per_second"),
+	}
+	if policy.Backoff <= 0 {
+		policy.Backoff = defaultRetryBackoff
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = defaultRetryMaxBackoff
+	}
+	if policy.BudgetPercent <= 0 {
+		policy.BudgetPercent = lib.GetIntConf("proxy.retry.budget_percent")
+	}
+	return policy
+}
+
+// 幂等方法或带Idempotency-Key的请求才重试，除非服务允许重试非幂等请求
+func (p *RetryPolicy) AllowMethod(req *http.Request) bool {
+	if p.NonIdempotent {
+		return true
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != "" || req.Header.Get("X-Idempotency-Key") != ""
+}
+
+func (p *RetryPolicy) ShouldRetry(resp *http.Response, err error) bool {
+	if err == nil {
+		return p.RetryOn[strconv.Itoa(resp.StatusCode)]
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return p.RetryOn[dao.RetryOnConnectError]
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return p.RetryOn[dao.RetryOnReset]
+	}
+	return false
+}
+
+// 指数退避加全随机抖动，attempt从1开始
+func (p *RetryPolicy) BackoffDuration(attempt int) time.Duration {
+	backoff := p.Backoff
+	for i := 1; i < attempt && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(backoff)) + 1)
+}
+
+// 集群重试预算：每秒重试数不超过服务集群qps的BudgetPercent，流量很小时至少允许MinRetryPerSec次
+func (p *RetryPolicy) AllowRetry(serviceName string) bool {
+	budget := float64(p.MinRetryPerSec)
+	if counter, err := public.FlowCounterHandler.GetCounter(public.FlowServicePrefix + serviceName); err == nil {
+		if qpsBudget := float64(atomic.LoadInt64(&counter.QPS)) * float64(p.BudgetPercent) / 100; qpsBudget > budget {
+			budget = qpsBudget
+		}
+	}
+	if budget <= 0 {
+		return false
+	}
+	ok, _ := public.AllowFlow(public.RetryBudgetPrefix+serviceName, budget, public.FlowLimitTypeRedisSlidingWindow)
+	return ok
+}
+
+// 每次请求一个，负责选取节点、上报结果以及按策略换节点重试
+type upstreamRoundTripper struct {
+	c         *gin.Context
+	service   *dao.ServiceDetial
+	lb        load_balance.LoadBalance
+	transport http.RoundTripper
+	breakers  *dao.CircuitBreakerItem
+	policy    *RetryPolicy
+}
+
+func (t *upstreamRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	maxAttempts := 1
+	if t.policy.MaxAttempts > 1 && t.policy.AllowMethod(req) {
+		maxAttempts = t.policy.MaxAttempts
+	}
+	var body []byte
+	if maxAttempts > 1 && req.Body != nil && req.Body != http.NoBody {
+		var replayable bool
+		if body, replayable = t.bufferBody(req); !replayable {
+			maxAttempts = 1
+		}
+	}
+	key := GetHashKey(t.c, t.service.LoadBalance)
+	tried := map[string]bool{}
+	addr, breaker, generation, err := pickNode(key, t.service, t.lb, t.breakers, tried)
+	if err != nil {
+		return nil, &pickNodeError{err: err}
+	}
+	for attempt := 1; ; attempt++ {
+		outreq := req
+		if maxAttempts > 1 {
+			outreq = req.Clone(req.Context())
+			if body != nil {
+				outreq.Body = io.NopCloser(bytes.NewReader(body))
+			}
+		}
+		outreq.URL.Host = addr
+		tried[addr] = true
+		start := time.Now()
+		resp, err := t.transport.RoundTrip(outreq)
+		t.report(addr, breaker, generation, resp, err, time.Since(start))
+		if attempt >= maxAttempts || !t.policy.ShouldRetry(resp, err) {
+			return resp, err
+		}
+		//没有其他可用节点时返回本次结果，确定会重试时才消耗重试预算
+		nextAddr, nextBreaker, nextGeneration, pickErr := pickNode(key, t.service, t.lb, t.breakers, tried)
+		if pickErr != nil {
+			return resp, err
+		}
+		if !t.policy.AllowRetry(t.service.Info.ServiceName) {
+			if nextBreaker != nil {
+				nextBreaker.Release(nextGeneration)
+			}
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+		timer := time.NewTimer(t.policy.BackoffDuration(attempt))
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			if nextBreaker != nil {
+				nextBreaker.Release(nextGeneration)
+			}
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		addr, breaker, generation = nextAddr, nextBreaker, nextGeneration
+	}
+}
+
+// 读取body用于重试，超过长度限制时拼回已读部分并放弃重试
+func (t *upstreamRoundTripper) bufferBody(req *http.Request) ([]byte, bool) {
+	if t.policy.MaxBodyBytes > 0 && req.ContentLength > t.policy.MaxBodyBytes {
+		return nil, false
+	}
+	limit := t.policy.MaxBodyBytes
+	if limit <= 0 {
+		limit = 1 << 20
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, limit+1))
+	if err != nil || int64(len(body)) > limit {
+		req.Body = &multiReadCloser{Reader: io.MultiReader(bytes.NewReader(body), req.Body), Closer: req.Body}
+		return nil, false
+	}
+	req.Body.Close()
+	return body, true
+}
+
+type multiReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// 上报单次请求结果，用于被动摘除和节点熔断
+func (t *upstreamRoundTripper) report(addr string, breaker *load_balance.CircuitBreaker, generation uint64, resp *http.Response, err error, latency time.Duration) {
+	if errors.Is(err, context.Canceled) {
+		if breaker != nil {
+			breaker.Release(generation)
+		}
+		return
+	}
+	failed := err != nil || resp.StatusCode >= http.StatusInternalServerError
+	dao.LoadBalancerHandler.ReportResult(t.service, addr, failed)
+	if breaker != nil {
+		breaker.Report(generation, failed, latency)
+	}
+}
@@ -0,0 +1,89 @@
+package reverse_proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gin_scaffold/dao"
+	"gin_scaffold/middleware"
+	"gin_scaffold/reverse_proxy/load_balance"
+	"net/http"
+	"net/http/httputil"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 获取服务的下游transport
+func GetUpstreamTransport(service *dao.ServiceDetial) (http.RoundTripper, error) {
+	transport, err := TransportManagerHandler.GetTransport(service)
+	if err != nil {
+		return nil, err
+	}
+	return transport, nil
+}
+
+// 根据匹配到的服务构建反向代理，节点在发出请求时选取，失败时按服务的重试策略换节点重试
+func NewLoadBalanceReverseProxy(c *gin.Context, service *dao.ServiceDetial, lb load_balance.LoadBalance, trans http.RoundTripper) *httputil.ReverseProxy {
+	director := func(req *http.Request) {
+		req.URL.Scheme = "http"
+		if service.LoadBalance.UpstreamTls == 1 {
+			req.URL.Scheme = "https"
+		}
+		if _, ok := req.Header["User-Agent"]; !ok {
+			req.Header.Set("User-Agent", "")
+		}
+		//X-Forwarded-For由httputil.ReverseProxy追加
+		req.Header.Set("X-Forwarded-Host", c.Request.Host)
+		if c.Request.TLS != nil {
+			req.Header.Set("X-Forwarded-Proto", "https")
+		} else {
+			req.Header.Set("X-Forwarded-Proto", "http")
+		}
+	}
+	errFunc := func(w http.ResponseWriter, req *http.Request, err error) {
+		//客户端主动断开，不需要再输出
+		if errors.Is(err, context.Canceled) {
+			c.Abort()
+			return
+		}
+		if errors.Is(err, ErrNodeCircuitOpen) {
+			CircuitBreakerFallback(c, service.CircuitBreaker, 2026, err)
+			return
+		}
+		var pickErr *pickNodeError
+		if errors.As(err, &pickErr) {
+			middleware.ResponseErrorWithStatus(c, http.StatusServiceUnavailable, 2002, pickErr.err)
+			return
+		}
+		middleware.ResponseErrorWithStatus(c, http.StatusBadGateway, 2003, fmt.Errorf("upstream unavailable: %v", err))
+	}
+	return &httputil.ReverseProxy{
+		Director: director,
+		Transport: &upstreamRoundTripper{
+			c:         c,
+			service:   service,
+			lb:        lb,
+			transport: trans,
+			breakers:  dao.CircuitBreakerHandler.GetItem(service),
+			policy:    NewRetryPolicy(service.LoadBalance),
+		},
+		FlushInterval: -1,
+		ErrorHandler:  errFunc,
+	}
+}
+
+// 一致性hash的取值，header或cookie不存在时退化为客户端ip
+func GetHashKey(c *gin.Context, conf *dao.LoadBalance) string {
+	keyType, name := conf.GetHashKeyByModel()
+	switch keyType {
+	case "header":
+		if value := c.Request.Header.Get(name); value != "" {
+			return value
+		}
+	case "cookie":
+		if cookie, err := c.Request.Cookie(name); err == nil && cookie.Value != "" {
+			return cookie.Value
+		}
+	}
+	return c.ClientIP()
+}
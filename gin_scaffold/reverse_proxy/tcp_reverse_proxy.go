@@ -0,0 +1,70 @@
+package reverse_proxy
+
+import (
+	"context"
+	"gin_scaffold/dao"
+	"gin_scaffold/reverse_proxy/load_balance"
+	"io"
+	"net"
+	"time"
+)
+
+const defaultTcpDialTimeout = 5 * time.Second
+
+// tcp反向代理，按负载均衡选出下游节点后双向拷贝数据
+type TcpReverseProxy struct {
+	service     *dao.ServiceDetial
+	lb          load_balance.LoadBalance
+	DialTimeout time.Duration
+	KeepAlive   time.Duration
+}
+
+func NewTcpLoadBalanceReverseProxy(service *dao.ServiceDetial, lb load_balance.LoadBalance) *TcpReverseProxy {
+	dialTimeout := defaultTcpDialTimeout
+	if service.LoadBalance.UpstreamConnectTimeout > 0 {
+		dialTimeout = time.Duration(service.LoadBalance.UpstreamConnectTimeout) * time.Second
+	}
+	return &TcpReverseProxy{
+		service:     service,
+		lb:          lb,
+		DialTimeout: dialTimeout,
+		KeepAlive:   30 * time.Second,
+	}
+}
+
+func (p *TcpReverseProxy) ServeTCP(ctx context.Context, src net.Conn) {
+	clientIP, _, _ := net.SplitHostPort(src.RemoteAddr().String())
+	nextAddr, err := p.lb.Get(clientIP)
+	if err != nil {
+		return
+	}
+	dialer := &net.Dialer{Timeout: p.DialTimeout, KeepAlive: p.KeepAlive}
+	dst, err := dialer.DialContext(ctx, "tcp", nextAddr)
+	dao.LoadBalancerHandler.ReportResult(p.service, nextAddr, err != nil)
+	if err != nil {
+		return
+	}
+	defer dst.Close()
+
+	done := make(chan struct{}, 2)
+	go p.copy(dst, src, done)
+	go p.copy(src, dst, done)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// 一端读完后半关闭另一端的写，保留对端继续回写的能力
+func (p *TcpReverseProxy) copy(dst, src net.Conn, done chan<- struct{}) {
+	io.Copy(dst, src)
+	if conn, ok := dst.(interface{ CloseWrite() error }); ok {
+		conn.CloseWrite()
+	} else {
+		dst.Close()
+	}
+	done <- struct{}{}
+}
@@ -0,0 +1,283 @@
+package reverse_proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"gin_scaffold/dao"
+	"gin_scaffold/public"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/e421083458/golang_common/lib"
+	"github.com/garyburd/redigo/redis"
+)
+
+// 服务未单独配置时使用的下游连接参数
+var DefaultTransport = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	MaxIdleConns:          100,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: 1 * time.Second,
+}
+
+const defaultDrainTimeout = 60 * time.Second
+
+// 构建transport用到的配置，可直接比较判断是否需要替换
+type TransportConf struct {
+	ConnectTimeout time.Duration
+	HeaderTimeout  time.Duration
+	IdleTimeout    time.Duration
+	MaxIdle        int
+	TLSConfig      *tls.Config
+}
+
+func NewTransportConf(lb *dao.LoadBalance) (TransportConf, error) {
+	tlsConfig, err := lb.GetUpstreamTLSConfig()
+	if err != nil {
+		return TransportConf{}, err
+	}
+	return TransportConf{
+		ConnectTimeout: time.Duration(lb.UpstreamConnectTimeout) * time.Second,
+		HeaderTimeout:  time.Duration(lb.UpstreamHeaderTimeout) * time.Second,
+		IdleTimeout:    time.Duration(lb.UpstreamIdleTimeout) * time.Second,
+		MaxIdle:        lb.UpstreamMaxIdle,
+		TLSConfig:      tlsConfig,
+	}, nil
+}
+
+// 每个服务独立的下游连接池，统计连接及请求数
+type UpstreamTransport struct {
+	conf       TransportConf
+	transport  *http.Transport
+	open       int64
+	active     int64
+	dialErrors int64
+	requests   int64
+}
+
+func NewUpstreamTransport(conf TransportConf) *UpstreamTransport {
+	t := &UpstreamTransport{conf: conf}
+	connectTimeout := conf.ConnectTimeout
+	if connectTimeout <= 0 {
+		connectTimeout = 30 * time.Second
+	}
+	dialer := &net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}
+	transport := DefaultTransport.Clone()
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			atomic.AddInt64(&t.dialErrors, 1)
+			return nil, err
+		}
+		atomic.AddInt64(&t.open, 1)
+		return &countConn{Conn: conn, open: &t.open}, nil
+	}
+	transport.ResponseHeaderTimeout = conf.HeaderTimeout
+	if conf.IdleTimeout > 0 {
+		transport.IdleConnTimeout = conf.IdleTimeout
+	}
+	if conf.MaxIdle > 0 {
+		transport.MaxIdleConns = conf.MaxIdle
+		transport.MaxIdleConnsPerHost = conf.MaxIdle
+	}
+	transport.TLSClientConfig = conf.TLSConfig
+	t.transport = transport
+	return t
+}
+
+// 请求从发出到响应body关闭期间计为活跃
+func (t *UpstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt64(&t.requests, 1)
+	atomic.AddInt64(&t.active, 1)
+	resp, err := t.transport.RoundTrip(req)
+	//协议升级后连接交给调用方，不再计入活跃请求
+	if err != nil || resp.StatusCode == http.StatusSwitchingProtocols {
+		atomic.AddInt64(&t.active, -1)
+		return resp, err
+	}
+	resp.Body = &countBody{ReadCloser: resp.Body, active: &t.active}
+	return resp, nil
+}
+
+// http1下每个活跃请求占用一个连接，其余已建立的连接视为空闲
+func (t *UpstreamTransport) Stats() *dao.TransportStats {
+	open := atomic.LoadInt64(&t.open)
+	active := atomic.LoadInt64(&t.active)
+	idle := open - active
+	if idle < 0 {
+		idle = 0
+	}
+	return &dao.TransportStats{
+		Active:     active,
+		Idle:       idle,
+		DialErrors: atomic.LoadInt64(&t.dialErrors),
+		Requests:   atomic.LoadInt64(&t.requests),
+	}
+}
+
+// 替换后不再有新请求进入，定时关闭空闲连接直到进行中的请求全部结束或超时
+func (t *UpstreamTransport) drain(timeout time.Duration, done func()) {
+	defer done()
+	t.transport.CloseIdleConnections()
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&t.open) > 0 && time.Now().Before(deadline) {
+		<-ticker.C
+		t.transport.CloseIdleConnections()
+	}
+}
+
+type countConn struct {
+	net.Conn
+	open *int64
+	once sync.Once
+}
+
+func (c *countConn) Close() error {
+	c.once.Do(func() {
+		atomic.AddInt64(c.open, -1)
+	})
+	return c.Conn.Close()
+}
+
+type countBody struct {
+	io.ReadCloser
+	active *int64
+	once   sync.Once
+}
+
+func (b *countBody) Close() error {
+	b.once.Do(func() {
+		atomic.AddInt64(b.active, -1)
+	})
+	return b.ReadCloser.Close()
+}
+
+var TransportManagerHandler *TransportManager
+
+func init() {
+	TransportManagerHandler = NewTransportManager()
+	dao.ServiceManagerHandler.Register(TransportManagerHandler.OnServiceChange)
+}
+
+type TransportManager struct {
+	TransportMap map[string]*UpstreamTransport
+	Locker       sync.Mutex
+	draining     map[string]int
+}
+
+func NewTransportManager() *TransportManager {
+	return &TransportManager{
+		TransportMap: map[string]*UpstreamTransport{},
+		draining:     map[string]int{},
+	}
+}
+
+// 获取服务的下游transport，Upstream*配置变化时替换并释放旧连接池
+func (m *TransportManager) GetTransport(service *dao.ServiceDetial) (*UpstreamTransport, error) {
+	conf, err := NewTransportConf(service.LoadBalance)
+	if err != nil {
+		return nil, err
+	}
+	serviceName := service.Info.ServiceName
+	m.Locker.Lock()
+	defer m.Locker.Unlock()
+	item, ok := m.TransportMap[serviceName]
+	if ok && item.conf == conf {
+		return item, nil
+	}
+	if ok {
+		m.drain(serviceName, item)
+	}
+	item = NewUpstreamTransport(conf)
+	m.TransportMap[serviceName] = item
+	return item, nil
+}
+
+// 服务删除或配置变化时提前释放，配置变化的服务在下次请求时重新构建
+func (m *TransportManager) OnServiceChange(change *dao.ServiceChange) {
+	m.Locker.Lock()
+	defer m.Locker.Unlock()
+	services := append(append([]*dao.ServiceDetial{}, change.Updated...), change.Removed...)
+	for index, service := range services {
+		item, ok := m.TransportMap[service.Info.ServiceName]
+		if !ok {
+			continue
+		}
+		if index < len(change.Updated) && service.LoadBalance != nil {
+			if conf, err := NewTransportConf(service.LoadBalance); err == nil && conf == item.conf {
+				continue
+			}
+		}
+		m.drain(service.Info.ServiceName, item)
+		delete(m.TransportMap, service.Info.ServiceName)
+	}
+}
+
+// 调用方需持有Locker
+func (m *TransportManager) drain(serviceName string, item *UpstreamTransport) {
+	m.draining[serviceName]++
+	timeout := time.Duration(lib.GetIntConf("proxy.transport.drain_timeout")) * time.Second
+	if timeout <= 0 {
+		timeout = defaultDrainTimeout
+	}
+	go item.drain(timeout, func() {
+		m.Locker.Lock()
+		defer m.Locker.Unlock()
+		if m.draining[serviceName]--; m.draining[serviceName] <= 0 {
+			delete(m.draining, serviceName)
+		}
+	})
+}
+
+// 当前实例各服务的连接池统计
+func (m *TransportManager) StatsMap() map[string]*dao.TransportStats {
+	m.Locker.Lock()
+	defer m.Locker.Unlock()
+	statsMap := map[string]*dao.TransportStats{}
+	for serviceName, item := range m.TransportMap {
+		stats := item.Stats()
+		stats.Draining = m.draining[serviceName]
+		statsMap[serviceName] = stats
+	}
+	return statsMap
+}
+
+// 定时把连接池统计写入redis，供dashboard展示
+func StartTransportStatReport(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	hostname, _ := os.Hostname()
+	instance := fmt.Sprintf("%s:%d", hostname, os.Getpid())
+	expire := int(interval.Seconds())*3 + 60
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			now := time.Now()
+			statsMap := TransportManagerHandler.StatsMap()
+			public.RedisConfPipline(func(c redis.Conn) {
+				for serviceName, stats := range statsMap {
+					stats.Instance = instance
+					stats.ReportTime = now
+					key := public.TransportStatsPrefix + serviceName
+					c.Send("HSET", key, instance, public.Obj2json(stats))
+					c.Send("EXPIRE", key, expire)
+				}
+			})
+		}
+	}()
+}
@@ -0,0 +1,183 @@
+package reverse_proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"gin_scaffold/dao"
+	"gin_scaffold/middleware"
+	"gin_scaffold/reverse_proxy/load_balance"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// websocket反向代理，握手成功后接管客户端连接并与下游双向转发
+type WebsocketReverseProxy struct {
+	c           *gin.Context
+	service     *dao.ServiceDetial
+	lb          load_balance.LoadBalance
+	DialTimeout time.Duration
+	IdleTimeout time.Duration
+
+	lastActive int64
+}
+
+func NewWebsocketLoadBalanceReverseProxy(c *gin.Context, service *dao.ServiceDetial, lb load_balance.LoadBalance, idleTimeout time.Duration) *WebsocketReverseProxy {
+	dialTimeout := defaultTcpDialTimeout
+	if service.LoadBalance.UpstreamConnectTimeout > 0 {
+		dialTimeout = time.Duration(service.LoadBalance.UpstreamConnectTimeout) * time.Second
+	}
+	return &WebsocketReverseProxy{
+		c:           c,
+		service:     service,
+		lb:          lb,
+		DialTimeout: dialTimeout,
+		IdleTimeout: idleTimeout,
+	}
+}
+
+func (p *WebsocketReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	nextAddr, err := p.lb.Get(GetHashKey(p.c, p.service.LoadBalance))
+	if err != nil {
+		middleware.ResponseErrorWithStatus(p.c, http.StatusServiceUnavailable, 2002, err)
+		return
+	}
+	tlsConfig, err := p.service.LoadBalance.GetUpstreamTLSConfig()
+	if err != nil {
+		middleware.ResponseErrorWithStatus(p.c, http.StatusBadGateway, 2003, fmt.Errorf("upstream tls config: %v", err))
+		return
+	}
+	upConn, err := net.DialTimeout("tcp", nextAddr, p.DialTimeout)
+	if err != nil {
+		dao.LoadBalancerHandler.ReportResult(p.service, nextAddr, true)
+		middleware.ResponseErrorWithStatus(p.c, http.StatusBadGateway, 2003, fmt.Errorf("upstream unavailable: %v", err))
+		return
+	}
+	//握手在首次写入时进行，受下面设置的超时约束
+	if tlsConfig != nil {
+		if tlsConfig.ServerName == "" {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(nextAddr)
+		}
+		upConn = tls.Client(upConn, tlsConfig)
+	}
+	defer upConn.Close()
+
+	outreq := req.Clone(req.Context())
+	outreq.URL.Scheme = "http"
+	if tlsConfig != nil {
+		outreq.URL.Scheme = "https"
+	}
+	outreq.URL.Host = nextAddr
+	outreq.RequestURI = ""
+	if clientIP, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		if prior := outreq.Header.Get("X-Forwarded-For"); prior != "" {
+			clientIP = prior + ", " + clientIP
+		}
+		outreq.Header.Set("X-Forwarded-For", clientIP)
+	}
+	outreq.Header.Set("X-Forwarded-Host", req.Host)
+	if req.TLS != nil {
+		outreq.Header.Set("X-Forwarded-Proto", "https")
+	} else {
+		outreq.Header.Set("X-Forwarded-Proto", "http")
+	}
+	upConn.SetDeadline(time.Now().Add(p.DialTimeout))
+	if err := outreq.Write(upConn); err != nil {
+		dao.LoadBalancerHandler.ReportResult(p.service, nextAddr, true)
+		middleware.ResponseErrorWithStatus(p.c, http.StatusBadGateway, 2003, fmt.Errorf("upstream unavailable: %v", err))
+		return
+	}
+	upReader := bufio.NewReader(upConn)
+	resp, err := http.ReadResponse(upReader, outreq)
+	if err != nil {
+		dao.LoadBalancerHandler.ReportResult(p.service, nextAddr, true)
+		middleware.ResponseErrorWithStatus(p.c, http.StatusBadGateway, 2003, fmt.Errorf("upstream unavailable: %v", err))
+		return
+	}
+	defer resp.Body.Close()
+	dao.LoadBalancerHandler.ReportResult(p.service, nextAddr, resp.StatusCode >= http.StatusInternalServerError)
+
+	//下游拒绝升级，按普通响应返回
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		for k, vv := range resp.Header {
+			for _, v := range vv {
+				rw.Header().Add(k, v)
+			}
+		}
+		rw.WriteHeader(resp.StatusCode)
+		io.Copy(rw, resp.Body)
+		return
+	}
+
+	hijacker, ok := rw.(http.Hijacker)
+	if !ok {
+		middleware.ResponseErrorWithStatus(p.c, http.StatusInternalServerError, 2006, fmt.Errorf("response writer does not support hijack"))
+		return
+	}
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer clientConn.Close()
+	//清除http server设置的读写超时，之后由空闲超时控制
+	clientConn.SetDeadline(time.Time{})
+	upConn.SetDeadline(time.Time{})
+
+	if _, err := fmt.Fprintf(clientBuf, "HTTP/1.1 %s\r\n", resp.Status); err != nil {
+		return
+	}
+	if err := resp.Header.Write(clientBuf); err != nil {
+		return
+	}
+	if _, err := clientBuf.WriteString("\r\n"); err != nil {
+		return
+	}
+	if err := clientBuf.Flush(); err != nil {
+		return
+	}
+
+	p.touch()
+	done := make(chan struct{}, 2)
+	go p.pipe(upConn, clientConn, clientBuf.Reader, done)
+	go p.pipe(clientConn, upConn, upReader, done)
+	<-done
+}
+
+func (p *WebsocketReverseProxy) touch() {
+	atomic.StoreInt64(&p.lastActive, time.Now().UnixNano())
+}
+
+// 单向转发，任一方向有数据都算活跃，两个方向都空闲超过IdleTimeout才断开
+func (p *WebsocketReverseProxy) pipe(dst net.Conn, srcConn net.Conn, src io.Reader, done chan<- struct{}) {
+	defer func() {
+		done <- struct{}{}
+	}()
+	buf := make([]byte, 32*1024)
+	for {
+		if p.IdleTimeout > 0 {
+			srcConn.SetReadDeadline(time.Now().Add(p.IdleTimeout))
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			p.touch()
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				last := time.Unix(0, atomic.LoadInt64(&p.lastActive))
+				if time.Since(last) < p.IdleTimeout {
+					continue
+				}
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,91 @@
+-- 已有网关库升级到当前版本需要的表结构变更，按功能分组，全部为新增列或新表，可按顺序执行一次
+
+-- 负载均衡：一致性hash取值、http健康检查、下游tls、重试
+ALTER TABLE `gateway_service_load_balance`
+  ADD COLUMN `check_path` varchar(255) NOT NULL DEFAULT '' COMMENT 'httpchk检查路径' AFTER `check_interval`,
+  ADD COLUMN `check_expect_status` int(11) NOT NULL DEFAULT '0' COMMENT 'httpchk期望状态码, 默认200' AFTER `check_path`,
+  ADD COLUMN `hash_key` varchar(255) NOT NULL DEFAULT '' COMMENT '一致性hash取值 为空=客户端ip header:名称 cookie:名称' AFTER `round_type`,
+  ADD COLUMN `upstream_tls` tinyint(4) NOT NULL DEFAULT '0' COMMENT '下游是否使用tls 1=使用, 仅http和grpc服务',
+  ADD COLUMN `upstream_ca_cert` text COMMENT '校验下游证书的CA, pem内容或文件路径, 为空使用系统根证书',
+  ADD COLUMN `upstream_client_cert` text COMMENT '向下游出示的客户端证书, pem内容或文件路径, 为空不出示',
+  ADD COLUMN `upstream_client_key` text COMMENT '客户端证书私钥, pem内容或文件路径',
+  ADD COLUMN `upstream_server_name` varchar(255) NOT NULL DEFAULT '' COMMENT '校验下游证书时使用的域名, 为空使用节点地址',
+  ADD COLUMN `retry_max_attempts` int(11) NOT NULL DEFAULT '0' COMMENT '最多尝试次数, 含首次请求, 0或1=不重试, 仅http服务',
+  ADD COLUMN `retry_on` varchar(255) NOT NULL DEFAULT '' COMMENT '重试条件 connect_error,reset,502,503,504 逗号间隔, 为空=全部',
+  ADD COLUMN `retry_non_idempotent` tinyint(4) NOT NULL DEFAULT '0' COMMENT '1=POST/PATCH等非幂等请求也重试',
+  ADD COLUMN `retry_backoff` int(11) NOT NULL DEFAULT '0' COMMENT '重试退避基数, 单位ms, 0=25ms',
+  ADD COLUMN `retry_max_backoff` int(11) NOT NULL DEFAULT '0' COMMENT '重试退避上限, 单位ms, 0=250ms',
+  ADD COLUMN `retry_budget` int(11) NOT NULL DEFAULT '0' COMMENT '集群重试预算, 重试请求占服务qps的百分比, 0=使用proxy.retry.budget_percent';
+
+-- 权限控制：认证方式、限流方式、并发连接数、客户端证书CA
+ALTER TABLE `gateway_service_access_control`
+  ADD COLUMN `auth_type` tinyint(4) NOT NULL DEFAULT '0' COMMENT '权限验证方式 0=JWT token 1=HMAC签名 2=客户端证书, 仅http服务',
+  ADD COLUMN `flow_limit_type` tinyint(4) NOT NULL DEFAULT '0' COMMENT '限流方式 0=单机令牌桶 1=redis滑动窗口 2=redis GCRA',
+  ADD COLUMN `service_max_conn` int(11) NOT NULL DEFAULT '0' COMMENT '服务最大并发连接数, 0=不限制',
+  ADD COLUMN `clientip_max_conn` int(11) NOT NULL DEFAULT '0' COMMENT '客户端ip最大并发连接数, 0=不限制',
+  ADD COLUMN `client_ca` text COMMENT '校验客户端证书的CA, pem内容或文件路径, 为空不要求客户端证书, 仅https';
+
+-- http规则：HSTS、websocket并发数和空闲超时
+ALTER TABLE `gateway_service_http_rule`
+  ADD COLUMN `hsts_max_age` int(11) NOT NULL DEFAULT '0' COMMENT 'https响应的HSTS max-age, 单位s, 0=不下发' AFTER `need_https`,
+  ADD COLUMN `hsts_include_subdomains` tinyint(4) NOT NULL DEFAULT '0' COMMENT 'HSTS是否包含子域名 1=包含' AFTER `hsts_max_age`,
+  ADD COLUMN `websocket_max_conn` int(11) NOT NULL DEFAULT '0' COMMENT 'websocket最大并发连接数, 0=不限制' AFTER `need_websocket`,
+  ADD COLUMN `websocket_idle_timeout` int(11) NOT NULL DEFAULT '0' COMMENT 'websocket空闲超时, 单位s, 0=使用默认配置' AFTER `websocket_max_conn`;
+
+-- 租户：客户端证书认证
+ALTER TABLE `gateway_apps`
+  ADD COLUMN `cert_subject` varchar(255) NOT NULL DEFAULT '' COMMENT '客户端证书subject, 如CN=app1,O=org, 为空时按证书CN匹配app_id' AFTER `qps`;
+
+-- 证书管理：上传证书与内部CA自动签发的证书
+CREATE TABLE IF NOT EXISTS `gateway_cert` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT COMMENT '自增主键',
+  `name` varchar(255) NOT NULL DEFAULT '' COMMENT '证书名称',
+  `store_type` tinyint(4) NOT NULL DEFAULT '0' COMMENT '存储方式 0=db 1=磁盘文件',
+  `source` tinyint(4) NOT NULL DEFAULT '0' COMMENT '来源 0=上传 1=内部CA自动签发',
+  `cert_pem` text COMMENT '证书链pem, store_type=0时使用',
+  `key_pem` text COMMENT '私钥pem, store_type=0时使用',
+  `cert_file` varchar(255) NOT NULL DEFAULT '' COMMENT '证书文件路径, store_type=1时使用',
+  `key_file` varchar(255) NOT NULL DEFAULT '' COMMENT '私钥文件路径, store_type=1时使用',
+  `domains` varchar(1024) NOT NULL DEFAULT '' COMMENT '证书包含的域名, 逗号间隔',
+  `not_before` datetime NOT NULL DEFAULT '1971-01-01 00:00:00' COMMENT '生效时间',
+  `not_after` datetime NOT NULL DEFAULT '1971-01-01 00:00:00' COMMENT '过期时间',
+  `create_at` datetime NOT NULL DEFAULT '1971-01-01 00:00:00' COMMENT '添加时间',
+  `update_at` datetime NOT NULL DEFAULT '1971-01-01 00:00:00' COMMENT '更新时间',
+  `is_delete` tinyint(4) NOT NULL DEFAULT '0' COMMENT '是否已删除；0：否；1：是',
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='网关证书';
+
+-- 熔断规则
+CREATE TABLE IF NOT EXISTS `gateway_service_circuit_breaker` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT COMMENT '自增主键',
+  `service_id` bigint(20) NOT NULL DEFAULT '0' COMMENT '服务id',
+  `window` int(11) NOT NULL DEFAULT '0' COMMENT '统计滑动窗口, 单位s, 0=10s',
+  `min_requests` int(11) NOT NULL DEFAULT '0' COMMENT '窗口内请求数达到该值才判断是否熔断, 0=20',
+  `error_rate` int(11) NOT NULL DEFAULT '0' COMMENT '错误率阈值, 百分比, 0=不按错误率熔断',
+  `slow_call_rate` int(11) NOT NULL DEFAULT '0' COMMENT '慢调用率阈值, 百分比, 0=不按慢调用率熔断',
+  `slow_call_ms` int(11) NOT NULL DEFAULT '0' COMMENT '耗时超过该值计为慢调用, 单位ms',
+  `open_time` int(11) NOT NULL DEFAULT '0' COMMENT '熔断持续时间, 单位s, 0=30s',
+  `half_open_requests` int(11) NOT NULL DEFAULT '0' COMMENT '半开状态放行的探测请求数, 0=5',
+  `fallback_status` int(11) NOT NULL DEFAULT '0' COMMENT '熔断时返回的http状态码, 0=503',
+  `fallback_content_type` varchar(255) NOT NULL DEFAULT '' COMMENT '熔断时返回的Content-Type',
+  `fallback_body` text COMMENT '熔断时返回的内容, 为空返回网关错误信息',
+  `create_at` datetime NOT NULL DEFAULT '1971-01-01 00:00:00' COMMENT '添加时间',
+  `update_at` datetime NOT NULL DEFAULT '1971-01-01 00:00:00' COMMENT '更新时间',
+  `is_delete` tinyint(4) NOT NULL DEFAULT '0' COMMENT '是否已删除；0：否；1：是',
+  PRIMARY KEY (`id`),
+  KEY `idx_service_id` (`service_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='网关熔断规则';
+
+-- 按小时持久化的流量统计
+CREATE TABLE IF NOT EXISTS `gateway_flow_stat` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT COMMENT '自增主键',
+  `name` varchar(255) NOT NULL DEFAULT '' COMMENT '计数器名称',
+  `stat_date` varchar(10) NOT NULL DEFAULT '' COMMENT '统计日期 格式2006-01-02',
+  `hour` tinyint(4) NOT NULL DEFAULT '0' COMMENT '小时 0-23',
+  `request_count` bigint(20) NOT NULL DEFAULT '0' COMMENT '请求量',
+  `error_count` bigint(20) NOT NULL DEFAULT '0' COMMENT '错误量',
+  `p95` bigint(20) NOT NULL DEFAULT '0' COMMENT 'p95耗时, 单位ms',
+  `update_at` datetime NOT NULL DEFAULT '1971-01-01 00:00:00' COMMENT '更新时间',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_name_date_hour` (`name`, `stat_date`, `hour`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='网关流量小时统计';
@@ -0,0 +1,26 @@
+package tcp_proxy_middleware
+
+import (
+	"gin_scaffold/dao"
+)
+
+// 黑白名单校验，配置了白名单时只允许白名单内ip，白名单优先级高于黑名单
+func TcpBlackWhiteListMiddleware() TcpHandlerFunc {
+	return func(c *TcpSliceRouterContext) {
+		serviceInterface, ok := c.Get("service")
+		if !ok {
+			c.Abort()
+			return
+		}
+		service := serviceInterface.(*dao.ServiceDetial)
+		if service.AccessControl == nil {
+			c.Next()
+			return
+		}
+		if !service.AccessControl.AllowClientIP(c.ClientIP()) {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
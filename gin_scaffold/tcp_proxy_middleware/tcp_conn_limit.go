@@ -0,0 +1,76 @@
+package tcp_proxy_middleware
+
+import (
+	"gin_scaffold/dao"
+	"sync"
+)
+
+type connCounter struct {
+	total int
+	ips   map[string]int
+}
+
+var (
+	connCounterMap    = map[string]*connCounter{}
+	connCounterLocker sync.Mutex
+)
+
+// 限制服务与单个客户端ip的并发连接数，超过上限直接断开
+func TcpConnLimitMiddleware() TcpHandlerFunc {
+	return func(c *TcpSliceRouterContext) {
+		serviceInterface, ok := c.Get("service")
+		if !ok {
+			c.Abort()
+			return
+		}
+		service := serviceInterface.(*dao.ServiceDetial)
+		if service.AccessControl == nil ||
+			(service.AccessControl.ServiceMaxConn <= 0 && service.AccessControl.ClientIPMaxConn <= 0) {
+			c.Next()
+			return
+		}
+		serviceName := service.Info.ServiceName
+		clientIP := c.ClientIP()
+		if !acquireConn(serviceName, clientIP, service.AccessControl) {
+			c.Abort()
+			return
+		}
+		defer releaseConn(serviceName, clientIP)
+		c.Next()
+	}
+}
+
+func acquireConn(serviceName, clientIP string, access *dao.AcccessControll) bool {
+	connCounterLocker.Lock()
+	defer connCounterLocker.Unlock()
+	counter, ok := connCounterMap[serviceName]
+	if !ok {
+		counter = &connCounter{ips: map[string]int{}}
+		connCounterMap[serviceName] = counter
+	}
+	if access.ServiceMaxConn > 0 && counter.total >= access.ServiceMaxConn {
+		return false
+	}
+	if access.ClientIPMaxConn > 0 && counter.ips[clientIP] >= access.ClientIPMaxConn {
+		return false
+	}
+	counter.total++
+	counter.ips[clientIP]++
+	return true
+}
+
+func releaseConn(serviceName, clientIP string) {
+	connCounterLocker.Lock()
+	defer connCounterLocker.Unlock()
+	counter, ok := connCounterMap[serviceName]
+	if !ok {
+		return
+	}
+	counter.total--
+	if counter.ips[clientIP]--; counter.ips[clientIP] <= 0 {
+		delete(counter.ips, clientIP)
+	}
+	if counter.total <= 0 {
+		delete(connCounterMap, serviceName)
+	}
+}
@@ -0,0 +1,25 @@
+package tcp_proxy_middleware
+
+import (
+	"gin_scaffold/dao"
+	"gin_scaffold/public"
+)
+
+// 按新建连接统计全站和服务的请求量
+func TcpFlowCountMiddleware() TcpHandlerFunc {
+	return func(c *TcpSliceRouterContext) {
+		serviceInterface, ok := c.Get("service")
+		if !ok {
+			c.Abort()
+			return
+		}
+		service := serviceInterface.(*dao.ServiceDetial)
+		if totalCounter, err := public.FlowCounterHandler.GetCounter(public.FlowTotal); err == nil {
+			totalCounter.Increase()
+		}
+		if serviceCounter, err := public.FlowCounterHandler.GetCounter(public.FlowServicePrefix + service.Info.ServiceName); err == nil {
+			serviceCounter.Increase()
+		}
+		c.Next()
+	}
+}
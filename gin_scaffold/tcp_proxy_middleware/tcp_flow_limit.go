@@ -0,0 +1,33 @@
+package tcp_proxy_middleware
+
+import (
+	"gin_scaffold/dao"
+	"gin_scaffold/public"
+)
+
+// 按新建连接做令牌桶限流，超过限制直接断开
+func TcpFlowLimitMiddleware() TcpHandlerFunc {
+	return func(c *TcpSliceRouterContext) {
+		serviceInterface, ok := c.Get("service")
+		if !ok {
+			c.Abort()
+			return
+		}
+		service := serviceInterface.(*dao.ServiceDetial)
+		access := service.AccessControl
+		if access == nil {
+			c.Next()
+			return
+		}
+		serviceName := service.Info.ServiceName
+		if ok, _ := public.AllowFlow(public.FlowServicePrefix+serviceName, float64(access.ServiceFlowLimit), access.FlowLimitType); !ok {
+			c.Abort()
+			return
+		}
+		if ok, _ := public.AllowFlow(public.FlowServicePrefix+serviceName+"_"+c.ClientIP(), float64(access.ClientIPFlowLimit), access.FlowLimitType); !ok {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,26 @@
+package tcp_proxy_middleware
+
+import (
+	"gin_scaffold/dao"
+	"gin_scaffold/reverse_proxy"
+)
+
+// 匹配服务的负载均衡器并转发连接
+func TcpReverseProxyMiddleware() TcpHandlerFunc {
+	return func(c *TcpSliceRouterContext) {
+		serviceInterface, ok := c.Get("service")
+		if !ok {
+			c.Abort()
+			return
+		}
+		service := serviceInterface.(*dao.ServiceDetial)
+		lb, err := dao.LoadBalancerHandler.GetLoadBalancer(service)
+		if err != nil {
+			c.Abort()
+			return
+		}
+		proxy := reverse_proxy.NewTcpLoadBalanceReverseProxy(service, lb)
+		proxy.ServeTCP(c.Ctx, c.Conn)
+		c.Abort()
+	}
+}
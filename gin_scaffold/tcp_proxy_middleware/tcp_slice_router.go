@@ -0,0 +1,71 @@
+package tcp_proxy_middleware
+
+import (
+	"context"
+	"net"
+)
+
+type TcpHandlerFunc func(c *TcpSliceRouterContext)
+
+// 按注册顺序执行的tcp中间件链，用法与gin中间件一致
+type TcpSliceRouter struct {
+	handlers []TcpHandlerFunc
+}
+
+func NewTcpSliceRouter() *TcpSliceRouter {
+	return &TcpSliceRouter{}
+}
+
+func (r *TcpSliceRouter) Use(middlewares ...TcpHandlerFunc) *TcpSliceRouter {
+	r.handlers = append(r.handlers, middlewares...)
+	return r
+}
+
+// 每条连接创建一个上下文
+func (r *TcpSliceRouter) NewContext(ctx context.Context, conn net.Conn) *TcpSliceRouterContext {
+	return &TcpSliceRouterContext{
+		Conn:     conn,
+		Ctx:      ctx,
+		handlers: r.handlers,
+		index:    -1,
+		keys:     map[string]interface{}{},
+	}
+}
+
+type TcpSliceRouterContext struct {
+	Conn     net.Conn
+	Ctx      context.Context
+	handlers []TcpHandlerFunc
+	index    int
+	keys     map[string]interface{}
+}
+
+func (c *TcpSliceRouterContext) Next() {
+	c.index++
+	for c.index < len(c.handlers) {
+		c.handlers[c.index](c)
+		c.index++
+	}
+}
+
+func (c *TcpSliceRouterContext) Abort() {
+	c.index = len(c.handlers)
+}
+
+func (c *TcpSliceRouterContext) IsAborted() bool {
+	return c.index >= len(c.handlers)
+}
+
+func (c *TcpSliceRouterContext) Set(key string, value interface{}) {
+	c.keys[key] = value
+}
+
+func (c *TcpSliceRouterContext) Get(key string) (interface{}, bool) {
+	value, ok := c.keys[key]
+	return value, ok
+}
+
+func (c *TcpSliceRouterContext) ClientIP() string {
+	ip, _, _ := net.SplitHostPort(c.Conn.RemoteAddr().String())
+	return ip
+}
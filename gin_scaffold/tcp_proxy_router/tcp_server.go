@@ -0,0 +1,108 @@
+package tcp_proxy_router
+
+import (
+	"context"
+	"fmt"
+	"gin_scaffold/dao"
+	"gin_scaffold/tcp_proxy_middleware"
+	"gin_scaffold/tcp_server"
+	"log"
+	"net"
+	"sync"
+)
+
+type tcpServerItem struct {
+	port   int
+	server *tcp_server.TcpServer
+}
+
+var (
+	tcpServerMap    = map[string]*tcpServerItem{}
+	tcpServerLocker sync.Mutex
+)
+
+// 为每个tcp服务启动监听，并随服务增删改同步开关监听
+func TcpServerRun() {
+	dao.ServiceManagerHandler.Register(func(change *dao.ServiceChange) {
+		syncTcpServers()
+	})
+	syncTcpServers()
+}
+
+func TcpServerStop() {
+	tcpServerLocker.Lock()
+	defer tcpServerLocker.Unlock()
+	for name, item := range tcpServerMap {
+		item.server.Close()
+		delete(tcpServerMap, name)
+		log.Printf(" [INFO] TcpProxyStop:%d stopped\n", item.port)
+	}
+}
+
+func syncTcpServers() {
+	tcpServerLocker.Lock()
+	defer tcpServerLocker.Unlock()
+	serviceMap := map[string]*dao.ServiceDetial{}
+	for _, service := range dao.ServiceManagerHandler.GetTcpServiceList() {
+		if service.TCPRule == nil || service.TCPRule.Port == 0 {
+			continue
+		}
+		serviceMap[service.Info.ServiceName] = service
+	}
+	//先关闭已删除或端口变化的服务，避免新监听端口冲突
+	for name, item := range tcpServerMap {
+		if service, ok := serviceMap[name]; ok && service.TCPRule.Port == item.port {
+			continue
+		}
+		item.server.Close()
+		delete(tcpServerMap, name)
+		log.Printf(" [INFO] TcpProxyStop:%d stopped\n", item.port)
+	}
+	for name, service := range serviceMap {
+		if _, ok := tcpServerMap[name]; ok {
+			continue
+		}
+		tcpServerMap[name] = startTcpServer(service)
+	}
+}
+
+func startTcpServer(service *dao.ServiceDetial) *tcpServerItem {
+	serviceName := service.Info.ServiceName
+	router := tcp_proxy_middleware.NewTcpSliceRouter()
+	router.Use(
+		tcp_proxy_middleware.TcpFlowCountMiddleware(),
+		tcp_proxy_middleware.TcpBlackWhiteListMiddleware(),
+		tcp_proxy_middleware.TcpFlowLimitMiddleware(),
+		tcp_proxy_middleware.TcpConnLimitMiddleware(),
+		tcp_proxy_middleware.TcpReverseProxyMiddleware())
+	handler := tcp_server.TCPHandlerFunc(func(ctx context.Context, conn net.Conn) {
+		//每条连接取最新的服务配置，配置更新无需重启监听
+		service, ok := dao.ServiceManagerHandler.GetService(serviceName)
+		if !ok {
+			return
+		}
+		c := router.NewContext(ctx, conn)
+		c.Set("service", service)
+		c.Next()
+	})
+	item := &tcpServerItem{
+		port: service.TCPRule.Port,
+		server: &tcp_server.TcpServer{
+			Addr:    fmt.Sprintf(":%d", service.TCPRule.Port),
+			Handler: handler,
+		},
+	}
+	go func() {
+		log.Printf(" [INFO] TcpProxyRun:%d\n", item.port)
+		if err := item.server.ListenAndServe(); err != nil && err != tcp_server.ErrServerClosed {
+			log.Printf(" [ERROR] TcpProxyRun:%d err:%v\n", item.port, err)
+			//监听失败时移除，下次同步重试
+			tcpServerLocker.Lock()
+			if tcpServerMap[serviceName] == item {
+				delete(tcpServerMap, serviceName)
+			}
+			tcpServerLocker.Unlock()
+		}
+	}()
+	return item
+}
@@ -0,0 +1,139 @@
+package tcp_server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrServerClosed = errors.New("tcp: Server closed")
+
+type TCPHandler interface {
+	ServeTCP(ctx context.Context, conn net.Conn)
+}
+
+// 普通函数适配为TCPHandler
+type TCPHandlerFunc func(ctx context.Context, conn net.Conn)
+
+func (f TCPHandlerFunc) ServeTCP(ctx context.Context, conn net.Conn) {
+	f(ctx, conn)
+}
+
+type TcpServer struct {
+	Addr    string
+	Handler TCPHandler
+	BaseCtx context.Context
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	cancel   context.CancelFunc
+	closed   bool
+}
+
+func (s *TcpServer) ListenAndServe() error {
+	if s.isClosed() {
+		return ErrServerClosed
+	}
+	ln, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+func (s *TcpServer) Serve(ln net.Listener) error {
+	baseCtx := s.BaseCtx
+	if baseCtx == nil {
+		baseCtx = context.Background()
+	}
+	ctx, cancel := context.WithCancel(baseCtx)
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		cancel()
+		ln.Close()
+		return ErrServerClosed
+	}
+	s.listener = ln
+	s.cancel = cancel
+	s.mu.Unlock()
+	defer ln.Close()
+
+	var tempDelay time.Duration
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			//文件句柄耗尽等临时错误，退避后继续accept
+			if tempDelay == 0 {
+				tempDelay = 5 * time.Millisecond
+			} else if tempDelay *= 2; tempDelay > time.Second {
+				tempDelay = time.Second
+			}
+			time.Sleep(tempDelay)
+			continue
+		}
+		tempDelay = 0
+		if !s.trackConn(conn, true) {
+			conn.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer func() {
+				s.trackConn(conn, false)
+				conn.Close()
+			}()
+			s.Handler.ServeTCP(ctx, conn)
+		}()
+	}
+}
+
+// 关闭监听并断开所有已建立的连接
+func (s *TcpServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.cancel != nil {
+		s.cancel()
+	}
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+	return err
+}
+
+func (s *TcpServer) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func (s *TcpServer) trackConn(conn net.Conn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.conns, conn)
+		return true
+	}
+	if s.closed {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = map[net.Conn]struct{}{}
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
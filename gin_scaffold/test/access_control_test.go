@@ -0,0 +1,117 @@
+package test
+
+import (
+	"gin_scaffold/dao"
+	"gin_scaffold/http_proxy_middleware"
+	"gin_scaffold/public"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAccessControlAllowClientIP(t *testing.T) {
+	cases := []struct {
+		access *dao.AcccessControll
+		ip     string
+		allow  bool
+	}{
+		{&dao.AcccessControll{}, "10.0.0.1", true},
+		{&dao.AcccessControll{BlackList: "10.0.0.1"}, "10.0.0.1", false},
+		{&dao.AcccessControll{BlackList: "10.0.0.0/8"}, "10.2.3.4", false},
+		{&dao.AcccessControll{BlackList: "10.0.0.0/8"}, "11.0.0.1", true},
+		//白名单优先级高于黑名单
+		{&dao.AcccessControll{WhiteList: "10.0.0.1", BlackList: "10.0.0.1"}, "10.0.0.1", true},
+		{&dao.AcccessControll{WhiteList: "192.168.1"}, "192.168.1.20", true},
+		{&dao.AcccessControll{WhiteList: "192.168.1"}, "192.168.10.20", false},
+		{&dao.AcccessControll{WhiteList: "192.168.*,\n172.16.0.0/12"}, "172.20.0.1", true},
+		//白名单条目全部无法解析时拒绝，不能退化为不限制
+		{&dao.AcccessControll{WhiteList: "10.0.0.0/33,abc"}, "10.0.0.1", false},
+		{&dao.AcccessControll{WhiteList: "10.0.0.0/33,10.0.0.1"}, "10.0.0.1", true},
+		{&dao.AcccessControll{BlackList: "300.1"}, "10.0.0.1", true},
+	}
+	for _, item := range cases {
+		if got := item.access.AllowClientIP(item.ip); got != item.allow {
+			t.Errorf("white=%q black=%q ip=%s got %v", item.access.WhiteList, item.access.BlackList, item.ip, got)
+		}
+	}
+	app := &dao.App{WhiteIPS: "127.0.0"}
+	if !app.AllowClientIP("127.0.0.1") || app.AllowClientIP("127.0.1.1") {
+		t.Error("app white_ips prefix match failed")
+	}
+	if (&dao.App{WhiteIPS: "localhost"}).AllowClientIP("127.0.0.1") {
+		t.Error("app with only invalid white_ips should deny")
+	}
+	if invalid := public.NewIPMatcher("10.0.0.1,1.2.3.4.5,fe80::/10,fe80:zz").Invalid(); len(invalid) != 2 {
+		t.Errorf("invalid entries got %v", invalid)
+	}
+	for host, valid := range map[string]bool{"*.test.com": true, "api.test.com": true, "10.0.0.1": true, "10.0.0.0/8": false, "a b": false, "*.": false} {
+		if public.ValidHostRule(host) != valid {
+			t.Errorf("host rule %q valid should be %v", host, valid)
+		}
+	}
+}
+
+func TestHTTPBlackWhiteListMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.SetTrustedProxies(public.GetTrustedProxies())
+	router.Use(func(c *gin.Context) {
+		c.Set("service", &dao.ServiceDetial{
+			Info:          &dao.Serviceinfo{ServiceName: "access_test"},
+			AccessControl: &dao.AcccessControll{WhiteList: "192.0.2.0/24", WhiteHostName: "*.test.com"},
+		})
+		c.Next()
+	}, http_proxy_middleware.HTTPBlackWhiteListMiddleware())
+	router.NoRoute(func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	cases := []struct {
+		remoteAddr string
+		host       string
+		code       int
+	}{
+		{"192.0.2.1:1234", "www.test.com", http.StatusOK},
+		{"198.51.100.1:1234", "www.test.com", http.StatusForbidden},
+		{"192.0.2.1:1234", "www.other.com", http.StatusForbidden},
+	}
+	for _, item := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = item.remoteAddr
+		req.Host = item.host
+		//未配置可信代理时不采信X-Forwarded-For
+		req.Header.Set("X-Forwarded-For", "192.0.2.9")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != item.code {
+			t.Errorf("%s %s got status %d want %d", item.remoteAddr, item.host, w.Code, item.code)
+		}
+	}
+}
+
+func TestResolveClientIP(t *testing.T) {
+	header := func(name string) string {
+		if name == "X-Forwarded-For" {
+			return "203.0.113.7, 10.0.0.2"
+		}
+		return ""
+	}
+	resolver := public.NewClientIPResolver([]string{"10.0.0.0/8"}, []string{"X-Forwarded-For"})
+	cases := []struct {
+		remoteIP string
+		want     string
+	}{
+		{"10.0.0.1", "203.0.113.7"},
+		{"198.51.100.1", "198.51.100.1"},
+	}
+	for _, item := range cases {
+		if ip := resolver.Resolve(item.remoteIP, header); ip != item.want {
+			t.Errorf("remote %s got %s want %s", item.remoteIP, ip, item.want)
+		}
+	}
+	//dev配置未设置可信代理
+	if ip := public.ResolveClientIP("10.0.0.1", header); ip != "10.0.0.1" {
+		t.Errorf("untrusted remote got %s", ip)
+	}
+}
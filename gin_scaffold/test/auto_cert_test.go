@@ -0,0 +1,178 @@
+package test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"gin_scaffold/dao"
+	"gin_scaffold/public"
+	"os"
+	"testing"
+	"time"
+)
+
+func newTestCertIssuer(t *testing.T) (*dao.CertIssuer, *x509.CertPool) {
+	ca, caKey := issueTestCert(t, nil, "auto cert ca", x509.ExtKeyUsageAny)
+	issuer, err := dao.NewCertIssuer(ca.pem, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	return issuer, roots
+}
+
+func TestCertIssuerIssue(t *testing.T) {
+	issuer, roots := newTestCertIssuer(t)
+	cert, err := issuer.Issue("www.auto.test", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Source != dao.CertSourceAuto || cert.Domains != "www.auto.test" {
+		t.Fatalf("issued cert source %d domains %s", cert.Source, cert.Domains)
+	}
+	keyPair, err := cert.LoadKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//证书链附带CA证书
+	if len(keyPair.Certificate) != 2 {
+		t.Errorf("chain length %d, want 2", len(keyPair.Certificate))
+	}
+	if _, err := keyPair.Leaf.Verify(x509.VerifyOptions{DNSName: "www.auto.test", Roots: roots}); err != nil {
+		t.Errorf("issued certificate should verify: %v", err)
+	}
+	if _, err := keyPair.Leaf.Verify(x509.VerifyOptions{DNSName: "other.auto.test", Roots: roots}); err == nil {
+		t.Error("issued certificate should only cover its domain")
+	}
+}
+
+// 仓库自带的CA为RSA PKCS1私钥
+func loadShippedCertIssuer(t *testing.T) *dao.CertIssuer {
+	caCertPem, err := os.ReadFile("../cert_file/ca.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	caKeyPem, err := os.ReadFile("../cert_file/ca.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := dao.NewCertIssuer(caCertPem, caKeyPem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return issuer
+}
+
+func TestCertIssuerShippedCA(t *testing.T) {
+	issuer := loadShippedCertIssuer(t)
+	if _, err := issuer.Issue("shipped.auto.test", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setTestServices(services ...*dao.ServiceDetial) {
+	serviceMap := map[string]*dao.ServiceDetial{}
+	for _, service := range services {
+		serviceMap[service.Info.ServiceName] = service
+	}
+	dao.ServiceManagerHandler.Locker.Lock()
+	dao.ServiceManagerHandler.ServiceMap = serviceMap
+	dao.ServiceManagerHandler.ServiceSlice = services
+	dao.ServiceManagerHandler.Locker.Unlock()
+}
+
+// 域名接入的服务没有证书时握手过程中签发，不依赖redis和mysql
+func TestAutoCertOnDemand(t *testing.T) {
+	issuer, roots := newTestCertIssuer(t)
+	dao.AutoCertManagerHandler.SetIssuer(issuer)
+	defer dao.AutoCertManagerHandler.SetIssuer(nil)
+	setTestServices(&dao.ServiceDetial{
+		Info:     &dao.Serviceinfo{ServiceName: "auto_cert_test", LoadType: public.LoadTypeHTTP},
+		HTTPRule: &dao.HttpRule{RuleType: public.HTTPRuleTypeDomain, Rule: "ondemand.auto.test"},
+	})
+	defer setTestServices()
+	setTestCerts(nil)
+	defer setTestCerts(nil)
+
+	cert, err := dao.CertManagerHandler.GetCertificate(&tls.ClientHelloInfo{ServerName: "ondemand.auto.test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: "ondemand.auto.test", Roots: roots}); err != nil {
+		t.Errorf("on demand certificate should verify: %v", err)
+	}
+	if dao.CertManagerHandler.Lookup("ondemand.auto.test") != cert {
+		t.Error("issued certificate should be served by later handshakes")
+	}
+	//不是域名接入规则的域名不签发
+	if _, err := dao.CertManagerHandler.GetCertificate(&tls.ClientHelloInfo{ServerName: "unknown.auto.test"}); err == nil {
+		t.Error("unknown domain should not get a certificate")
+	}
+}
+
+// 测试CA只有一天有效期，续期使用长期有效的自带CA
+func TestAutoCertRenewAll(t *testing.T) {
+	issuer := loadShippedCertIssuer(t)
+	dao.AutoCertManagerHandler.SetIssuer(issuer)
+	defer dao.AutoCertManagerHandler.SetIssuer(nil)
+	setTestServices(&dao.ServiceDetial{
+		Info:     &dao.Serviceinfo{ServiceName: "auto_cert_renew_test", LoadType: public.LoadTypeHTTP},
+		HTTPRule: &dao.HttpRule{RuleType: public.HTTPRuleTypeDomain, Rule: "renew.auto.test"},
+	})
+	defer setTestServices()
+	//即将过期的证书在检查时续期
+	expiring, err := issuer.Issue("renew.auto.test", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPair, err := expiring.LoadKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	setTestCerts(nil, keyPair)
+	defer setTestCerts(nil)
+	dao.AutoCertManagerHandler.RenewAll()
+	renewed := dao.CertManagerHandler.Lookup("renew.auto.test")
+	if renewed == nil || !renewed.Leaf.NotAfter.After(keyPair.Leaf.NotAfter.Add(24*time.Hour)) {
+		t.Fatal("expiring certificate should be renewed")
+	}
+	//有效期充足时不重复签发
+	dao.AutoCertManagerHandler.RenewAll()
+	if dao.CertManagerHandler.Lookup("renew.auto.test") != renewed {
+		t.Error("fresh certificate should not be reissued")
+	}
+}
+
+// 上传的证书优先，自动签发的证书不替换上传证书覆盖的域名
+func TestAutoCertKeepsUploadedCert(t *testing.T) {
+	issuer := loadShippedCertIssuer(t)
+	dao.AutoCertManagerHandler.SetIssuer(issuer)
+	defer dao.AutoCertManagerHandler.SetIssuer(nil)
+	setTestServices(&dao.ServiceDetial{
+		Info:     &dao.Serviceinfo{ServiceName: "auto_cert_uploaded_test", LoadType: public.LoadTypeHTTP},
+		HTTPRule: &dao.HttpRule{RuleType: public.HTTPRuleTypeDomain, Rule: "x.uploaded.auto.test"},
+	})
+	defer setTestServices()
+	uploaded := loadTestCert(t, "uploaded", "*.uploaded.auto.test")
+	setTestCerts(nil, uploaded)
+	setTestUploadedCerts(uploaded)
+	defer setTestCerts(nil)
+
+	//上传的证书即将过期也不续期
+	dao.AutoCertManagerHandler.RenewAll()
+	if dao.CertManagerHandler.Lookup("x.uploaded.auto.test") != uploaded {
+		t.Fatal("uploaded certificate should not be renewed")
+	}
+	auto, err := issuer.Issue("x.uploaded.auto.test", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPair, err := auto.LoadKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dao.CertManagerHandler.AddCert(keyPair)
+	if dao.CertManagerHandler.Lookup("x.uploaded.auto.test") != uploaded {
+		t.Error("auto certificate should not replace uploaded certificate")
+	}
+}
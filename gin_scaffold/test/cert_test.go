@@ -0,0 +1,182 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"gin_scaffold/dao"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// 生成自签名证书，返回pem格式的证书与私钥
+func genTestCert(t *testing.T, cn string, dnsNames ...string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func loadTestCert(t *testing.T, cn string, dnsNames ...string) *tls.Certificate {
+	certPem, keyPem := genTestCert(t, cn, dnsNames...)
+	item := &dao.Cert{StoreType: dao.CertStoreTypeDB, CertPem: string(certPem), KeyPem: string(keyPem)}
+	keyPair, err := item.LoadKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyPair
+}
+
+// 测试证书都按自动签发处理，上传的证书用setTestUploadedCerts标记
+func setTestCerts(defaultCert *tls.Certificate, certs ...*tls.Certificate) {
+	certMap := map[string]*tls.Certificate{}
+	wildcardMap := map[string]*tls.Certificate{}
+	for _, cert := range certs {
+		for _, domain := range dao.CertDomains(cert.Leaf) {
+			if domain[0] == '*' {
+				wildcardMap[domain[1:]] = cert
+				continue
+			}
+			certMap[domain] = cert
+		}
+	}
+	dao.CertManagerHandler.Locker.Lock()
+	dao.CertManagerHandler.CertMap = certMap
+	dao.CertManagerHandler.WildcardMap = wildcardMap
+	dao.CertManagerHandler.UploadedCerts = map[*tls.Certificate]bool{}
+	dao.CertManagerHandler.DefaultCert = defaultCert
+	dao.CertManagerHandler.Locker.Unlock()
+}
+
+func setTestUploadedCerts(certs ...*tls.Certificate) {
+	uploadedCerts := map[*tls.Certificate]bool{}
+	for _, cert := range certs {
+		uploadedCerts[cert] = true
+	}
+	dao.CertManagerHandler.Locker.Lock()
+	dao.CertManagerHandler.UploadedCerts = uploadedCerts
+	dao.CertManagerHandler.Locker.Unlock()
+}
+
+func TestCertDomains(t *testing.T) {
+	keyPair := loadTestCert(t, "ignored.test.com", "A.test.com", "*.wild.test.com")
+	domains := dao.CertDomains(keyPair.Leaf)
+	if len(domains) != 2 || domains[0] != "a.test.com" || domains[1] != "*.wild.test.com" {
+		t.Fatalf("domains = %v", domains)
+	}
+	//没有SAN时使用CN
+	keyPair = loadTestCert(t, "cn.test.com")
+	if domains := dao.CertDomains(keyPair.Leaf); len(domains) != 1 || domains[0] != "cn.test.com" {
+		t.Fatalf("domains = %v", domains)
+	}
+	cases := []struct {
+		certDomain string
+		host       string
+		match      bool
+	}{
+		{"a.test.com", "A.test.com", true},
+		{"*.wild.test.com", "x.wild.test.com", true},
+		{"*.wild.test.com", "wild.test.com", false},
+		{"*.wild.test.com", "x.y.wild.test.com", false},
+	}
+	for _, item := range cases {
+		if got := dao.CertDomainMatch(item.certDomain, item.host); got != item.match {
+			t.Errorf("CertDomainMatch(%q, %q) = %v, want %v", item.certDomain, item.host, got, item.match)
+		}
+	}
+}
+
+func TestCertPairMismatch(t *testing.T) {
+	certPem, _ := genTestCert(t, "a.test.com", "a.test.com")
+	_, keyPem := genTestCert(t, "b.test.com", "b.test.com")
+	item := &dao.Cert{StoreType: dao.CertStoreTypeDB, CertPem: string(certPem), KeyPem: string(keyPem)}
+	if _, err := item.LoadKeyPair(); err == nil {
+		t.Fatal("mismatched key pair should fail")
+	}
+}
+
+func TestCertManagerGetCertificate(t *testing.T) {
+	defer setTestCerts(nil)
+	exact := loadTestCert(t, "a.test.com", "a.test.com")
+	wildcard := loadTestCert(t, "wild", "*.wild.test.com")
+	defaultCert := loadTestCert(t, "default", "default.test.com")
+	setTestCerts(defaultCert, exact, wildcard)
+	cases := []struct {
+		serverName string
+		want       *tls.Certificate
+	}{
+		{"a.test.com", exact},
+		{"A.TEST.COM.", exact},
+		{"x.wild.test.com", wildcard},
+		{"wild.test.com", defaultCert},
+		{"", defaultCert},
+	}
+	for _, item := range cases {
+		got, err := dao.CertManagerHandler.GetCertificate(&tls.ClientHelloInfo{ServerName: item.serverName})
+		if err != nil || got != item.want {
+			t.Errorf("GetCertificate(%q) = %v, %v", item.serverName, got, err)
+		}
+	}
+	setTestCerts(nil, exact)
+	if _, err := dao.CertManagerHandler.GetCertificate(&tls.ClientHelloInfo{ServerName: "b.test.com"}); err == nil {
+		t.Fatal("no matching certificate and no default should fail")
+	}
+}
+
+// 替换证书后新的握手立即使用新证书，不需要重启监听
+func TestCertManagerHotReload(t *testing.T) {
+	defer setTestCerts(nil)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{GetCertificate: dao.CertManagerHandler.GetCertificate}
+	server.StartTLS()
+	defer server.Close()
+
+	handshake := func() *x509.Certificate {
+		conn, err := tls.Dial("tcp", server.Listener.Addr().String(), &tls.Config{
+			ServerName:         "a.test.com",
+			InsecureSkipVerify: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0]
+	}
+	first := loadTestCert(t, "first", "a.test.com")
+	setTestCerts(nil, first)
+	if got := handshake(); got.Subject.CommonName != "first" {
+		t.Fatalf("got certificate %s, want first", got.Subject.CommonName)
+	}
+	second := loadTestCert(t, "second", "a.test.com")
+	setTestCerts(nil, second)
+	if got := handshake(); got.Subject.CommonName != "second" {
+		t.Fatalf("got certificate %s, want second", got.Subject.CommonName)
+	}
+}
@@ -0,0 +1,270 @@
+package test
+
+import (
+	"gin_scaffold/dao"
+	"gin_scaffold/http_proxy_middleware"
+	"gin_scaffold/reverse_proxy/load_balance"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCircuitBreakerErrorRate(t *testing.T) {
+	changes := []string{}
+	breaker := load_balance.NewCircuitBreaker(load_balance.CircuitBreakerConf{
+		MinRequests: 4,
+		ErrorRate:   50,
+		OpenTime:    50 * time.Millisecond,
+		HalfOpenMax: 2,
+	}, func(from, to load_balance.BreakerState) {
+		changes = append(changes, from.String()+"->"+to.String())
+	})
+	report := func(failed bool) {
+		generation, ok := breaker.Allow()
+		if !ok {
+			t.Fatal("request should be allowed")
+		}
+		breaker.Report(generation, failed, 0)
+	}
+	report(false)
+	report(false)
+	report(true)
+	if breaker.State() != load_balance.BreakerClosed {
+		t.Fatal("breaker should stay closed below min requests")
+	}
+	report(true)
+	if _, ok := breaker.Allow(); ok || breaker.State() != load_balance.BreakerOpen {
+		t.Fatal("breaker should open at error rate threshold")
+	}
+	//半开状态只放行有限的探测请求，任一失败重新打开
+	time.Sleep(60 * time.Millisecond)
+	first, ok1 := breaker.Allow()
+	_, ok2 := breaker.Allow()
+	if _, ok3 := breaker.Allow(); !ok1 || !ok2 || ok3 {
+		t.Fatal("half open breaker should allow exactly 2 probes")
+	}
+	breaker.Report(first, true, 0)
+	if breaker.State() != load_balance.BreakerOpen {
+		t.Fatal("failed probe should reopen breaker")
+	}
+	//探测全部成功后关闭
+	time.Sleep(60 * time.Millisecond)
+	report(false)
+	report(false)
+	if breaker.State() != load_balance.BreakerClosed {
+		t.Fatal("successful probes should close breaker")
+	}
+	want := "closed->open,open->half_open,half_open->open,open->half_open,half_open->closed"
+	if got := strings.Join(changes, ","); got != want {
+		t.Errorf("state changes got %s, want %s", got, want)
+	}
+}
+
+func TestCircuitBreakerSlowCall(t *testing.T) {
+	breaker := load_balance.NewCircuitBreaker(load_balance.CircuitBreakerConf{
+		MinRequests:      3,
+		SlowCallRate:     60,
+		SlowCallDuration: 100 * time.Millisecond,
+	}, nil)
+	generation, _ := breaker.Allow()
+	breaker.Report(generation, false, 200*time.Millisecond)
+	generation, _ = breaker.Allow()
+	breaker.Report(generation, false, 50*time.Millisecond)
+	if breaker.State() != load_balance.BreakerClosed {
+		t.Fatal("breaker should stay closed below min requests")
+	}
+	//打开前发出的请求结果不影响新状态
+	stale, _ := breaker.Allow()
+	generation, _ = breaker.Allow()
+	breaker.Report(generation, false, 200*time.Millisecond)
+	if breaker.State() != load_balance.BreakerOpen {
+		t.Fatal("breaker should open at slow call rate threshold")
+	}
+	breaker.Report(stale, false, 0)
+	if breaker.State() != load_balance.BreakerOpen {
+		t.Fatal("stale report should be ignored")
+	}
+}
+
+// 失败节点熔断后流量只转发到正常节点，全部节点失败时服务熔断并返回降级内容
+func TestHTTPCircuitBreakerMiddleware(t *testing.T) {
+	var failing sync.Map
+	newUpstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := failing.Load(name); ok {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			w.Write([]byte(name))
+		}))
+	}
+	upstreamA, upstreamB := newUpstream("a"), newUpstream("b")
+	defer upstreamA.Close()
+	defer upstreamB.Close()
+	newService := func(name string) *dao.ServiceDetial {
+		return &dao.ServiceDetial{
+			Info: &dao.Serviceinfo{ServiceName: name},
+			LoadBalance: &dao.LoadBalance{
+				IpList: strings.TrimPrefix(upstreamA.URL, "http://") + "," + strings.TrimPrefix(upstreamB.URL, "http://"),
+			},
+			CircuitBreaker: &dao.CircuitBreakerRule{
+				MinRequests:         3,
+				ErrorRate:           100,
+				OpenTime:            60,
+				FallbackStatus:      http.StatusOK,
+				FallbackContentType: "text/plain",
+				FallbackBody:        "fallback",
+			},
+		}
+	}
+	service := newService("circuit_breaker_node_test")
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("service", service)
+		c.Next()
+	}, http_proxy_middleware.HTTPCircuitBreakerMiddleware(), http_proxy_middleware.HTTPReverseProxyMiddleware())
+	proxy := httptest.NewServer(router)
+	defer proxy.Close()
+	get := func() string {
+		resp, err := http.Get(proxy.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	failing.Store("b", true)
+	for i := 0; i < 6; i++ {
+		get()
+	}
+	for i := 0; i < 4; i++ {
+		if body := get(); body != "a" {
+			t.Fatalf("request %d after node b opened got %q", i, body)
+		}
+	}
+	service = newService("circuit_breaker_service_test")
+	failing.Store("a", true)
+	for i := 0; i < 3; i++ {
+		get()
+	}
+	if body := get(); body != "fallback" {
+		t.Errorf("open service breaker should return fallback, got %q", body)
+	}
+	if dao.CircuitBreakerHandler.GetItem(service).Service().State() != load_balance.BreakerOpen {
+		t.Error("service breaker should be open")
+	}
+}
+
+// 节点移出ip_list后丢弃其熔断器，重新加入时从关闭状态开始
+func TestCircuitBreakerPruneNodes(t *testing.T) {
+	newService := func(ipList string) *dao.ServiceDetial {
+		return &dao.ServiceDetial{
+			Info:           &dao.Serviceinfo{ServiceName: "circuit_breaker_prune_test"},
+			LoadBalance:    &dao.LoadBalance{IpList: ipList},
+			CircuitBreaker: &dao.CircuitBreakerRule{MinRequests: 1, ErrorRate: 100, OpenTime: 60},
+		}
+	}
+	service := newService("127.0.0.1:1,127.0.0.1:2")
+	defer dao.CircuitBreakerHandler.OnServiceChange(&dao.ServiceChange{Removed: []*dao.ServiceDetial{service}})
+	item := dao.CircuitBreakerHandler.GetItem(service)
+	for _, addr := range []string{"127.0.0.1:1", "127.0.0.1:2"} {
+		breaker := item.Node(addr)
+		generation, _ := breaker.Allow()
+		breaker.Report(generation, true, 0)
+		if breaker.State() != load_balance.BreakerOpen {
+			t.Fatalf("node %s breaker should be open", addr)
+		}
+	}
+	service = newService("127.0.0.1:1")
+	dao.CircuitBreakerHandler.OnServiceChange(&dao.ServiceChange{Updated: []*dao.ServiceDetial{service}})
+	if dao.CircuitBreakerHandler.GetItem(service) != item {
+		t.Fatal("service breaker should be kept when the rule is unchanged")
+	}
+	if item.Node("127.0.0.1:1").State() != load_balance.BreakerOpen {
+		t.Error("remaining node breaker should be kept")
+	}
+	if item.Node("127.0.0.1:2").State() != load_balance.BreakerClosed {
+		t.Error("removed node breaker should be dropped")
+	}
+}
+
+// 一致性hash保持key与节点的对应关系，选中的节点熔断时直接降级
+func TestHTTPCircuitBreakerConsistentHash(t *testing.T) {
+	hits := map[string]int{}
+	var locker sync.Mutex
+	newUpstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			locker.Lock()
+			hits[name]++
+			locker.Unlock()
+			w.Write([]byte(name))
+		}))
+	}
+	upstreamA, upstreamB := newUpstream("a"), newUpstream("b")
+	defer upstreamA.Close()
+	defer upstreamB.Close()
+	addrs := map[string]string{
+		"a": strings.TrimPrefix(upstreamA.URL, "http://"),
+		"b": strings.TrimPrefix(upstreamB.URL, "http://"),
+	}
+	service := &dao.ServiceDetial{
+		Info: &dao.Serviceinfo{ServiceName: "circuit_breaker_hash_test"},
+		LoadBalance: &dao.LoadBalance{
+			RoundType: int(load_balance.LbConsistentHash),
+			HashKey:   "header:X-User",
+			IpList:    addrs["a"] + "," + addrs["b"],
+		},
+		CircuitBreaker: &dao.CircuitBreakerRule{
+			MinRequests:         1,
+			ErrorRate:           50,
+			OpenTime:            60,
+			FallbackStatus:      http.StatusOK,
+			FallbackContentType: "text/plain",
+			FallbackBody:        "fallback",
+		},
+	}
+	defer dao.CircuitBreakerHandler.OnServiceChange(&dao.ServiceChange{Removed: []*dao.ServiceDetial{service}})
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("service", service)
+		c.Next()
+	}, http_proxy_middleware.HTTPCircuitBreakerMiddleware(), http_proxy_middleware.HTTPReverseProxyMiddleware())
+	proxy := httptest.NewServer(router)
+	defer proxy.Close()
+	get := func() string {
+		req, _ := http.NewRequest(http.MethodGet, proxy.URL, nil)
+		req.Header.Set("X-User", "hash_user")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	chosen := get()
+	if addrs[chosen] == "" {
+		t.Fatalf("unexpected response %q", chosen)
+	}
+	breaker := dao.CircuitBreakerHandler.GetItem(service).Node(addrs[chosen])
+	generation, _ := breaker.Allow()
+	breaker.Report(generation, true, 0)
+	if body := get(); body != "fallback" {
+		t.Errorf("open node breaker should return fallback, got %q", body)
+	}
+	locker.Lock()
+	defer locker.Unlock()
+	if len(hits) != 1 {
+		t.Errorf("requests should stay on node %s, hits %v", chosen, hits)
+	}
+}
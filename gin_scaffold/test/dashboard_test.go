@@ -0,0 +1,31 @@
+package test
+
+import (
+	"encoding/json"
+	"gin_scaffold/controller"
+	"gin_scaffold/middleware"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 统计服务或租户时必须指定id，否则会查到任意一条记录
+func TestFlowStatRangeRequiresID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.TranslationMiddleware())
+	controller.DashboardRegister(router.Group("/dashboard"))
+	for _, query := range []string{"stat_type=1", "stat_type=2&id=0"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/dashboard/flow_stat_range?start_date=2024-03-04&end_date=2024-03-05&"+query, nil))
+		resp := struct {
+			Errno int `json:"errno"`
+		}{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if resp.Errno != 2001 {
+			t.Errorf("%s got errno %d", query, resp.Errno)
+		}
+	}
+}
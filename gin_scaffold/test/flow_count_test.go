@@ -0,0 +1,105 @@
+package test
+
+import (
+	"gin_scaffold/dao"
+	"gin_scaffold/http_proxy_middleware"
+	"gin_scaffold/public"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestFlowCounterKey(t *testing.T) {
+	counter, _ := public.FlowCounterHandler.GetCounter("key_test")
+	if same, _ := public.FlowCounterHandler.GetCounter("key_test"); same != counter {
+		t.Error("counter should be cached by name")
+	}
+	at := time.Date(2024, 3, 5, 7, 30, 0, 0, time.Local)
+	if key := counter.GetDayKey(at); key != public.RedisFlowDayKey+"_20240305_key_test" {
+		t.Errorf("day key got %s", key)
+	}
+	if key := counter.GetHourKey(at); key != public.RedisFlowHourKey+"_2024030507_key_test" {
+		t.Errorf("hour key got %s", key)
+	}
+}
+
+// dashboard只读redis，不创建带刷新协程的计数器
+func TestQueryFlowCount(t *testing.T) {
+	public.QueryFlowCount("query_test", time.Now())
+	for _, counter := range public.FlowCounterHandler.GetCounterList() {
+		if counter.Name == "query_test" {
+			t.Fatal("query should not create counter")
+		}
+	}
+}
+
+func TestHTTPFlowCountMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("service", &dao.ServiceDetial{
+			Info: &dao.Serviceinfo{ServiceName: "flow_count_test"},
+		})
+		c.Next()
+	}, http_proxy_middleware.HTTPFlowCountMiddleware())
+	router.NoRoute(func(c *gin.Context) {
+		c.Set("app", &dao.App{AppID: "flow_count_app"})
+		c.Status(http.StatusOK)
+	})
+	serviceCounter, _ := public.FlowCounterHandler.GetCounter(public.FlowServicePrefix + "flow_count_test")
+	appCounter, _ := public.FlowCounterHandler.GetCounter(public.FlowAppPrefix + "flow_count_app")
+	serviceBefore := atomic.LoadInt64(&serviceCounter.TickerCount)
+	appBefore := atomic.LoadInt64(&appCounter.TickerCount)
+	//redis未初始化时计数保留在本地
+	for i := 0; i < 3; i++ {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	if count := atomic.LoadInt64(&serviceCounter.TickerCount) - serviceBefore; count != 3 {
+		t.Errorf("service count got %d", count)
+	}
+	if count := atomic.LoadInt64(&appCounter.TickerCount) - appBefore; count != 3 {
+		t.Errorf("app count got %d", count)
+	}
+}
+
+func TestFlowLatencyPercentile(t *testing.T) {
+	cases := []struct {
+		counts []int64
+		want   int64
+	}{
+		{[]int64{}, 0},
+		{[]int64{100}, 5},
+		{[]int64{94, 0, 6}, 25},
+		{[]int64{95, 0, 5}, 5},
+		{[]int64{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 10}, 10000},
+	}
+	for _, item := range cases {
+		if got := public.FlowLatencyPercentile(item.counts, 0.95); got != item.want {
+			t.Errorf("p95 of %v got %d want %d", item.counts, got, item.want)
+		}
+	}
+}
+
+func TestHTTPFlowCountError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("service", &dao.ServiceDetial{
+			Info: &dao.Serviceinfo{ServiceName: "flow_count_error_test"},
+		})
+		c.Next()
+	}, http_proxy_middleware.HTTPFlowCountMiddleware())
+	router.NoRoute(func(c *gin.Context) {
+		c.Status(http.StatusBadGateway)
+	})
+	counter, _ := public.FlowCounterHandler.GetCounter(public.FlowServicePrefix + "flow_count_error_test")
+	before := atomic.LoadInt64(&counter.ErrorCount)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if count := atomic.LoadInt64(&counter.ErrorCount) - before; count != 1 {
+		t.Errorf("error count got %d", count)
+	}
+}
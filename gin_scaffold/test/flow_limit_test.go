@@ -0,0 +1,113 @@
+package test
+
+import (
+	"encoding/json"
+	"gin_scaffold/dao"
+	"gin_scaffold/http_proxy_middleware"
+	"gin_scaffold/middleware"
+	"gin_scaffold/public"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestFlowLimiter(t *testing.T) {
+	limiter := public.NewFlowLimiter()
+	if ok, _ := limiter.Allow("test", 0); !ok {
+		t.Error("qps=0 should not limit")
+	}
+	if ok, _ := limiter.Allow("test", 1); !ok {
+		t.Error("first request should pass")
+	}
+	ok, delay := limiter.Allow("test", 1)
+	if ok || delay <= 0 {
+		t.Errorf("second request got ok=%v delay=%v", ok, delay)
+	}
+	//reload后调整速率，不重建令牌桶
+	before := limiter.GetLimiter("test", 1)
+	if after := limiter.GetLimiter("test", 100); after != before || after.Burst() != 100 {
+		t.Error("limiter not updated in place")
+	}
+}
+
+func TestHTTPFlowLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("service", &dao.ServiceDetial{
+			Info:          &dao.Serviceinfo{ServiceName: "flow_limit_test"},
+			AccessControl: &dao.AcccessControll{ServiceFlowLimit: 1},
+		})
+		c.Next()
+	}, http_proxy_middleware.HTTPFlowLimitMiddleware())
+	router.NoRoute(func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	codes := []int{http.StatusOK, http.StatusTooManyRequests}
+	for i, code := range codes {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != code {
+			t.Fatalf("request %d status got %d want %d", i, w.Code, code)
+		}
+		if code != http.StatusTooManyRequests {
+			continue
+		}
+		if w.Header().Get("Retry-After") != "1" {
+			t.Errorf("retry-after got %q", w.Header().Get("Retry-After"))
+		}
+		resp := &middleware.Response{}
+		if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil || resp.ErrorCode != 2008 {
+			t.Errorf("body got %s err %v", w.Body.String(), err)
+		}
+	}
+}
+
+// 被客户端ip限流拒绝的请求不占用服务整体的配额
+func TestHTTPFlowLimitClientIPFirst(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("service", &dao.ServiceDetial{
+			Info:          &dao.Serviceinfo{ServiceName: "flow_limit_ip_first_test"},
+			AccessControl: &dao.AcccessControll{ServiceFlowLimit: 2, ClientIPFlowLimit: 1},
+		})
+		c.Next()
+	}, http_proxy_middleware.HTTPFlowLimitMiddleware())
+	router.NoRoute(func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	requests := []struct {
+		remoteAddr string
+		code       int
+	}{
+		{"192.0.2.1:1234", http.StatusOK},
+		{"192.0.2.1:1234", http.StatusTooManyRequests},
+		{"192.0.2.2:1234", http.StatusOK},
+	}
+	for i, item := range requests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = item.remoteAddr
+		router.ServeHTTP(w, req)
+		if w.Code != item.code {
+			t.Fatalf("request %d from %s status got %d want %d", i, item.remoteAddr, w.Code, item.code)
+		}
+	}
+}
+
+func TestRedisFlowLimiterFallback(t *testing.T) {
+	//测试环境未初始化redis，应退化为单机令牌桶
+	for _, limitType := range []int{public.FlowLimitTypeRedisSlidingWindow, public.FlowLimitTypeRedisGCRA} {
+		name := "redis_fallback_test_" + strconv.Itoa(limitType)
+		if ok, _ := public.AllowFlow(name, 1, limitType); !ok {
+			t.Errorf("type %d first request should pass", limitType)
+		}
+		if ok, _ := public.AllowFlow(name, 1, limitType); ok {
+			t.Errorf("type %d second request should be limited", limitType)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package test
+
+import (
+	"context"
+	"gin_scaffold/dao"
+	"gin_scaffold/public"
+	"gin_scaffold/reverse_proxy"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestGrpcReverseProxy(t *testing.T) {
+	//下游使用官方health服务，代理侧不依赖任何proto
+	upstreamLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(upstream, health.NewServer())
+	go upstream.Serve(upstreamLis)
+	defer upstream.Stop()
+
+	service := &dao.ServiceDetial{
+		Info:        &dao.Serviceinfo{ServiceName: "grpc_test"},
+		LoadBalance: &dao.LoadBalance{IpList: upstreamLis.Addr().String(), WeightList: "1"},
+	}
+	lb, err := dao.NewLoadBalanceByModel(service.LoadBalance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := grpc.NewServer(
+		grpc.ForceServerCodec(reverse_proxy.GrpcCodec),
+		grpc.UnknownServiceHandler(reverse_proxy.NewGrpcLoadBalanceHandler(service, lb)))
+	go proxy.Serve(proxyLis)
+	defer proxy.Stop()
+
+	conn, err := grpc.Dial(proxyLis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := grpc_health_v1.NewHealthClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	//一元调用
+	resp, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	if err != nil || resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Fatalf("check got %v err %v", resp, err)
+	}
+	//服务端流
+	stream, err := client.Watch(ctx, &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := stream.Recv(); err != nil || resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Fatalf("watch got %v err %v", resp, err)
+	}
+}
+
+// 测试用的原始字节编解码器，客户端与下游都不依赖proto
+type grpcBytesCodec struct{}
+
+func (grpcBytesCodec) Marshal(v interface{}) ([]byte, error) {
+	return *v.(*[]byte), nil
+}
+
+func (grpcBytesCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*[]byte) = append([]byte{}, data...)
+	return nil
+}
+
+func (grpcBytesCodec) Name() string {
+	return "bytes"
+}
+
+// Sum把收到的消息拼接后返回一条，Chat逐条回显，Empty只返回header不返回消息
+func grpcStreamUpstream(srv interface{}, stream grpc.ServerStream) error {
+	method, _ := grpc.MethodFromServerStream(stream)
+	switch method {
+	case "/test.Stream/Sum":
+		sum := []byte{}
+		for {
+			msg := []byte{}
+			if err := stream.RecvMsg(&msg); err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+			sum = append(sum, msg...)
+		}
+		stream.SetHeader(metadata.Pairs("x-upstream", "sum"))
+		return stream.SendMsg(&sum)
+	case "/test.Stream/Chat":
+		for {
+			msg := []byte{}
+			if err := stream.RecvMsg(&msg); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			reply := append([]byte("echo:"), msg...)
+			if err := stream.SendMsg(&reply); err != nil {
+				return err
+			}
+		}
+	case "/test.Stream/Empty":
+		stream.SendHeader(metadata.Pairs("x-upstream", "empty"))
+		return status.Error(codes.NotFound, "nothing")
+	}
+	return status.Error(codes.Unimplemented, method)
+}
+
+func TestGrpcStreamingProxy(t *testing.T) {
+	upstreamLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := grpc.NewServer(
+		grpc.ForceServerCodec(grpcBytesCodec{}),
+		grpc.UnknownServiceHandler(grpcStreamUpstream))
+	go upstream.Serve(upstreamLis)
+	defer upstream.Stop()
+
+	service := &dao.ServiceDetial{
+		Info:        &dao.Serviceinfo{ServiceName: "grpc_stream_test", LoadType: public.LoadTypeGrpc},
+		LoadBalance: &dao.LoadBalance{IpList: upstreamLis.Addr().String(), WeightList: "1"},
+	}
+	lb, err := dao.NewLoadBalanceByModel(service.LoadBalance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := grpc.NewServer(
+		grpc.ForceServerCodec(reverse_proxy.GrpcCodec),
+		grpc.UnknownServiceHandler(reverse_proxy.NewGrpcLoadBalanceHandler(service, lb)))
+	go proxy.Serve(proxyLis)
+	defer proxy.Stop()
+
+	conn, err := grpc.Dial(proxyLis.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(grpcBytesCodec{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	desc := &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}
+
+	//客户端流
+	stream, err := conn.NewStream(ctx, desc, "/test.Stream/Sum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []string{"a", "b", "c"} {
+		msg := []byte(part)
+		if err := stream.SendMsg(&msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stream.CloseSend()
+	reply := []byte{}
+	if err := stream.RecvMsg(&reply); err != nil || string(reply) != "abc" {
+		t.Fatalf("sum got %q err %v", reply, err)
+	}
+	if md, _ := stream.Header(); len(md.Get("x-upstream")) == 0 || md.Get("x-upstream")[0] != "sum" {
+		t.Errorf("sum header got %v", md)
+	}
+
+	//双向流，每发一条收一条
+	stream, err = conn.NewStream(ctx, desc, "/test.Stream/Chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []string{"x", "y"} {
+		msg := []byte(part)
+		if err := stream.SendMsg(&msg); err != nil {
+			t.Fatal(err)
+		}
+		reply := []byte{}
+		if err := stream.RecvMsg(&reply); err != nil || string(reply) != "echo:"+part {
+			t.Fatalf("chat got %q err %v", reply, err)
+		}
+	}
+	stream.CloseSend()
+	if err := stream.RecvMsg(&reply); err != io.EOF {
+		t.Errorf("chat should end with EOF, got %v", err)
+	}
+
+	//下游没有返回消息时header和状态码也要透传
+	stream, err = conn.NewStream(ctx, desc, "/test.Stream/Empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.CloseSend()
+	if err := stream.RecvMsg(&reply); status.Code(err) != codes.NotFound {
+		t.Errorf("empty got err %v", err)
+	}
+	if md, _ := stream.Header(); len(md.Get("x-upstream")) == 0 || md.Get("x-upstream")[0] != "empty" {
+		t.Errorf("empty header got %v", md)
+	}
+
+	//服务仍在使用时保留下游连接，服务删除后释放
+	setTestServices(service)
+	defer setTestServices()
+	reverse_proxy.OnGrpcServiceChange(&dao.ServiceChange{})
+	if reverse_proxy.GrpcConnCount() == 0 {
+		t.Error("connection of a loaded service should be kept")
+	}
+	setTestServices()
+	reverse_proxy.OnGrpcServiceChange(&dao.ServiceChange{Removed: []*dao.ServiceDetial{service}})
+	if reverse_proxy.GrpcConnCount() != 0 {
+		t.Error("connection of a removed service should be evicted")
+	}
+}
@@ -0,0 +1,232 @@
+package test
+
+import (
+	"encoding/json"
+	"gin_scaffold/dao"
+	"gin_scaffold/http_proxy_middleware"
+	"gin_scaffold/public"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 依次执行中间件，返回转发前的请求
+func runProxyMiddleware(rule *dao.HttpRule, req *http.Request, mw gin.HandlerFunc) *http.Request {
+	gin.SetMode(gin.TestMode)
+	var out *http.Request
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("service", &dao.ServiceDetial{HTTPRule: rule})
+		c.Next()
+	}, mw)
+	router.NoRoute(func(c *gin.Context) {
+		out = c.Request
+	})
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	return out
+}
+
+func TestHTTPStripUriMiddleware(t *testing.T) {
+	tests := []struct {
+		name string
+		rule *dao.HttpRule
+		path string
+		want string
+	}{
+		{"strip prefix", &dao.HttpRule{RuleType: public.HTTPRuleTypefixURL, Rule: "/test_http", NeedStripUri: 1}, "/test_http/abc", "/abc"},
+		{"strip whole path", &dao.HttpRule{RuleType: public.HTTPRuleTypefixURL, Rule: "/test_http", NeedStripUri: 1}, "/test_http", "/"},
+		{"strip disabled", &dao.HttpRule{RuleType: public.HTTPRuleTypefixURL, Rule: "/test_http"}, "/test_http/abc", "/test_http/abc"},
+		{"domain rule", &dao.HttpRule{RuleType: public.HTTPRuleTypeDomain, Rule: "www.test.com", NeedStripUri: 1}, "/abc", "/abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			out := runProxyMiddleware(tt.rule, req, http_proxy_middleware.HTTPStripUriMiddleware())
+			if out.URL.Path != tt.want {
+				t.Errorf("path got %s want %s", out.URL.Path, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPUrlRewriteMiddleware(t *testing.T) {
+	tests := []struct {
+		name    string
+		rewrite string
+		path    string
+		want    string
+	}{
+		{"no rule", "", "/abc", "/abc"},
+		{"capture group", "^/test_http/abb(.*) /test_http/bba$1", "/test_http/abb/ping", "/test_http/bba/ping"},
+		{"not matched", "^/test_http/abb(.*) /test_http/bba$1", "/other/abb", "/other/abb"},
+		{"multi lines", "^/a(.*) /b$1\n^/b(.*) /c$1", "/a/x", "/c/x"},
+		{"invalid line ignored", "^/a(.*\n^/a(.*) /b$1", "/a/x", "/b/x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := &dao.HttpRule{UrlRewrite: tt.rewrite}
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			out := runProxyMiddleware(rule, req, http_proxy_middleware.HTTPUrlRewriteMiddleware())
+			if out.URL.Path != tt.want {
+				t.Errorf("path got %s want %s", out.URL.Path, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPHeaderTransforMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		transfor string
+		header   http.Header
+		want     http.Header
+	}{
+		{"add", "add X-Gateway proxy", http.Header{}, http.Header{"X-Gateway": {"proxy"}}},
+		{"add keeps old value", "add X-Gateway proxy", http.Header{"X-Gateway": {"old"}}, http.Header{"X-Gateway": {"old", "proxy"}}},
+		{"edit", "edit X-Gateway proxy", http.Header{"X-Gateway": {"old"}}, http.Header{"X-Gateway": {"proxy"}}},
+		{"del", "del X-Gateway", http.Header{"X-Gateway": {"old"}}, http.Header{}},
+		{"multi lines", "del X-A\nadd X-B b", http.Header{"X-A": {"a"}}, http.Header{"X-B": {"b"}}},
+		{"unknown op ignored", "set X-A a", http.Header{}, http.Header{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := &dao.HttpRule{HeaderTransfor: tt.transfor}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header = tt.header
+			out := runProxyMiddleware(rule, req, http_proxy_middleware.HTTPHeaderTransforMiddleware())
+			if public.Obj2json(out.Header) != public.Obj2json(tt.want) {
+				t.Errorf("header got %v want %v", out.Header, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPNeedHttpsMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     *dao.HttpRule
+		tls      bool
+		code     int
+		location string
+		hsts     string
+	}{
+		{"http to https service", &dao.HttpRule{NeedHttps: 1}, false, http.StatusMovedPermanently, "https://www.test.com:4433/abc?a=1", ""},
+		{"http to http service", &dao.HttpRule{NeedHttps: 0}, false, http.StatusOK, "", ""},
+		{"https without hsts", &dao.HttpRule{NeedHttps: 1}, true, http.StatusOK, "", ""},
+		{"https with hsts", &dao.HttpRule{NeedHttps: 1, HstsMaxAge: 600, HstsIncludeSubdomains: 1}, true, http.StatusOK, "", "max-age=600; includeSubDomains"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+			router.Use(func(c *gin.Context) {
+				c.Set("service", &dao.ServiceDetial{HTTPRule: tt.rule})
+				c.Next()
+			}, http_proxy_middleware.HTTPNeedHttpsMiddleware())
+			router.NoRoute(func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+			target := "http://www.test.com:8080/abc?a=1"
+			if tt.tls {
+				target = "https://www.test.com/abc?a=1"
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+			if w.Code != tt.code {
+				t.Errorf("status got %d want %d", w.Code, tt.code)
+			}
+			if got := w.Header().Get("Location"); got != tt.location {
+				t.Errorf("location got %s want %s", got, tt.location)
+			}
+			if got := w.Header().Get("Strict-Transport-Security"); got != tt.hsts {
+				t.Errorf("hsts got %s want %s", got, tt.hsts)
+			}
+		})
+	}
+}
+
+// 请求转发到选中的节点，附带X-Forwarded-*并去掉逐跳header；无节点可用和节点不可达时返回对应错误码
+func TestHTTPReverseProxyMiddleware(t *testing.T) {
+	var received *http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		w.Header().Set("X-Upstream", "a")
+		w.Write([]byte("upstream " + r.URL.Path))
+	}))
+	defer upstream.Close()
+	upstreamAddr := strings.TrimPrefix(upstream.URL, "http://")
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closedAddr := strings.TrimPrefix(closed.URL, "http://")
+	closed.Close()
+
+	var service *dao.ServiceDetial
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("service", service)
+		c.Next()
+	}, http_proxy_middleware.HTTPReverseProxyMiddleware())
+	proxy := httptest.NewServer(router)
+	defer proxy.Close()
+	do := func() (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/hello", nil)
+		req.Host = "www.forward.test"
+		req.Header.Set("Connection", "X-Hop")
+		req.Header.Set("X-Hop", "1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+	errno := func(body string) int {
+		out := struct {
+			Errno int `json:"errno"`
+		}{}
+		json.Unmarshal([]byte(body), &out)
+		return out.Errno
+	}
+
+	service = &dao.ServiceDetial{
+		Info:        &dao.Serviceinfo{ServiceName: "forward_test"},
+		LoadBalance: &dao.LoadBalance{IpList: upstreamAddr + "," + closedAddr, ForbidList: closedAddr},
+	}
+	resp, body := do()
+	if resp.StatusCode != http.StatusOK || body != "upstream /hello" || resp.Header.Get("X-Upstream") != "a" {
+		t.Fatalf("forward got %d %q", resp.StatusCode, body)
+	}
+	if got := received.Header.Get("X-Forwarded-Host"); got != "www.forward.test" {
+		t.Errorf("X-Forwarded-Host = %q", got)
+	}
+	if got := received.Header.Get("X-Forwarded-Proto"); got != "http" {
+		t.Errorf("X-Forwarded-Proto = %q", got)
+	}
+	if got := received.Header.Get("X-Forwarded-For"); got != "127.0.0.1" {
+		t.Errorf("X-Forwarded-For = %q", got)
+	}
+	if received.Header.Get("X-Hop") != "" {
+		t.Error("hop-by-hop header should not be forwarded")
+	}
+
+	service = &dao.ServiceDetial{
+		Info:        &dao.Serviceinfo{ServiceName: "forward_no_node_test"},
+		LoadBalance: &dao.LoadBalance{IpList: upstreamAddr, ForbidList: upstreamAddr},
+	}
+	if resp, body := do(); resp.StatusCode != http.StatusServiceUnavailable || errno(body) != 2002 {
+		t.Errorf("no node got %d %q", resp.StatusCode, body)
+	}
+
+	service = &dao.ServiceDetial{
+		Info:        &dao.Serviceinfo{ServiceName: "forward_down_test"},
+		LoadBalance: &dao.LoadBalance{IpList: closedAddr},
+	}
+	if resp, body := do(); resp.StatusCode != http.StatusBadGateway || errno(body) != 2003 {
+		t.Errorf("unreachable node got %d %q", resp.StatusCode, body)
+	}
+}
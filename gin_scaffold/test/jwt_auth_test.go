@@ -0,0 +1,101 @@
+package test
+
+import (
+	"encoding/json"
+	"gin_scaffold/dao"
+	"gin_scaffold/http_proxy_middleware"
+	"gin_scaffold/http_proxy_router"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setTestApps(apps ...*dao.App) {
+	appMap := map[string]*dao.App{}
+	for _, app := range apps {
+		appMap[app.AppID] = app
+	}
+	dao.AppManagerHandler.Locker.Lock()
+	dao.AppManagerHandler.AppMap = appMap
+	dao.AppManagerHandler.AppSlice = apps
+	dao.AppManagerHandler.Locker.Unlock()
+}
+
+func requestToken(router http.Handler, appID string, secret string) (int, string) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	req := httptest.NewRequest(http.MethodPost, "/oauth/tokens", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(appID, secret)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp := struct {
+		Data struct {
+			AccessToken string `json:"access_token"`
+		} `json:"data"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp.Data.AccessToken
+}
+
+func TestJwtAuthToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setTestApps(&dao.App{ID: 1, AppID: "app_jwt", Secret: "secret_jwt"})
+	defer setTestApps()
+
+	tokenRouter := http_proxy_router.InitRouter()
+	if code, token := requestToken(tokenRouter, "app_jwt", "wrong"); code != http.StatusUnauthorized || token != "" {
+		t.Fatalf("wrong secret got status %d", code)
+	}
+	code, token := requestToken(tokenRouter, "app_jwt", "secret_jwt")
+	if code != http.StatusOK || token == "" {
+		t.Fatalf("token request got status %d", code)
+	}
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("service", &dao.ServiceDetial{
+			Info:          &dao.Serviceinfo{ServiceName: "jwt_test"},
+			AccessControl: &dao.AcccessControll{OpenAuth: 1},
+		})
+		c.Next()
+	}, http_proxy_middleware.HTTPJwtAuthTokenMiddleware())
+	router.NoRoute(func(c *gin.Context) {
+		app, _ := c.Get("app")
+		c.String(http.StatusOK, app.(*dao.App).AppID)
+	})
+	cases := []struct {
+		auth string
+		code int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer bad.token.value", http.StatusUnauthorized},
+		{"Bearer " + token, http.StatusOK},
+	}
+	for _, item := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if item.auth != "" {
+			req.Header.Set("Authorization", item.auth)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != item.code {
+			t.Errorf("auth %q got status %d want %d", item.auth, w.Code, item.code)
+		}
+		if item.code == http.StatusOK && w.Body.String() != "app_jwt" {
+			t.Errorf("app in context got %q", w.Body.String())
+		}
+	}
+	//租户删除后token失效
+	setTestApps()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("deleted app got status %d", w.Code)
+	}
+}
@@ -0,0 +1,75 @@
+package test
+
+import (
+	"encoding/json"
+	"gin_scaffold/dao"
+	"gin_scaffold/http_proxy_middleware"
+	"gin_scaffold/public"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newAppFlowLimitRouter(app *dao.App) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("app", app)
+		c.Next()
+	}, http_proxy_middleware.HTTPJwtFlowLimitMiddleware())
+	router.NoRoute(func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return router
+}
+
+func serveErrno(router http.Handler) (int, int) {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	resp := struct {
+		Errno int `json:"errno"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp.Errno
+}
+
+func TestHTTPJwtFlowLimitQps(t *testing.T) {
+	router := newAppFlowLimitRouter(&dao.App{AppID: "app_qps_test", Qps: 1})
+	if code, _ := serveErrno(router); code != http.StatusOK {
+		t.Fatalf("first request got status %d", code)
+	}
+	if code, errno := serveErrno(router); code != http.StatusTooManyRequests || errno != 2012 {
+		t.Errorf("second request got status %d errno %d", code, errno)
+	}
+}
+
+func TestHTTPJwtFlowLimitQpd(t *testing.T) {
+	router := newAppFlowLimitRouter(&dao.App{AppID: "app_qpd_test", Qpd: 2})
+	//redis不可用时按流量统计中间件计入租户计数器的当日请求量判断
+	counter, _ := public.FlowCounterHandler.GetCounter(public.FlowAppPrefix + "app_qpd_test")
+	for i := 0; i < 2; i++ {
+		if code, _ := serveErrno(router); code != http.StatusOK {
+			t.Fatalf("request %d got status %d", i, code)
+		}
+		counter.Increase()
+	}
+	code, errno := serveErrno(router)
+	if code != http.StatusTooManyRequests || errno != 2013 {
+		t.Errorf("over quota got status %d errno %d", code, errno)
+	}
+}
+
+// 先检查qps，被qps拒绝的请求不占用日配额
+func TestHTTPJwtFlowLimitQpsBeforeQpd(t *testing.T) {
+	router := newAppFlowLimitRouter(&dao.App{AppID: "app_qps_qpd_test", Qps: 1, Qpd: 1})
+	counter, _ := public.FlowCounterHandler.GetCounter(public.FlowAppPrefix + "app_qps_qpd_test")
+	counter.Increase()
+	if code, errno := serveErrno(router); code != http.StatusTooManyRequests || errno != 2013 {
+		t.Errorf("first request got status %d errno %d", code, errno)
+	}
+	if code, errno := serveErrno(router); code != http.StatusTooManyRequests || errno != 2012 {
+		t.Errorf("second request got status %d errno %d", code, errno)
+	}
+}
@@ -0,0 +1,200 @@
+package test
+
+import (
+	"gin_scaffold/dao"
+	"gin_scaffold/reverse_proxy/load_balance"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRoundRobinBalance(t *testing.T) {
+	lb := load_balance.LoadBalanceFactory(load_balance.LbRoundRobin)
+	lb.Add("127.0.0.1:2003")
+	lb.Add("127.0.0.1:2004")
+	want := []string{"127.0.0.1:2003", "127.0.0.1:2004", "127.0.0.1:2003"}
+	for _, w := range want {
+		if addr, _ := lb.Get(""); addr != w {
+			t.Errorf("round robin got %s want %s", addr, w)
+		}
+	}
+}
+
+func TestWeightRoundRobinBalance(t *testing.T) {
+	lb := load_balance.LoadBalanceFactory(load_balance.LbWeightRoundRobin)
+	lb.Add("a", "4")
+	lb.Add("b", "3")
+	lb.Add("c", "2")
+	//平滑加权轮询的固定序列
+	want := []string{"a", "b", "c", "a", "b", "a", "c", "b", "a"}
+	for i, w := range want {
+		if addr, _ := lb.Get(""); addr != w {
+			t.Errorf("weight round robin step %d got %s want %s", i, addr, w)
+		}
+	}
+}
+
+func TestConsistentHashBalance(t *testing.T) {
+	lb := load_balance.LoadBalanceFactory(load_balance.LbConsistentHash)
+	lb.Add("127.0.0.1:2003")
+	lb.Add("127.0.0.1:2004")
+	lb.Add("127.0.0.1:2005")
+	first, err := lb.Get("192.168.1.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if addr, _ := lb.Get("192.168.1.10"); addr != first {
+			t.Errorf("consistent hash got %s want %s", addr, first)
+		}
+	}
+}
+
+func TestEmptyBalance(t *testing.T) {
+	for _, lbType := range []load_balance.LbType{
+		load_balance.LbRoundRobin,
+		load_balance.LbWeightRoundRobin,
+		load_balance.LbRandom,
+		load_balance.LbConsistentHash,
+	} {
+		if _, err := load_balance.LoadBalanceFactory(lbType).Get("key"); err == nil {
+			t.Errorf("lb type %d should fail without nodes", lbType)
+		}
+	}
+}
+
+func TestNewLoadBalanceByModel(t *testing.T) {
+	conf := &dao.LoadBalance{
+		RoundType:  int(load_balance.LbRoundRobin),
+		IpList:     "127.0.0.1:2003,127.0.0.1:2004,127.0.0.1:2005",
+		WeightList: "50,50,50",
+		ForbidList: "127.0.0.1:2004",
+	}
+	lb, err := dao.NewLoadBalanceByModel(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		if addr, _ := lb.Get(""); addr == "127.0.0.1:2004" {
+			t.Errorf("forbidden node %s selected", addr)
+		}
+	}
+}
+
+func TestOutlierDetector(t *testing.T) {
+	conf := load_balance.OutlierConf{
+		ConsecutiveErrors:  2,
+		BaseEjectionTime:   50 * time.Millisecond,
+		MaxEjectionPercent: 50,
+	}
+	detector := load_balance.NewOutlierDetector([]string{"a", "b"}, conf, nil)
+	defer detector.Stop()
+	detector.Report("a", true)
+	if detector.IsEjected("a") {
+		t.Errorf("node a ejected before reaching consecutive errors")
+	}
+	detector.Report("a", true)
+	if !detector.IsEjected("a") {
+		t.Errorf("node a should be ejected")
+	}
+	//最多摘除50%的节点
+	detector.Report("b", true)
+	detector.Report("b", true)
+	if detector.IsEjected("b") {
+		t.Errorf("node b ejected beyond max ejection percent")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if detector.IsEjected("a") {
+		t.Errorf("node a should be released after ejection time")
+	}
+}
+
+// 节点连续失败fall次后标记为down，恢复后连续成功rise次标记为up
+func TestHealthCheckerDownUp(t *testing.T) {
+	var healthy int32 = 1
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer upstream.Close()
+	addr := strings.TrimPrefix(upstream.URL, "http://")
+	changes := make(chan []string, 10)
+	checker := load_balance.NewHealthChecker([]string{addr}, load_balance.HealthCheckConf{
+		Method:   load_balance.CheckMethodHttp,
+		Interval: 20 * time.Millisecond,
+		Rise:     2,
+		Fall:     2,
+	}, func(h *load_balance.HealthChecker, changed bool) {
+		if changed {
+			changes <- h.HealthyNodes()
+		}
+	})
+	checker.Start()
+	defer checker.Stop()
+	wait := func() []string {
+		select {
+		case nodes := <-changes:
+			return nodes
+		case <-time.After(time.Second):
+			t.Fatal("health state did not change in time")
+		}
+		return nil
+	}
+
+	atomic.StoreInt32(&healthy, 0)
+	if nodes := wait(); len(nodes) != 0 {
+		t.Fatalf("node should be down, healthy nodes %v", nodes)
+	}
+	status := checker.NodeStatusList()[0]
+	if status.Healthy || status.LastError == "" {
+		t.Errorf("down node status %+v", status)
+	}
+	atomic.StoreInt32(&healthy, 1)
+	if nodes := wait(); len(nodes) != 1 || nodes[0] != addr {
+		t.Fatalf("node should be up, healthy nodes %v", nodes)
+	}
+}
+
+// 服务加载后立即开始健康检查，不等待首次请求
+func TestLoadBalancerStartsOnServiceChange(t *testing.T) {
+	var checks int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&checks, 1)
+	}))
+	defer upstream.Close()
+	service := &dao.ServiceDetial{
+		Info: &dao.Serviceinfo{ServiceName: "health_check_start_test"},
+		LoadBalance: &dao.LoadBalance{
+			IpList:        strings.TrimPrefix(upstream.URL, "http://"),
+			CheckMethod:   load_balance.CheckMethodHttp,
+			CheckInterval: 60,
+		},
+	}
+	dao.LoadBalancerHandler.OnServiceChange(&dao.ServiceChange{Added: []*dao.ServiceDetial{service}})
+	defer dao.LoadBalancerHandler.OnServiceChange(&dao.ServiceChange{Removed: []*dao.ServiceDetial{service}})
+	waitFor(t, func() bool {
+		return atomic.LoadInt32(&checks) > 0
+	})
+	dao.LoadBalancerHandler.Locker.RLock()
+	_, ok := dao.LoadBalancerHandler.LoadBalanceMap[service.Info.ServiceName]
+	dao.LoadBalancerHandler.Locker.RUnlock()
+	if !ok {
+		t.Error("load balancer should be built on service change")
+	}
+}
+
+// redis不可用时详情页按ip_list展示节点
+func TestDefaultNodeHealth(t *testing.T) {
+	lb := &dao.LoadBalance{IpList: "127.0.0.1:2003,127.0.0.1:2004"}
+	list, err := lb.DefaultNodeHealth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Addr != "127.0.0.1:2003" || !list[0].Healthy || !list[1].Healthy {
+		t.Errorf("default node health %+v", list)
+	}
+}
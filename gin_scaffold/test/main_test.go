@@ -0,0 +1,19 @@
+package test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/e421083458/golang_common/lib"
+)
+
+// 只加载配置文件，不初始化mysql、redis连接
+func TestMain(m *testing.M) {
+	lib.ParseConfPath("../conf/dev/")
+	if err := lib.InitViperConf(); err != nil {
+		panic(err)
+	}
+	//配置文件中不提供jwt密钥，测试时单独设置
+	lib.ViperConfMap["proxy"].Set("jwt.sign_key", "test_jwt_sign_key")
+	os.Exit(m.Run())
+}
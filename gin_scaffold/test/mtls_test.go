@@ -0,0 +1,212 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"gin_scaffold/dao"
+	"gin_scaffold/http_proxy_middleware"
+	"gin_scaffold/public"
+	"gin_scaffold/reverse_proxy"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type testIssuer struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// 签发证书，parent为空时生成自签名CA
+func issueTestCert(t *testing.T, parent *testIssuer, cn string, usage x509.ExtKeyUsage, ips ...net.IP) (*testIssuer, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"gateway"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		IPAddresses:  ips,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	return &testIssuer{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestHTTPClientCertMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ca, _ := issueTestCert(t, nil, "test ca", x509.ExtKeyUsageAny)
+	otherCa, _ := issueTestCert(t, nil, "other ca", x509.ExtKeyUsageAny)
+	appCert, _ := issueTestCert(t, ca, "app_mtls", x509.ExtKeyUsageClientAuth)
+	subjectCert, _ := issueTestCert(t, ca, "anything", x509.ExtKeyUsageClientAuth)
+	unknownCert, _ := issueTestCert(t, ca, "app_unknown", x509.ExtKeyUsageClientAuth)
+	serverCert, _ := issueTestCert(t, ca, "app_mtls", x509.ExtKeyUsageServerAuth)
+	foreignCert, _ := issueTestCert(t, otherCa, "app_mtls", x509.ExtKeyUsageClientAuth)
+	setTestApps(
+		&dao.App{ID: 1, AppID: "app_mtls"},
+		&dao.App{ID: 2, AppID: "app_subject", CertSubject: subjectCert.cert.Subject.String()},
+	)
+	defer setTestApps()
+
+	newRouter := func(access *dao.AcccessControll) *gin.Engine {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("service", &dao.ServiceDetial{
+				Info:          &dao.Serviceinfo{ServiceName: "mtls_test"},
+				AccessControl: access,
+			})
+			c.Next()
+		}, http_proxy_middleware.HTTPClientCertMiddleware())
+		router.NoRoute(func(c *gin.Context) {
+			appID := ""
+			if app, ok := c.Get("app"); ok {
+				appID = app.(*dao.App).AppID
+			}
+			c.String(http.StatusOK, appID)
+		})
+		return router
+	}
+	serve := func(router http.Handler, peer *testIssuer, https bool) (int, int, string) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if https {
+			req.TLS = &tls.ConnectionState{}
+			if peer != nil {
+				req.TLS.PeerCertificates = []*x509.Certificate{peer.cert}
+			}
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		resp := struct {
+			Errno int `json:"errno"`
+		}{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp.Errno, w.Body.String()
+	}
+
+	verifyOnly := newRouter(&dao.AcccessControll{ClientCa: string(ca.pem)})
+	authRouter := newRouter(&dao.AcccessControll{ClientCa: string(ca.pem), OpenAuth: 1, AuthType: public.AuthTypeClientCert})
+	cases := []struct {
+		name   string
+		router http.Handler
+		peer   *testIssuer
+		https  bool
+		code   int
+		errno  int
+		appID  string
+	}{
+		{"no ca configured", newRouter(&dao.AcccessControll{}), nil, false, http.StatusOK, 0, ""},
+		{"cert auth without ca", newRouter(&dao.AcccessControll{OpenAuth: 1, AuthType: public.AuthTypeClientCert}), appCert, true, http.StatusForbidden, 2023, ""},
+		{"plain http", verifyOnly, nil, false, http.StatusUnauthorized, 2023, ""},
+		{"no certificate", verifyOnly, nil, true, http.StatusUnauthorized, 2023, ""},
+		{"other ca", verifyOnly, foreignCert, true, http.StatusUnauthorized, 2023, ""},
+		{"server usage", verifyOnly, serverCert, true, http.StatusUnauthorized, 2023, ""},
+		{"verify only", verifyOnly, unknownCert, true, http.StatusOK, 0, ""},
+		{"cn maps to app", authRouter, appCert, true, http.StatusOK, 0, "app_mtls"},
+		{"subject maps to app", authRouter, subjectCert, true, http.StatusOK, 0, "app_subject"},
+		{"unknown app", authRouter, unknownCert, true, http.StatusForbidden, 2024, ""},
+	}
+	for _, item := range cases {
+		code, errno, body := serve(item.router, item.peer, item.https)
+		if code != item.code || errno != item.errno || (item.appID != "" && body != item.appID) {
+			t.Errorf("%s: got status %d errno %d body %s", item.name, code, errno, body)
+		}
+	}
+}
+
+func TestUpstreamMutualTLS(t *testing.T) {
+	ca, _ := issueTestCert(t, nil, "test ca", x509.ExtKeyUsageAny)
+	server, serverKey := issueTestCert(t, ca, "upstream", x509.ExtKeyUsageServerAuth, net.ParseIP("127.0.0.1"))
+	client, clientKey := issueTestCert(t, ca, "gateway", x509.ExtKeyUsageClientAuth)
+	serverPair, err := tls.X509KeyPair(server.pem, serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	upstream.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverPair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    x509.NewCertPool(),
+	}
+	upstream.TLS.ClientCAs.AddCert(ca.cert)
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	newService := func(withClientCert bool) *dao.ServiceDetial {
+		lb := &dao.LoadBalance{UpstreamTls: 1, UpstreamCaCert: string(ca.pem)}
+		if withClientCert {
+			lb.UpstreamClientCert, lb.UpstreamClientKey = string(client.pem), string(clientKey)
+		}
+		return &dao.ServiceDetial{Info: &dao.Serviceinfo{ServiceName: "upstream_mtls_test"}, LoadBalance: lb}
+	}
+	get := func(service *dao.ServiceDetial) (string, error) {
+		trans, err := reverse_proxy.GetUpstreamTransport(service)
+		if err != nil {
+			return "", err
+		}
+		resp, err := (&http.Client{Transport: trans}).Get(upstream.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body := make([]byte, 64)
+		n, _ := resp.Body.Read(body)
+		return string(body[:n]), nil
+	}
+	if body, err := get(newService(true)); err != nil || body != "gateway" {
+		t.Fatalf("mutual tls got %q, %v", body, err)
+	}
+	if _, err := get(newService(false)); err == nil {
+		t.Error("upstream requiring client certificate should reject gateway without one")
+	}
+	//同样的证书配置重复加载后复用同一个transport
+	first, _ := reverse_proxy.GetUpstreamTransport(newService(true))
+	second, _ := reverse_proxy.GetUpstreamTransport(newService(true))
+	if first != second {
+		t.Error("transport should be reused when tls config is unchanged")
+	}
+	//CA不匹配时校验下游证书失败
+	otherCa, _ := issueTestCert(t, nil, "other ca", x509.ExtKeyUsageAny)
+	service := newService(true)
+	service.LoadBalance.UpstreamCaCert = string(otherCa.pem)
+	if _, err := get(service); err == nil {
+		t.Error("upstream certificate from unknown ca should fail")
+	}
+	//tls配置变化时替换transport
+	if trans, _ := reverse_proxy.GetUpstreamTransport(newService(false)); trans == first {
+		t.Error("transport should be replaced when tls config changes")
+	}
+}
@@ -0,0 +1,175 @@
+package test
+
+import (
+	"gin_scaffold/dao"
+	"gin_scaffold/http_proxy_middleware"
+	"gin_scaffold/reverse_proxy"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	if _, err := dao.ParseRetryOn("502,timeout"); err == nil {
+		t.Error("unknown retry condition should be rejected")
+	}
+	policy := reverse_proxy.NewRetryPolicy(&dao.LoadBalance{RetryMaxAttempts: 3, RetryOn: "503,connect_error", RetryBackoff: 10, RetryMaxBackoff: 30})
+	if !policy.ShouldRetry(&http.Response{StatusCode: http.StatusServiceUnavailable}, nil) {
+		t.Error("503 should be retried")
+	}
+	if policy.ShouldRetry(&http.Response{StatusCode: http.StatusBadGateway}, nil) {
+		t.Error("502 is not in retry_on")
+	}
+	if !policy.ShouldRetry(nil, &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}) {
+		t.Error("connect error should be retried")
+	}
+	if policy.ShouldRetry(nil, &net.OpError{Op: "read", Err: syscall.ECONNRESET}) {
+		t.Error("reset is not in retry_on")
+	}
+	for attempt := 1; attempt <= 5; attempt++ {
+		if backoff := policy.BackoffDuration(attempt); backoff <= 0 || backoff > 30*time.Millisecond {
+			t.Errorf("attempt %d backoff %v out of range", attempt, backoff)
+		}
+	}
+	post := httptest.NewRequest(http.MethodPost, "/", nil)
+	if policy.AllowMethod(post) {
+		t.Error("post should not be retried by default")
+	}
+	post.Header.Set("Idempotency-Key", "abc")
+	if !policy.AllowMethod(post) {
+		t.Error("post with idempotency key should be retried")
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	policy := reverse_proxy.NewRetryPolicy(&dao.LoadBalance{RetryMaxAttempts: 2})
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if policy.AllowRetry("retry_budget_test") {
+			allowed++
+		}
+	}
+	//没有流量时只允许min_retries_per_second次重试
+	if allowed == 0 || allowed >= 10 {
+		t.Errorf("retry budget allowed %d of 10", allowed)
+	}
+}
+
+// 失败节点的请求换到其他节点重试，非幂等请求默认不重试
+func TestHTTPRetry(t *testing.T) {
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(append([]byte("good"), body...))
+	}))
+	defer good.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closedAddr := strings.TrimPrefix(closed.URL, "http://")
+	closed.Close()
+	goodAddr := strings.TrimPrefix(good.URL, "http://")
+
+	var service *dao.ServiceDetial
+	newService := func(name, firstAddr string, nonIdempotent int) *dao.ServiceDetial {
+		return &dao.ServiceDetial{
+			Info: &dao.Serviceinfo{ServiceName: name},
+			LoadBalance: &dao.LoadBalance{
+				IpList:             firstAddr + "," + goodAddr,
+				RetryMaxAttempts:   2,
+				RetryNonIdempotent: nonIdempotent,
+				RetryBackoff:       1,
+			},
+		}
+	}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("service", service)
+		c.Next()
+	}, http_proxy_middleware.HTTPReverseProxyMiddleware())
+	proxy := httptest.NewServer(router)
+	defer proxy.Close()
+	do := func(method string) (int, string) {
+		var reqBody io.Reader
+		if method == http.MethodPost {
+			reqBody = strings.NewReader("!")
+		}
+		req, _ := http.NewRequest(method, proxy.URL, reqBody)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	service = newService("retry_status_test", strings.TrimPrefix(bad.URL, "http://"), 0)
+	for i := 0; i < 2; i++ {
+		if code, body := do(http.MethodGet); code != http.StatusOK || body != "good" {
+			t.Errorf("get %d got %d %q", i, code, body)
+		}
+	}
+	failed := 0
+	for i := 0; i < 2; i++ {
+		if code, _ := do(http.MethodPost); code == http.StatusServiceUnavailable {
+			failed++
+		}
+	}
+	if failed != 1 {
+		t.Errorf("post should not be retried, %d of 2 failed", failed)
+	}
+
+	service = newService("retry_non_idempotent_test", strings.TrimPrefix(bad.URL, "http://"), 1)
+	for i := 0; i < 2; i++ {
+		if code, body := do(http.MethodPost); code != http.StatusOK || body != "good!" {
+			t.Errorf("post %d with body replay got %d %q", i, code, body)
+		}
+	}
+
+	service = newService("retry_connect_test", closedAddr, 0)
+	for i := 0; i < 2; i++ {
+		if code, body := do(http.MethodGet); code != http.StatusOK || body != "good" {
+			t.Errorf("get %d after connect error got %d %q", i, code, body)
+		}
+	}
+}
+
+// 没有其他节点可重试时不消耗重试预算
+func TestRetryBudgetSingleNode(t *testing.T) {
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer bad.Close()
+	service := &dao.ServiceDetial{
+		Info:        &dao.Serviceinfo{ServiceName: "retry_single_node_test"},
+		LoadBalance: &dao.LoadBalance{IpList: strings.TrimPrefix(bad.URL, "http://"), RetryMaxAttempts: 2},
+	}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("service", service)
+		c.Next()
+	}, http_proxy_middleware.HTTPReverseProxyMiddleware())
+	proxy := httptest.NewServer(router)
+	defer proxy.Close()
+	for i := 0; i < 10; i++ {
+		resp, err := http.Get(proxy.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if !reverse_proxy.NewRetryPolicy(service.LoadBalance).AllowRetry(service.Info.ServiceName) {
+		t.Error("requests without another node should not consume retry budget")
+	}
+}
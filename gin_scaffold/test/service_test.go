@@ -0,0 +1,163 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"gin_scaffold/controller"
+	"gin_scaffold/middleware"
+	"gin_scaffold/public"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/e421083458/golang_common/lib"
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// 记录执行的sql，查询均返回空结果，用于在没有mysql时测试后台接口
+type recordDB struct {
+	sync.Mutex
+	lastID int64
+	execs  []recordExec
+}
+
+type recordExec struct {
+	query string
+	args  []driver.NamedValue
+}
+
+func (db *recordDB) Connect(ctx context.Context) (driver.Conn, error) {
+	return &recordConn{db: db}, nil
+}
+
+func (db *recordDB) Driver() driver.Driver {
+	return nil
+}
+
+// 按列名取出insert语句中的值
+func (db *recordDB) insertValue(table string, column string) (interface{}, bool) {
+	db.Lock()
+	defer db.Unlock()
+	for _, exec := range db.execs {
+		if !strings.HasPrefix(exec.query, "INSERT INTO `"+table+"`") {
+			continue
+		}
+		start := strings.Index(exec.query, "(")
+		end := strings.Index(exec.query, ")")
+		for i, name := range strings.Split(exec.query[start+1:end], ",") {
+			if name == "`"+column+"`" && i < len(exec.args) {
+				return exec.args[i].Value, true
+			}
+		}
+	}
+	return nil, false
+}
+
+type recordConn struct {
+	db *recordDB
+}
+
+func (c *recordConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, driver.ErrSkip
+}
+
+func (c *recordConn) Close() error {
+	return nil
+}
+
+func (c *recordConn) Begin() (driver.Tx, error) {
+	return c, nil
+}
+
+func (c *recordConn) Commit() error {
+	return nil
+}
+
+func (c *recordConn) Rollback() error {
+	return nil
+}
+
+func (c *recordConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.Lock()
+	defer c.db.Unlock()
+	c.db.lastID++
+	c.db.execs = append(c.db.execs, recordExec{query: query, args: args})
+	return recordResult(c.db.lastID), nil
+}
+
+func (c *recordConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return recordRows{}, nil
+}
+
+type recordResult int64
+
+func (r recordResult) LastInsertId() (int64, error) {
+	return int64(r), nil
+}
+
+func (r recordResult) RowsAffected() (int64, error) {
+	return 1, nil
+}
+
+type recordRows struct{}
+
+func (recordRows) Columns() []string {
+	return []string{}
+}
+
+func (recordRows) Close() error {
+	return nil
+}
+
+func (recordRows) Next(dest []driver.Value) error {
+	return io.EOF
+}
+
+func setTestGormPool(t *testing.T, db *recordDB) {
+	pool, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      sql.OpenDB(db),
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := lib.GORMMapPool
+	lib.GORMMapPool = map[string]*gorm.DB{"default": pool}
+	t.Cleanup(func() {
+		lib.GORMMapPool = old
+	})
+}
+
+// 后台创建http服务时保存接入类型，域名接入才能被代理和证书管理使用
+func TestCreateHTTPServiceDomainRule(t *testing.T) {
+	db := &recordDB{}
+	setTestGormPool(t, db)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.TranslationMiddleware())
+	controller.ServiceRegister(router.Group("/service"))
+
+	body := `{"service_name":"domain_rule_0service","service_desc":"domain rule","rule_type":1,"rule":"www.example.com","ip_list":"127.0.0.1:2003","weightlist":"1"}`
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/service/create_http_service", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	resp := &middleware.Response{}
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil || resp.ErrorCode != middleware.SuccessCode {
+		t.Fatalf("create got %s err %v", w.Body.String(), err)
+	}
+	if ruleType, ok := db.insertValue("gateway_service_http_rule", "rule_type"); !ok || ruleType != int64(public.HTTPRuleTypeDomain) {
+		t.Errorf("saved rule_type %v, want domain rule", ruleType)
+	}
+	if rule, _ := db.insertValue("gateway_service_http_rule", "rule"); rule != "www.example.com" {
+		t.Errorf("saved rule %v", rule)
+	}
+}
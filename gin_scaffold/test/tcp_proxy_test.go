@@ -0,0 +1,68 @@
+package test
+
+import (
+	"bufio"
+	"gin_scaffold/dao"
+	"gin_scaffold/reverse_proxy"
+	"gin_scaffold/tcp_server"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTcpReverseProxy(t *testing.T) {
+	//下游echo服务
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	service := &dao.ServiceDetial{
+		Info:        &dao.Serviceinfo{ServiceName: "tcp_test"},
+		LoadBalance: &dao.LoadBalance{IpList: upstream.Addr().String(), WeightList: "1"},
+	}
+	lb, err := dao.NewLoadBalanceByModel(service.LoadBalance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &tcp_server.TcpServer{Handler: reverse_proxy.NewTcpLoadBalanceReverseProxy(service, lb)}
+	go server.Serve(ln)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	if _, err := conn.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "hello\n" {
+		t.Fatalf("got %q err %v", line, err)
+	}
+
+	//关闭后已建立的连接也应断开
+	server.Close()
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("connection still open after server close")
+	}
+}
@@ -0,0 +1,125 @@
+package test
+
+import (
+	"gin_scaffold/dao"
+	"gin_scaffold/reverse_proxy"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTransportTestService(name string, lb *dao.LoadBalance) *dao.ServiceDetial {
+	return &dao.ServiceDetial{Info: &dao.Serviceinfo{ServiceName: name}, LoadBalance: lb}
+}
+
+func TestUpstreamTransportStats(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+	service := newTransportTestService("transport_stats_test", &dao.LoadBalance{})
+	trans, err := reverse_proxy.TransportManagerHandler.GetTransport(service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: trans}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if resp, err := client.Get(upstream.URL + "/slow"); err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}()
+	waitFor(t, func() bool { return trans.Stats().Active == 1 })
+	close(release)
+	<-done
+	stats := trans.Stats()
+	if stats.Active != 0 || stats.Idle != 1 || stats.Requests != 1 {
+		t.Errorf("after request got active %d idle %d requests %d", stats.Active, stats.Idle, stats.Requests)
+	}
+	//建立连接失败计入dial_errors
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	closedAddr := listener.Addr().String()
+	listener.Close()
+	if _, err := client.Get("http://" + closedAddr); err == nil {
+		t.Fatal("request to closed port should fail")
+	}
+	if stats := trans.Stats(); stats.DialErrors != 1 || stats.Active != 0 {
+		t.Errorf("after dial error got dial_errors %d active %d", stats.DialErrors, stats.Active)
+	}
+	if stats := reverse_proxy.TransportManagerHandler.StatsMap()["transport_stats_test"]; stats == nil || stats.Requests != 2 {
+		t.Error("stats map should include the service transport")
+	}
+}
+
+func TestUpstreamTransportConf(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(1500 * time.Millisecond)
+	}))
+	defer upstream.Close()
+	service := newTransportTestService("transport_conf_test", &dao.LoadBalance{UpstreamHeaderTimeout: 1})
+	trans, err := reverse_proxy.TransportManagerHandler.GetTransport(service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&http.Client{Transport: trans}).Get(upstream.URL); err == nil {
+		t.Error("upstream_header_timeout should abort slow upstream")
+	}
+	same, _ := reverse_proxy.TransportManagerHandler.GetTransport(newTransportTestService("transport_conf_test", &dao.LoadBalance{UpstreamHeaderTimeout: 1}))
+	if same != trans {
+		t.Error("transport should be reused when config is unchanged")
+	}
+}
+
+// 配置变化后新请求使用新的transport，旧transport的连接被释放
+func TestUpstreamTransportSwap(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+	get := func(trans http.RoundTripper) {
+		resp, err := (&http.Client{Transport: trans}).Get(upstream.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	old, _ := reverse_proxy.TransportManagerHandler.GetTransport(newTransportTestService("transport_swap_test", &dao.LoadBalance{UpstreamMaxIdle: 10}))
+	get(old)
+	if old.Stats().Idle != 1 {
+		t.Fatal("old transport should keep an idle connection")
+	}
+	updated := newTransportTestService("transport_swap_test", &dao.LoadBalance{UpstreamMaxIdle: 20})
+	trans, _ := reverse_proxy.TransportManagerHandler.GetTransport(updated)
+	if trans == old {
+		t.Fatal("transport should be replaced when config changes")
+	}
+	get(trans)
+	waitFor(t, func() bool { return old.Stats().Idle == 0 })
+	//服务删除后释放连接池
+	reverse_proxy.TransportManagerHandler.OnServiceChange(&dao.ServiceChange{Removed: []*dao.ServiceDetial{updated}})
+	if _, ok := reverse_proxy.TransportManagerHandler.StatsMap()["transport_swap_test"]; ok {
+		t.Error("removed service should release its transport")
+	}
+	waitFor(t, func() bool { return trans.Stats().Idle == 0 })
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 50; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("condition not met in time")
+}
@@ -0,0 +1,89 @@
+package test
+
+import (
+	"bufio"
+	"gin_scaffold/dao"
+	"gin_scaffold/http_proxy_middleware"
+	"gin_scaffold/reverse_proxy"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestWebsocketReverseProxy(t *testing.T) {
+	//下游握手成功后原样回写收到的数据
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		buf.Flush()
+		io.Copy(conn, buf)
+	}))
+	defer upstream.Close()
+
+	service := &dao.ServiceDetial{
+		Info:        &dao.Serviceinfo{ServiceName: "websocket_test"},
+		HTTPRule:    &dao.HttpRule{NeedWebsocket: 1},
+		LoadBalance: &dao.LoadBalance{IpList: strings.TrimPrefix(upstream.URL, "http://"), WeightList: "1"},
+	}
+	lb, err := dao.NewLoadBalanceByModel(service.LoadBalance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		reverse_proxy.NewWebsocketLoadBalanceReverseProxy(c, service, lb, time.Second).ServeHTTP(c.Writer, c.Request)
+	})
+	proxy := httptest.NewServer(router)
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxy.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"))
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake got %v err %v", resp, err)
+	}
+	conn.Write([]byte("hello\n"))
+	if line, err := reader.ReadString('\n'); err != nil || line != "hello\n" {
+		t.Fatalf("got %q err %v", line, err)
+	}
+}
+
+func TestHTTPWebsocketMiddlewareDisabled(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	if !http_proxy_middleware.IsWebsocketRequest(req) {
+		t.Fatal("upgrade request not detected")
+	}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("service", &dao.ServiceDetial{
+			Info:     &dao.Serviceinfo{ServiceName: "websocket_test"},
+			HTTPRule: &dao.HttpRule{NeedWebsocket: 0},
+		})
+		c.Next()
+	}, http_proxy_middleware.HTTPWebsocketMiddleware())
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status got %d want %d", w.Code, http.StatusForbidden)
+	}
+}